@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/metadata"
+)
+
+// AAGUIDRegistry maps authenticator AAGUIDs to human-readable models.
+//
+// It is loaded from a local copy of the community passkey AAGUID listing
+// (https://github.com/passkeydeveloper/passkey-authenticator-aaguids), whose
+// entries carry a name plus optional light and dark icons:
+//
+//	{
+//	  "fbfc3007-154e-4ecc-8c0b-6e020557d7bd": {
+//	    "name": "iCloud Keychain",
+//	    "icon_dark": "data:image/svg+xml;base64,...",
+//	    "icon_light": "data:image/svg+xml;base64,..."
+//	  }
+//	}
+//
+// The registry is safe for concurrent use and can be reloaded in place (the
+// backend does so on SIGHUP). A nil *AAGUIDRegistry resolves nothing, so the
+// feature is optional.
+type AAGUIDRegistry struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]metadata.PassKeyAuthenticatorAAGUID // Keyed by normalized AAGUID
+	err     error                                          // Result of the last Reload
+}
+
+// NewAAGUIDRegistry loads the AAGUID listing at path.
+func NewAAGUIDRegistry(path string) (*AAGUIDRegistry, error) {
+	registry := &AAGUIDRegistry{path: path}
+	if err := registry.Reload(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Reload re-reads the listing from disk.
+//
+// On failure the previously loaded entries are kept, so a bad edit to the
+// file does not blank out authenticator names on a running server.
+func (r *AAGUIDRegistry) Reload() error {
+	entries, err := r.load()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	if err != nil {
+		return err
+	}
+	r.entries = entries
+	return nil
+}
+
+func (r *AAGUIDRegistry) load() (map[string]metadata.PassKeyAuthenticatorAAGUID, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("read AAGUID listing: %w", err)
+	}
+
+	var listing metadata.PasskeyAuthenticator
+	if err := json.Unmarshal(data, &listing); err != nil {
+		return nil, fmt.Errorf("parse AAGUID listing %s: %w", r.path, err)
+	}
+
+	entries := make(map[string]metadata.PassKeyAuthenticatorAAGUID, len(listing))
+	for aaguid, entry := range listing {
+		entries[normalizeAAGUID(aaguid)] = entry
+	}
+	return entries, nil
+}
+
+// Err returns the error of the last Reload, or nil if it succeeded.
+func (r *AAGUIDRegistry) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// Len returns the number of known authenticator models.
+func (r *AAGUIDRegistry) Len() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.entries)
+}
+
+// Lookup resolves an AAGUID in either hex or dashed UUID form.
+//
+// The all-zero AAGUID, which authenticators send when they decline to
+// identify themselves, never matches.
+func (r *AAGUIDRegistry) Lookup(aaguid string) (metadata.PassKeyAuthenticatorAAGUID, bool) {
+	if r == nil {
+		return metadata.PassKeyAuthenticatorAAGUID{}, false
+	}
+
+	key := normalizeAAGUID(aaguid)
+	if key == "" || strings.Trim(key, "0") == "" {
+		return metadata.PassKeyAuthenticatorAAGUID{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[key]
+	return entry, ok
+}
+
+// Annotate fills in authenticator model details on passkeys whose AAGUID is
+// known. Passkeys without a user-assigned nickname are also named after the
+// model, replacing the generic name from generatePasskeyName.
+func (r *AAGUIDRegistry) Annotate(passkeys []PasskeyInfo) {
+	for i := range passkeys {
+		entry, ok := r.Lookup(passkeys[i].AAGUID)
+		if !ok {
+			continue
+		}
+
+		passkeys[i].AuthenticatorName = entry.Name
+		passkeys[i].AuthenticatorIconLight = entry.IconLight
+		passkeys[i].AuthenticatorIconDark = entry.IconDark
+		if passkeys[i].Nickname == "" && entry.Name != "" {
+			passkeys[i].Name = entry.Name
+		}
+	}
+}
+
+// normalizeAAGUID reduces an AAGUID to lowercase hex without dashes so the
+// dashed keys of the listing match the hex values in PasskeyInfo.
+func normalizeAAGUID(aaguid string) string {
+	key := strings.ToLower(strings.ReplaceAll(aaguid, "-", ""))
+	if _, err := hex.DecodeString(key); err != nil {
+		return ""
+	}
+	return key
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testAAGUIDListing = `{
+  "fbfc3007-154e-4ecc-8c0b-6e020557d7bd": {"name": "iCloud Keychain", "icon_light": "light.svg", "icon_dark": "dark.svg"},
+  "00000000-0000-0000-0000-000000000000": {"name": "Anonymous"}
+}`
+
+func writeAAGUIDListing(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "aaguids.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAAGUIDRegistryLookup(t *testing.T) {
+	registry, err := NewAAGUIDRegistry(writeAAGUIDListing(t, testAAGUIDListing))
+	if err != nil {
+		t.Fatalf("NewAAGUIDRegistry: %v", err)
+	}
+
+	for _, aaguid := range []string{"fbfc3007-154e-4ecc-8c0b-6e020557d7bd", "FBFC3007154E4ECC8C0B6E020557D7BD"} {
+		if entry, ok := registry.Lookup(aaguid); !ok || entry.Name != "iCloud Keychain" {
+			t.Errorf("Lookup(%s) = %+v, %v", aaguid, entry, ok)
+		}
+	}
+	for _, aaguid := range []string{"00000000000000000000000000000000", "", "not-hex", "ffffffffffffffffffffffffffffffff"} {
+		if _, ok := registry.Lookup(aaguid); ok {
+			t.Errorf("Lookup(%q) matched", aaguid)
+		}
+	}
+
+	passkeys := []PasskeyInfo{
+		{AAGUID: "fbfc3007154e4ecc8c0b6e020557d7bd", Name: "Platform Passkey"},
+		{AAGUID: "fbfc3007154e4ecc8c0b6e020557d7bd", Name: "Mine", Nickname: "Mine"},
+		{Name: "Security Key"},
+	}
+	registry.Annotate(passkeys)
+	if passkeys[0].Name != "iCloud Keychain" || passkeys[0].AuthenticatorIconDark != "dark.svg" {
+		t.Errorf("unnamed passkey = %+v", passkeys[0])
+	}
+	if passkeys[1].Name != "Mine" || passkeys[1].AuthenticatorName != "iCloud Keychain" {
+		t.Errorf("nicknamed passkey = %+v", passkeys[1])
+	}
+	if passkeys[2].Name != "Security Key" || passkeys[2].AuthenticatorName != "" {
+		t.Errorf("unknown passkey = %+v", passkeys[2])
+	}
+}
+
+func TestAAGUIDRegistryReload(t *testing.T) {
+	path := writeAAGUIDListing(t, testAAGUIDListing)
+	registry, err := NewAAGUIDRegistry(path)
+	if err != nil {
+		t.Fatalf("NewAAGUIDRegistry: %v", err)
+	}
+
+	// A broken edit keeps the entries that were already loaded
+	os.WriteFile(path, []byte("{not json"), 0o600)
+	if err := registry.Reload(); err == nil {
+		t.Error("Reload accepted invalid JSON")
+	}
+	if registry.Len() != 2 {
+		t.Errorf("Len = %d after a failed reload, want 2", registry.Len())
+	}
+
+	os.WriteFile(path, []byte(`{}`), 0o600)
+	if err := registry.Reload(); err != nil || registry.Len() != 0 {
+		t.Errorf("Reload = %v, Len = %d", err, registry.Len())
+	}
+
+	var none *AAGUIDRegistry
+	if _, ok := none.Lookup("fbfc3007154e4ecc8c0b6e020557d7bd"); ok || none.Len() != 0 {
+		t.Error("nil registry resolved an AAGUID")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin checks that the request carries the configured admin token
+// as a bearer token.
+//
+// When no token is configured the admin API is disabled and 404 is written.
+// A missing or wrong token gets 401 ADMIN_REQUIRED. ok is false whenever a
+// response has already been written.
+func (app *App) requireAdmin(w http.ResponseWriter, r *http.Request) (ok bool) {
+	token := app.config.Admin.Token
+	if token == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	presented, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		app.writeAppError(w, ErrAdminRequired, http.StatusUnauthorized)
+		return false
+	}
+
+	addLogAttrs(r.Context(), slog.String("actor", "admin"))
+	return true
+}
+
+// handleAdminAudit queries the security audit log, newest first.
+//
+// Query: type (comma-separated), username, credentialId, outcome,
+// since/until (RFC 3339), limit (default 50, max 200), before (cursor)
+// Response: AuditPage (JSON)
+// HTTP Status: 200 (success), 400 (bad filter), 401 (no admin token)
+func (app *App) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.auditPage(filter)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to query audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleAdminAuditVerify recomputes the audit log's hash chain.
+//
+// Response: AuditVerification (JSON); valid is false and brokenAt names the
+// first bad event if anything was altered or removed
+// HTTP Status: 200 (checked, valid or not), 401 (no admin token)
+func (app *App) handleAdminAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	result, err := verifyAuditChain(app.store)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !result.Valid {
+		logger.ErrorContext(r.Context(), "Audit log hash chain is broken",
+			"broken_at", result.BrokenAt, "problem", result.Problem)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// Audit event types. They are stored, so existing values must never change.
+const (
+	auditRegistration        = "registration"             // New account with its first passkey
+	auditPasskeyAdded        = "passkey_added"            // Additional passkey on an existing account
+	auditLogin               = "login"                    // Login ceremony finished, successfully or not
+	auditDeletedCredential   = "deleted_credential_login" // Assertion from a credential removed from the account
+	auditCloneWarning        = "clone_warning"            // Sign counter did not increase
+	auditPasskeyDeleted      = "passkey_deleted"          // Owner removed a passkey
+	auditPasskeyReinstated   = "passkey_reinstated"       // Owner cleared a suspect or suspended passkey
+	auditAccountLocked       = "account_locked"           // Repeated failed assertions locked an account or credential
+	auditAccountUnlocked     = "account_unlocked"         // Lock lifted by a different credential or an admin
+	auditRecoveryCodes       = "recovery_codes_generated" // New set of recovery codes, replacing any old one
+	auditReauth              = "reauthenticated"          // Fresh user verification within a login session
+	auditTransactionApproval = "transaction_approval"     // Transaction payload approved with a passkey
+)
+
+// Audit event outcomes.
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditWarning = "warning" // Allowed, but worth a look
+)
+
+// Page sizes for audit queries.
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 200
+)
+
+// AuditEvent is one entry in the append-only security audit log.
+//
+// Events form a hash chain: PrevHash is the Hash of the event before, and
+// Hash covers every other field (see auditHash). Editing, removing or
+// reordering a stored event breaks the chain from that point on, which
+// verifyAuditChain reports. The chain makes tampering evident, it does not
+// prevent it: someone able to rewrite the whole log can rebuild the chain,
+// so keep a copy of the latest hash elsewhere when that matters.
+type AuditEvent struct {
+	ID           int64     `json:"id"`                     // Position in the log, starting at 1
+	Time         time.Time `json:"time"`                   // When the event was recorded (UTC)
+	Type         string    `json:"type"`                   // See the audit* type constants
+	Actor        string    `json:"actor,omitempty"`        // Who acted: a username, "admin", or empty if not authenticated
+	Username     string    `json:"username,omitempty"`     // Account the event concerns, if known
+	CredentialID string    `json:"credentialId,omitempty"` // Base64url credential ID, if any
+	IP           string    `json:"ip,omitempty"`           // Client IP
+	UserAgent    string    `json:"userAgent,omitempty"`    // Client User-Agent
+	Outcome      string    `json:"outcome"`                // success, failure or warning
+	Detail       string    `json:"detail,omitempty"`       // Error code or short reason
+	PrevHash     string    `json:"prevHash"`               // Hash of the previous event (empty for the first)
+	Hash         string    `json:"hash"`                   // Hex SHA-256 of this event
+}
+
+// seal assigns the event its place in the chain after the event with
+// prevID and prevHash (0 and "" for the first event). Stores call it while
+// holding whatever lock makes the append atomic.
+func (e *AuditEvent) seal(prevID int64, prevHash string) {
+	e.ID = prevID + 1
+	e.Time = e.Time.UTC()
+	e.PrevHash = prevHash
+	e.Hash = auditHash(e)
+}
+
+// auditHash returns the hex SHA-256 of the event's fields and PrevHash.
+//
+// The fields are hashed as a JSON array in a fixed order, so the result does
+// not depend on struct tags or on how a store encodes them. New fields must
+// be appended to the end.
+func auditHash(e *AuditEvent) string {
+	data, _ := json.Marshal([]interface{}{
+		e.ID, e.Time.UTC().Format(time.RFC3339Nano), e.Type, e.Actor, e.Username, e.CredentialID,
+		e.IP, e.UserAgent, e.Outcome, e.Detail, e.PrevHash,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilter selects audit events. Zero fields do not filter.
+type AuditFilter struct {
+	Types        []string  // Any of these event types
+	Username     string    // Account the event concerns
+	CredentialID string    // Base64url credential ID
+	Outcome      string    // success, failure or warning
+	Since        time.Time // At or after
+	Until        time.Time // Before
+	BeforeID     int64     // Page cursor: only events older than this ID
+	Limit        int       // Maximum number of events (0: no limit)
+}
+
+// matches reports whether e passes every filter except Limit.
+func (f AuditFilter) matches(e *AuditEvent) bool {
+	switch {
+	case len(f.Types) > 0 && !containsString(f.Types, e.Type):
+		return false
+	case f.Username != "" && e.Username != f.Username:
+		return false
+	case f.CredentialID != "" && e.CredentialID != f.CredentialID:
+		return false
+	case f.Outcome != "" && e.Outcome != f.Outcome:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	case f.BeforeID > 0 && e.ID >= f.BeforeID:
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditPage is one page of audit events, newest first.
+//
+// NextCursor is set when older events remain; pass it back as ?before= to
+// get the next page.
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// auditPage queries one page of events matching filter.
+func (app *App) auditPage(filter AuditFilter) (AuditPage, error) {
+	// Ask for one extra event to learn whether another page exists
+	limit := filter.Limit
+	filter.Limit = limit + 1
+	events, err := app.store.ListAuditEvents(filter)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	page := AuditPage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = strconv.FormatInt(page.Events[limit-1].ID, 10)
+	}
+	return page, nil
+}
+
+// parseAuditPaging reads ?limit= and ?before= into filter.
+func parseAuditPaging(r *http.Request, filter *AuditFilter, defaultLimit int) error {
+	query := r.URL.Query()
+
+	filter.Limit = defaultLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > auditMaxLimit {
+			return fmt.Errorf("limit must be between 1 and %d", auditMaxLimit)
+		}
+		filter.Limit = limit
+	}
+
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before < 1 {
+			return fmt.Errorf("invalid before cursor")
+		}
+		filter.BeforeID = before
+	}
+
+	return nil
+}
+
+// audit appends a security event about request r to the audit log, filling
+// in the time, client IP and User-Agent.
+//
+// A failed append is logged rather than returned: an unavailable audit log
+// must not lock users out of their accounts.
+func (app *App) audit(r *http.Request, event AuditEvent) {
+	event.Time = time.Now()
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	if err := app.store.AppendAuditEvent(&event); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to append audit event", "type", event.Type, "error", err)
+	}
+}
+
+// assertionFailure describes why a login assertion was rejected, for the
+// Detail of a failed login event.
+func assertionFailure(err error) string {
+	var protoErr *protocol.Error
+	if errors.As(err, &protoErr) && protoErr.Type != "" {
+		return protoErr.Type
+	}
+	return "verification_failed"
+}
+
+// handleSecurityActivity lists recent audit events for the logged-in user,
+// newest first: logins (including failed attempts), new and deleted
+// passkeys, clone warnings and attempts with deleted credentials.
+//
+// Query: limit (default 20, max 200), before (cursor from nextCursor)
+// Response: AuditPage (JSON)
+// HTTP Status: 200 (success), 400 (bad paging), 401 (not authenticated)
+func (app *App) handleSecurityActivity(w http.ResponseWriter, r *http.Request) {
+	username := app.getCurrentUser(r)
+	if username == "" {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+
+	filter := AuditFilter{Username: username}
+	if err := parseAuditPaging(r, &filter, 20); err != nil {
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.auditPage(filter)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to load security activity: %v", err), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// AuditVerification is the result of checking the audit hash chain.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`              // Every hash and link checked out
+	Events   int64  `json:"events"`             // Events checked
+	Head     string `json:"head,omitempty"`     // Hash of the newest event
+	BrokenAt int64  `json:"brokenAt,omitempty"` // ID of the first event found broken
+	Problem  string `json:"problem,omitempty"`  // What was wrong with it
+}
+
+// verifyAuditChain walks the whole audit log from the newest event back to
+// the first, recomputing each hash and checking it links to its
+// predecessor.
+func verifyAuditChain(store Store) (AuditVerification, error) {
+	const batch = 500
+	var result AuditVerification
+	var newer *AuditEvent // Event checked just before, one ID higher
+	filter := AuditFilter{Limit: batch}
+
+	for {
+		events, err := store.ListAuditEvents(filter)
+		if err != nil {
+			return result, err
+		}
+
+		for i := range events {
+			event := &events[i]
+			if result.Events == 0 {
+				result.Head = event.Hash
+			}
+			result.Events++
+
+			switch {
+			case auditHash(event) != event.Hash:
+				result.BrokenAt, result.Problem = event.ID, "hash does not match contents"
+			case newer != nil && newer.ID != event.ID+1:
+				result.BrokenAt, result.Problem = newer.ID, fmt.Sprintf("event %d is missing", newer.ID-1)
+			case newer != nil && newer.PrevHash != event.Hash:
+				result.BrokenAt, result.Problem = newer.ID, "previous hash does not match"
+			}
+			if result.BrokenAt != 0 {
+				return result, nil
+			}
+			newer = event
+		}
+
+		if len(events) < batch {
+			break
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+
+	// The oldest event must be the start of the chain
+	if newer != nil && (newer.ID != 1 || newer.PrevHash != "") {
+		result.BrokenAt, result.Problem = newer.ID, "chain does not start at event 1"
+		return result, nil
+	}
+
+	result.Valid = true
+	return result, nil
+}
+
+// auditTypes lists every event type, to validate the ?type= filter.
+var auditTypes = []string{
+	auditRegistration, auditPasskeyAdded, auditLogin, auditDeletedCredential,
+	auditCloneWarning, auditPasskeyDeleted, auditPasskeyReinstated, auditAccountLocked,
+	auditAccountUnlocked, auditRecoveryCodes, auditReauth, auditTransactionApproval,
+}
+
+// parseAuditFilter reads the admin query filters: type (comma-separated),
+// username, credentialId, outcome, since and until (RFC 3339), plus paging.
+func parseAuditFilter(r *http.Request) (AuditFilter, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Username:     query.Get("username"),
+		CredentialID: strings.TrimRight(query.Get("credentialId"), "="),
+		Outcome:      query.Get("outcome"),
+	}
+
+	if value := query.Get("type"); value != "" {
+		filter.Types = splitList(value)
+		for _, t := range filter.Types {
+			if !containsString(auditTypes, t) {
+				return filter, fmt.Errorf("unknown event type %q", t)
+			}
+		}
+	}
+	switch filter.Outcome {
+	case "", auditSuccess, auditFailure, auditWarning:
+	default:
+		return filter, fmt.Errorf("outcome must be success, failure or warning")
+	}
+
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*target = t
+		}
+	}
+
+	return filter, parseAuditPaging(r, &filter, auditDefaultLimit)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appendTestEvents appends one event per username, alternating types.
+func appendTestEvents(t *testing.T, store Store, usernames ...string) {
+	t.Helper()
+	for i, username := range usernames {
+		event := AuditEvent{Time: time.Now(), Type: auditLogin, Username: username, Outcome: auditSuccess}
+		if i%2 == 1 {
+			event.Type, event.Outcome = auditPasskeyDeleted, auditWarning
+		}
+		if err := store.AppendAuditEvent(&event); err != nil {
+			t.Fatalf("AppendAuditEvent: %v", err)
+		}
+		if event.ID != int64(i+1) || event.Hash == "" {
+			t.Fatalf("appended event = %+v, want ID %d and a hash", event, i+1)
+		}
+	}
+}
+
+func auditIDs(events []AuditEvent) []int64 {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func TestStoreAuditLog(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		appendTestEvents(t, store, "alice", "bob", "alice", "carol", "alice")
+
+		tests := []struct {
+			name   string
+			filter AuditFilter
+			want   []int64
+		}{
+			{"all, newest first", AuditFilter{}, []int64{5, 4, 3, 2, 1}},
+			{"username", AuditFilter{Username: "alice"}, []int64{5, 3, 1}},
+			{"type", AuditFilter{Types: []string{auditPasskeyDeleted}}, []int64{4, 2}},
+			{"outcome", AuditFilter{Outcome: auditWarning}, []int64{4, 2}},
+			{"page", AuditFilter{BeforeID: 4, Limit: 2}, []int64{3, 2}},
+			{"until", AuditFilter{Until: time.Now().Add(-time.Hour)}, []int64{}},
+		}
+		for _, tc := range tests {
+			events, err := store.ListAuditEvents(tc.filter)
+			if err != nil {
+				t.Fatalf("%s: ListAuditEvents: %v", tc.name, err)
+			}
+			if got := auditIDs(events); !slices.Equal(got, tc.want) {
+				t.Errorf("%s: IDs = %v, want %v", tc.name, got, tc.want)
+			}
+		}
+
+		result, err := verifyAuditChain(store)
+		if err != nil {
+			t.Fatalf("verifyAuditChain: %v", err)
+		}
+		newest, _ := store.ListAuditEvents(AuditFilter{Limit: 1})
+		if !result.Valid || result.Events != 5 || result.Head != newest[0].Hash {
+			t.Errorf("verifyAuditChain = %+v, want a valid chain of 5 ending at %s", result, newest[0].Hash)
+		}
+	})
+}
+
+func TestVerifyAuditChainDetectsTampering(t *testing.T) {
+	store := NewInMemoryStore()
+	appendTestEvents(t, store, "alice", "bob", "carol", "dave")
+
+	store.auditEvents[1].Username = "mallory"
+	if result, _ := verifyAuditChain(store); result.Valid || result.BrokenAt != 2 || !strings.Contains(result.Problem, "hash") {
+		t.Errorf("edited event: %+v, want broken at 2", result)
+	}
+
+	// Re-hashing the edit still breaks the link from the next event
+	store.auditEvents[1].Hash = auditHash(&store.auditEvents[1])
+	if result, _ := verifyAuditChain(store); result.Valid || result.BrokenAt != 3 {
+		t.Errorf("re-hashed event: %+v, want broken at 3", result)
+	}
+
+	store.auditEvents = append(store.auditEvents[:1:1], store.auditEvents[2:]...)
+	if result, _ := verifyAuditChain(store); result.Valid || !strings.Contains(result.Problem, "missing") {
+		t.Errorf("removed event: %+v, want a missing event", result)
+	}
+}
+
+func TestSQLiteAuditLogAppendOnly(t *testing.T) {
+	store := storeBackends[1].open(t).(*SQLiteStore)
+	defer store.Close()
+	appendTestEvents(t, store, "alice")
+
+	if _, err := store.db.Exec(`UPDATE audit_events SET username = 'mallory'`); err == nil {
+		t.Error("UPDATE of an audit event succeeded")
+	}
+	if _, err := store.db.Exec(`DELETE FROM audit_events`); err == nil {
+		t.Error("DELETE of an audit event succeeded")
+	}
+}
+
+func TestHandleAdminAudit(t *testing.T) {
+	app := &App{config: DefaultConfig(), store: NewInMemoryStore()}
+	appendTestEvents(t, app.store, "alice", "bob", "alice")
+
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		app.handleAdminAudit(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/admin/audit", "anything"); rec.Code != http.StatusNotFound {
+		t.Errorf("without a configured token: status = %d, want 404", rec.Code)
+	}
+
+	token := strings.Repeat("a", minAdminTokenLength)
+	app.config.Admin.Token = token
+	if rec := get("/api/admin/audit", "wrong"); rec.Code != http.StatusUnauthorized || decodeError(t, rec).Code != ErrAdminRequired.Code {
+		t.Errorf("wrong token: status = %d, want 401 ADMIN_REQUIRED", rec.Code)
+	}
+	if rec := get("/api/admin/audit?type=bogus", token); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown type: status = %d, want 400", rec.Code)
+	}
+
+	rec := get("/api/admin/audit?username=alice&limit=1", token)
+	var page AuditPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(page.Events) != 1 || page.Events[0].ID != 3 || page.NextCursor != "3" {
+		t.Fatalf("first page = %d %+v", rec.Code, page)
+	}
+
+	rec = get("/api/admin/audit?username=alice&limit=1&before="+page.NextCursor, token)
+	page = AuditPage{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Events) != 1 || page.Events[0].ID != 1 || page.NextCursor != "" {
+		t.Errorf("last page = %+v", page)
+	}
+}
+
+func TestLoginAudited(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice")
+	passkey := newTestAuthenticator(t)
+	passkey.enroll(t, app, user)
+
+	if rec := passkey.loginFinish(t, app, user); rec.Code != http.StatusOK {
+		t.Fatalf("login: status = %d: %s", rec.Code, rec.Body)
+	}
+	events, _ := app.store.ListAuditEvents(AuditFilter{Username: "alice"})
+	if len(events) != 1 || events[0].Type != auditLogin || events[0].Outcome != auditSuccess ||
+		events[0].CredentialID != encodeCredentialID(passkey.id) || events[0].UserAgent != "test-agent" {
+		t.Errorf("audit events = %+v, want one successful login", events)
+	}
+
+	// A deleted credential is refused and recorded as such
+	if err := app.store.DeleteUserPasskey("alice", passkey.id, true); err != nil {
+		t.Fatal(err)
+	}
+	if rec := passkey.loginFinish(t, app, user); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with deleted credential: status = %d, want 401", rec.Code)
+	}
+	events, _ = app.store.ListAuditEvents(AuditFilter{Username: "alice", Limit: 1})
+	if len(events) != 1 || events[0].Outcome != auditFailure {
+		t.Errorf("newest event = %+v, want a failed login", events)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Relying party used by handler tests that run real WebAuthn ceremonies.
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// newTestWebAuthn configures the library for testRPID and testOrigin.
+func newTestWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Passkey Demo",
+		RPID:          testRPID,
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("webauthn.New: %v", err)
+	}
+	return w
+}
+
+// newTestApp returns an App with the default configuration, an in-memory
+// store and a relying party for testRPID and testOrigin.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	return &App{
+		config:   DefaultConfig(),
+		webAuthn: newTestWebAuthn(t),
+		store:    NewInMemoryStore(),
+		limiter:  newRateLimiter(),
+	}
+}
+
+// testAuthenticator is a software passkey that produces the JSON a browser
+// would post to the finish endpoints, with a "none" attestation.
+type testAuthenticator struct {
+	id        []byte
+	key       *ecdsa.PrivateKey
+	signCount uint32
+	origin    string // Reported in client data, testOrigin by default
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{id: id, key: key, origin: testOrigin}
+}
+
+// Authenticator data flags: user present, user verified, attested data.
+const (
+	testFlagUP = 0x01
+	testFlagUV = 0x04
+	testFlagAT = 0x40
+)
+
+// authData builds authenticator data for testRPID, followed by extra.
+func (a *testAuthenticator) authData(flags byte, extra []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, extra...)
+}
+
+func (a *testAuthenticator) clientDataJSON(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// register returns a registration response for the given challenge.
+func (a *testAuthenticator) register(t *testing.T, challenge string) []byte {
+	t.Helper()
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(testFlagUP|testFlagUV|testFlagAT, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(a.clientDataJSON(t, "webauthn.create", challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// login returns an assertion for the given challenge, advancing the
+// signature counter first as a real authenticator would.
+func (a *testAuthenticator) login(t *testing.T, challenge string, userHandle []byte) []byte {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(testFlagUP|testFlagUV, nil)
+	clientData := a.clientDataJSON(t, "webauthn.get", challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(userHandle),
+	})
+}
+
+func (a *testAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.id),
+		"rawId":    b64(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// enroll registers the authenticator for an existing user directly through
+// the library and the store, bypassing the registration handlers.
+func (a *testAuthenticator) enroll(t *testing.T, app *App, user *User) {
+	t.Helper()
+	_, sessionData, err := app.webAuthn.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(a.register(t, sessionData.Challenge)))
+	if err != nil {
+		t.Fatalf("parse registration: %v", err)
+	}
+	credential, err := app.webAuthn.CreateCredential(user, *sessionData, parsed)
+	if err != nil {
+		t.Fatalf("CreateCredential: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if err := app.store.AddCredential(user.ID, NewCredentialRecord(credential, req)); err != nil {
+		t.Fatalf("AddCredential: %v", err)
+	}
+}
+
+// loginFinish runs a discoverable login with the authenticator through
+// handleLoginFinish and returns the response.
+func (a *testAuthenticator) loginFinish(t *testing.T, app *App, user *User) *httptest.ResponseRecorder {
+	t.Helper()
+	_, sessionData, err := app.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatalf("BeginDiscoverableLogin: %v", err)
+	}
+	if err := app.store.StoreSession("login-1", &Session{Ceremony: ceremonyLogin, SessionData: *sessionData}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/login/finish", bytes.NewReader(a.login(t, sessionData.Challenge, user.ID)))
+	req.Header.Set("User-Agent", "test-agent")
+	req = req.WithContext(setSessionID(req.Context(), "login-1"))
+	rec := httptest.NewRecorder()
+	app.handleLoginFinish(rec, req)
+	return rec
+}
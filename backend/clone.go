@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// Clone warning policies (Config.Security.ClonePolicy).
+const (
+	clonePolicyFlag    = "flag"    // Allow the login, mark the passkey suspect
+	clonePolicyStepUp  = "step-up" // Also require an assertion from a different passkey
+	clonePolicySuspend = "suspend" // Refuse the login and suspend the passkey
+)
+
+// StepUpResponse is the body of 401 STEP_UP_REQUIRED.
+//
+// Options are assertion options limited to the user's other passkeys: pass
+// them to navigator.credentials.get() and POST the result to
+// /api/login/step-up/finish. They are omitted (with 403) when the account has
+// no other passkey that could confirm the login.
+type StepUpResponse struct {
+	ErrorResponse
+	Options *protocol.CredentialAssertion `json:"options,omitempty"`
+}
+
+// checkCredentialStanding applies the clone policy to a verified assertion,
+// before a login session is created for it.
+//
+// WebAuthn sign counters only go up; a counter that did not increase
+// (credential.Authenticator.CloneWarning) suggests two authenticators share
+// the private key. signCount is the counter the authenticator sent, which
+// the library does not keep when it raises the warning. The first such
+// warning marks the passkey suspect, which the owner sees in the passkey
+// list until they reinstate or delete it.
+// Every warning is audited. Then, depending on the policy:
+//   - flag: the login continues
+//   - step-up: logins with the suspect passkey must be confirmed with a
+//     different passkey (see requireStepUp)
+//   - suspend: the passkey is suspended and refused with 403
+//     CREDENTIAL_SUSPENDED
+//
+// Suspended passkeys are refused whatever the current policy. Step-up only
+// completes logins: other ceremonies (reauthentication, transaction
+// approval) refuse a suspect passkey under the step-up policy with 403
+// CREDENTIAL_SUSPECT instead, so they never turn into a new login. When the
+// ceremony is stopped, its session is deleted and ok is false.
+func (app *App) checkCredentialStanding(w http.ResponseWriter, r *http.Request, ceremony, sessionID string, user *User, credential *webauthn.Credential, signCount uint32) (ok bool) {
+	record := user.credentialRecord(credential.ID)
+	if record == nil {
+		// Callers check the credential belongs to the user first
+		app.writeAppError(w, ErrCredentialNotFound, http.StatusUnauthorized)
+		return false
+	}
+	policy := app.config.Security.ClonePolicy
+
+	if credential.Authenticator.CloneWarning {
+		logger.WarnContext(r.Context(), "Clone detected", "policy", policy)
+		metrics.CloneWarnings.Inc()
+		app.audit(r, AuditEvent{
+			Type:         auditCloneWarning,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(credential.ID),
+			Outcome:      auditWarning,
+			Detail:       fmt.Sprintf("sign count %d, policy %s", signCount, policy),
+		})
+
+		now := time.Now()
+		mark := func(record *CredentialRecord) {
+			record.LastSignCount = signCount
+			if record.SuspectAt.IsZero() {
+				record.SuspectAt = now
+			}
+			if policy == clonePolicySuspend {
+				record.Suspended = true
+			}
+		}
+		if err := app.store.UpdateCredential(user.ID, credential.ID, mark); err != nil {
+			app.store.DeleteSession(sessionID)
+			if err == ErrCredentialNotFound {
+				// Deleted while the ceremony was in flight
+				app.writeAppError(w, ErrCredentialNotFound, http.StatusUnauthorized)
+				return false
+			}
+			app.writeError(w, fmt.Sprintf("Failed to update credential: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		mark(record)
+	}
+
+	switch {
+	case record.Suspended:
+		app.store.DeleteSession(sessionID)
+		app.audit(r, AuditEvent{
+			Type:         auditLogin,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(credential.ID),
+			Outcome:      auditFailure,
+			Detail:       ErrCredentialSuspended.Code,
+		})
+		app.writeAppError(w, ErrCredentialSuspended, http.StatusForbidden)
+		return false
+	case !record.SuspectAt.IsZero() && policy == clonePolicyStepUp:
+		app.store.DeleteSession(sessionID)
+		if ceremony != ceremonyLogin && ceremony != ceremonyStepUp {
+			logger.WarnContext(r.Context(), "Refused suspect passkey", "ceremony", ceremony)
+			app.writeAppError(w, ErrCredentialSuspect, http.StatusForbidden)
+			return false
+		}
+		app.requireStepUp(w, r, user, record)
+		return false
+	}
+
+	return true
+}
+
+// canVerify reports whether record may provide fresh user verification
+// outside of a login: it must not be suspended, nor suspect while the
+// step-up policy asks for suspect passkeys to be confirmed by another.
+func (app *App) canVerify(record *CredentialRecord) bool {
+	if record.Suspended {
+		return false
+	}
+	return record.SuspectAt.IsZero() || app.config.Security.ClonePolicy != clonePolicyStepUp
+}
+
+// requireStepUp answers a login with a suspect passkey by starting a new
+// assertion limited to the user's other passkeys that are in good standing.
+func (app *App) requireStepUp(w http.ResponseWriter, r *http.Request, user *User, suspect *CredentialRecord) {
+	var allowed []protocol.CredentialDescriptor
+	for _, cred := range user.Credentials {
+		if string(cred.ID) != string(suspect.ID) && cred.SuspectAt.IsZero() && !cred.Suspended {
+			allowed = append(allowed, cred.Descriptor())
+		}
+	}
+
+	app.audit(r, AuditEvent{
+		Type:         auditLogin,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(suspect.ID),
+		Outcome:      auditFailure,
+		Detail:       ErrStepUpRequired.Code,
+	})
+
+	response := StepUpResponse{ErrorResponse: ErrorResponse{Error: ErrStepUpRequired.Message, Code: ErrStepUpRequired.Code}}
+	if len(allowed) == 0 {
+		recordErrorCode(w, ErrStepUpRequired.Code)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	options, sessionData, err := app.webAuthn.BeginLogin(
+		user,
+		webauthn.WithAllowedCredentials(allowed),
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to begin step-up: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if err := app.store.StoreSession(sessionID, &Session{
+		UserID:      user.ID,
+		Ceremony:    ceremonyStepUp,
+		StepUpFrom:  suspect.ID,
+		SessionData: *sessionData,
+	}); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
+
+	response.Options = options
+	recordErrorCode(w, ErrStepUpRequired.Code)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleStepUpFinish completes a login that required confirmation with a
+// different passkey (see requireStepUp).
+//
+// The assertion must come from one of the passkeys offered in the step-up
+// options; the user is then signed in with that passkey. The suspect passkey
+// stays suspect until its owner reinstates or deletes it.
+//
+// HTTP Status: 200 (signed in), 400 (invalid session), 401 (assertion failed)
+func (app *App) handleStepUpFinish(w http.ResponseWriter, r *http.Request) {
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyStepUp)
+	if !ok {
+		return
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		app.writeError(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Authentication failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	if !app.checkLockout(w, r, user, parsedResponse.RawID) {
+		return
+	}
+
+	// The session only allows the other passkeys, so the suspect one fails here
+	credential, err := app.webAuthn.ValidateLogin(user, session.SessionData, parsedResponse)
+	if err != nil {
+		app.audit(r, AuditEvent{
+			Type:         auditLogin,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(parsedResponse.RawID),
+			Outcome:      auditFailure,
+			Detail:       assertionFailure(err),
+		})
+		app.recordLoginFailure(r, user, parsedResponse.RawID)
+		app.writeError(w, fmt.Sprintf("Authentication failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	logCredential(r.Context(), credential.ID)
+
+	if !app.checkCredentialStanding(w, r, ceremonyStepUp, sessionID, user, credential, parsedResponse.Response.AuthenticatorData.Counter) {
+		return
+	}
+	app.clearLoginFailures(r, user, credential.ID)
+
+	if !app.updateUserCredential(w, r, user, credential) {
+		return
+	}
+
+	if err := app.startLoginSession(w, r, user, credential); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to create login session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	app.store.DeleteSession(sessionID)
+
+	metrics.Logins.Inc("step_up")
+	app.audit(r, AuditEvent{
+		Type:         auditLogin,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credential.ID),
+		Outcome:      auditSuccess,
+		Detail:       "step-up for " + encodeCredentialID(session.StepUpFrom),
+	})
+	app.writeSuccess(w, "Authentication successful", map[string]interface{}{
+		"username":    user.Username,
+		"displayName": user.DisplayName,
+		"userId":      user.ID,
+	})
+}
+
+// handleReinstatePasskey clears the suspect and suspended marks on one of
+// the current user's passkeys, after the owner has checked it is theirs.
+//
+// The stored sign count is lowered to the one last seen, so the counter the
+// owner's authenticator now reports does not raise the warning again.
+//
+// Requires recent user verification with a different passkey: a session
+// signed in with the suspect passkey proves nothing about it. Owners who do
+// not recognise the passkey should delete it instead.
+//
+// HTTP Status: 200 (success), 400 (invalid ID), 401/403 (see
+// requireRecentVerification), 403 DIFFERENT_CREDENTIAL_REQUIRED
+func (app *App) handleReinstatePasskey(w http.ResponseWriter, r *http.Request) {
+	loginSession, user, ok := app.requireRecentVerification(w, r)
+	if !ok {
+		return
+	}
+
+	_, credentialID, ok := app.credentialIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	record := user.credentialRecord(credentialID)
+	if record == nil {
+		app.writeAppError(w, ErrCredentialNotFound, http.StatusNotFound)
+		return
+	}
+	if string(loginSession.CredentialID) == string(credentialID) {
+		app.writeAppError(w, ErrDifferentCredential, http.StatusForbidden)
+		return
+	}
+
+	err := app.store.UpdateCredential(user.ID, credentialID, func(record *CredentialRecord) {
+		if !record.SuspectAt.IsZero() {
+			record.Authenticator.SignCount = record.LastSignCount
+		}
+		record.Authenticator.CloneWarning = false
+		record.SuspectAt = time.Time{}
+		record.Suspended = false
+	})
+	if err == ErrCredentialNotFound {
+		app.writeAppError(w, ErrCredentialNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to reinstate passkey: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Passkey reinstated")
+	app.audit(r, AuditEvent{
+		Type:         auditPasskeyReinstated,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credentialID),
+		Outcome:      auditSuccess,
+	})
+	app.writeSuccess(w, "Passkey reinstated", nil)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCloneWarningReinstate runs a login that raises a clone warning, has
+// the owner reinstate the passkey and checks the next login goes through
+// without another warning.
+func TestCloneWarningReinstate(t *testing.T) {
+	for _, policy := range []string{clonePolicyFlag, clonePolicySuspend} {
+		t.Run(policy, func(t *testing.T) {
+			app := &App{config: DefaultConfig(), store: NewInMemoryStore()}
+			app.config.Security.ClonePolicy = policy
+
+			user := testUser("alice", "cred-1", "cred-2")
+			user.Credentials[0].Authenticator.SignCount = 10
+			user.Credentials[0].LastSignCount = 10
+			if err := app.store.CreateUser(user); err != nil {
+				t.Fatal(err)
+			}
+
+			// login verifies an assertion with counter the way ValidateLogin
+			// does, then applies the clone policy
+			login := func(counter uint32) (ok bool) {
+				stored, _ := app.store.GetUser("alice")
+				credential := stored.credentialRecord([]byte("cred-1")).Credential
+				credential.Authenticator.UpdateCounter(counter)
+
+				req := httptest.NewRequest(http.MethodPost, "/api/login/finish", nil)
+				if !app.checkCredentialStanding(httptest.NewRecorder(), req, ceremonyLogin, "session", stored, &credential, counter) {
+					return false
+				}
+				return app.updateUserCredential(httptest.NewRecorder(), req, stored, &credential)
+			}
+
+			if ok := login(4); ok != (policy == clonePolicyFlag) {
+				t.Fatalf("login with a lower counter: ok = %v", ok)
+			}
+			stored, _ := app.store.GetUser("alice")
+			record := stored.credentialRecord([]byte("cred-1"))
+			if record.SuspectAt.IsZero() || record.Authenticator.CloneWarning || record.LastSignCount != 4 {
+				t.Fatalf("after clone warning: suspectAt %v, cloneWarning %v, lastSignCount %d",
+					record.SuspectAt, record.Authenticator.CloneWarning, record.LastSignCount)
+			}
+
+			session := &LoginSession{UserID: user.ID, CredentialID: []byte("cred-2"), VerifiedAt: time.Now()}
+			req := httptest.NewRequest(http.MethodPost, "/api/user/passkeys/"+encodeCredentialID([]byte("cred-1"))+"/reinstate", nil)
+			req = req.WithContext(setLoginSession(req.Context(), session))
+			rec := httptest.NewRecorder()
+			app.handleReinstatePasskey(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("reinstate: %d %s", rec.Code, rec.Body)
+			}
+
+			if !login(5) {
+				t.Fatal("login after reinstate was refused")
+			}
+			stored, _ = app.store.GetUser("alice")
+			record = stored.credentialRecord([]byte("cred-1"))
+			if !record.SuspectAt.IsZero() || record.Suspended || record.Authenticator.SignCount != 5 {
+				t.Errorf("after reinstate and login: suspectAt %v, suspended %v, signCount %d",
+					record.SuspectAt, record.Suspended, record.Authenticator.SignCount)
+			}
+
+			warnings, err := app.store.ListAuditEvents(AuditFilter{Types: []string{auditCloneWarning}})
+			if err != nil || len(warnings) != 1 {
+				t.Errorf("clone warnings audited: %d (%v), want 1", len(warnings), err)
+			}
+		})
+	}
+}
+
+// stepUpFinish posts an assertion from passkey for the step-up options in
+// rec, the 401 STEP_UP_REQUIRED answer to a login.
+func stepUpFinish(t *testing.T, app *App, user *User, passkey *testAuthenticator, rec *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	t.Helper()
+	var stepUp StepUpResponse
+	if err := json.NewDecoder(rec.Body).Decode(&stepUp); err != nil || stepUp.Options == nil {
+		t.Fatalf("decode step-up response: %v", err)
+	}
+	var sessionID string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "webauthn-session" {
+			sessionID = cookie.Value
+		}
+	}
+
+	body := passkey.login(t, stepUp.Options.Response.Challenge.String(), user.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/login/step-up/finish", bytes.NewReader(body))
+	req = req.WithContext(setSessionID(req.Context(), sessionID))
+	finish := httptest.NewRecorder()
+	app.handleStepUpFinish(finish, req)
+	return finish
+}
+
+func TestCloneStepUpLogin(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Security.ClonePolicy = clonePolicyStepUp
+	user := createTestUser(t, app.store, "alice")
+	cloned, other := newTestAuthenticator(t), newTestAuthenticator(t)
+	cloned.enroll(t, app, user)
+	other.enroll(t, app, user)
+
+	if rec := cloned.loginFinish(t, app, user); rec.Code != http.StatusOK {
+		t.Fatalf("first login: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// A copy of the key replays an old counter
+	cloned.signCount = 0
+	rec := cloned.loginFinish(t, app, user)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with a repeated counter: status = %d, want 401 STEP_UP_REQUIRED: %s", rec.Code, rec.Body)
+	}
+	stored, _ := app.store.GetUser("alice")
+	if record := stored.credentialRecord(cloned.id); record.SuspectAt.IsZero() || record.LastSignCount != 1 {
+		t.Errorf("suspect passkey = %+v, want marked suspect with the counter it sent", record)
+	}
+
+	// The suspect passkey cannot confirm its own login
+	if finish := stepUpFinish(t, app, user, cloned, rec); finish.Code != http.StatusUnauthorized {
+		t.Errorf("step-up with the suspect passkey: status = %d, want 401", finish.Code)
+	}
+
+	rec = cloned.loginFinish(t, app, user)
+	finish := stepUpFinish(t, app, user, other, rec)
+	if finish.Code != http.StatusOK {
+		t.Fatalf("step-up with another passkey: status = %d: %s", finish.Code, finish.Body)
+	}
+	sessions := 0
+	for _, cookie := range finish.Result().Cookies() {
+		if cookie.Name == userSessionCookie && cookie.Value != "" {
+			sessions++
+		}
+	}
+	if sessions != 1 {
+		t.Errorf("step-up set %d login session cookies, want 1", sessions)
+	}
+}
@@ -0,0 +1,1073 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete backend configuration.
+//
+// Values are layered, each layer overriding the previous one:
+//  1. DefaultConfig (local development on localhost)
+//  2. YAML file given with -config
+//  3. Environment variables (see applyEnv)
+//  4. Command line flags that were explicitly set
+//
+// The result is checked by Validate before the server starts, and can be
+// inspected with the print-config subcommand. Validate does not modify the
+// configuration, so the readiness probe re-runs it on a live server.
+type Config struct {
+	Listen         string             `yaml:"listen"`          // HTTP (or HTTPS with tls.enabled) listen address
+	TrustedProxies []string           `yaml:"trustedProxies"`  // Reverse proxies (addresses or CIDRs) whose forwarding headers are believed
+	TLS            TLSConfig          `yaml:"tls"`             // Built-in HTTPS listener
+	RelyingParty   RelyingPartyConfig `yaml:"relyingParty"`    // WebAuthn RP identity
+	Timeouts       TimeoutsConfig     `yaml:"timeouts"`        // Browser ceremony timeouts
+	Sessions       SessionsConfig     `yaml:"sessions"`        // Ceremony and login session lifetimes
+	Cookies        CookiesConfig      `yaml:"cookies"`         // Cookie attributes
+	Storage        StorageConfig      `yaml:"storage"`         // Store backend
+	Attestation    AttestationConfig  `yaml:"attestation"`     // Attestation and FIDO metadata
+	Apple          AppleConfig        `yaml:"apple"`           // iOS apps sharing passkeys
+	Android        AndroidConfig      `yaml:"android"`         // Android apps sharing passkeys
+	Logging        LoggingConfig      `yaml:"logging"`         // Log format and level
+	Admin          AdminConfig        `yaml:"admin"`           // Admin API access
+	Security       SecurityConfig     `yaml:"security"`        // Responses to suspicious authenticator behaviour
+	RateLimit      RateLimitConfig    `yaml:"rateLimit"`       // Request budgets per client IP and username
+	Shutdown       time.Duration      `yaml:"shutdownTimeout"` // How long to drain connections on SIGINT/SIGTERM
+	DrainDelay     time.Duration      `yaml:"drainDelay"`      // How long readiness fails before listeners close
+	AAGUIDFile     string             `yaml:"aaguidFile"`      // Passkey AAGUID listing (optional)
+	PolicyFile     string             `yaml:"policyFile"`      // Authenticator policy (optional)
+}
+
+// RelyingPartyConfig identifies this server to authenticators.
+type RelyingPartyConfig struct {
+	ID             string   `yaml:"id"`             // RP ID, a registrable domain suffix of every origin
+	DisplayName    string   `yaml:"displayName"`    // Shown by some authenticators during registration
+	Origins        []string `yaml:"origins"`        // Origins allowed to perform ceremonies
+	RelatedOrigins []string `yaml:"relatedOrigins"` // Origins on other domains sharing this RP ID (see /.well-known/webauthn)
+}
+
+// AppleConfig lists the Apple apps published in the generated
+// /.well-known/apple-app-site-association file.
+type AppleConfig struct {
+	Apps []AppleApp `yaml:"apps"`
+}
+
+// AppleApp associates one app (or App Clip) with this domain.
+//
+// The app ID is TeamID.BundleID. An app must use at least one service.
+type AppleApp struct {
+	TeamID         string             `yaml:"teamID"`         // 10-character Apple Developer Team ID
+	BundleID       string             `yaml:"bundleID"`       // e.g. com.passkey.demo.ios
+	WebCredentials bool               `yaml:"webCredentials"` // Share passkeys and passwords
+	AppLinks       []AppLinkComponent `yaml:"appLinks"`       // Universal Link URL components
+	AppClip        bool               `yaml:"appClip"`        // This app ID is an App Clip
+}
+
+// AppLinkComponent matches Universal Link URLs, in the AASA "components"
+// format. Empty fields are omitted and match anything.
+type AppLinkComponent struct {
+	Path     string `yaml:"path" json:"/,omitempty"`          // e.g. /profile/*
+	Query    string `yaml:"query" json:"?,omitempty"`         // e.g. ref=*
+	Fragment string `yaml:"fragment" json:"#,omitempty"`      // e.g. section
+	Exclude  bool   `yaml:"exclude" json:"exclude,omitempty"` // Keep matching URLs in the browser
+	Comment  string `yaml:"comment" json:"comment,omitempty"` // Ignored by iOS
+}
+
+// AndroidConfig lists the Android apps allowed to use this RP's passkeys.
+//
+// Each app is published in /.well-known/assetlinks.json and its signing
+// certificates are accepted as android:apk-key-hash origins, which is what
+// Credential Manager puts in clientDataJSON for native apps.
+type AndroidConfig struct {
+	Apps []AndroidApp `yaml:"apps"`
+}
+
+// AndroidApp identifies an app by package name and signing certificates.
+type AndroidApp struct {
+	PackageName            string   `yaml:"packageName"`            // e.g. com.passkeydemo.android
+	SHA256CertFingerprints []string `yaml:"sha256CertFingerprints"` // "AB:CD:..." as printed by keytool or signingReport
+}
+
+// TLSConfig enables the built-in HTTPS listener.
+//
+// With Cert and Key empty, a local development CA and a leaf certificate for
+// the RP ID, localhost and this machine's LAN names are generated in Dir (see
+// TLSConfig.serverTLSConfig). Enabling TLS also forces secure cookies.
+type TLSConfig struct {
+	Enabled        bool     `yaml:"enabled"`        // Serve HTTPS on listen
+	Cert           string   `yaml:"cert"`           // PEM certificate chain (optional)
+	Key            string   `yaml:"key"`            // PEM private key for cert
+	Dir            string   `yaml:"dir"`            // Where generated CA and certificate are kept
+	Hosts          []string `yaml:"hosts"`          // Extra names for the generated certificate
+	RedirectListen string   `yaml:"redirectListen"` // Plain HTTP address redirecting to HTTPS (empty disables)
+}
+
+// TimeoutsConfig bounds how long the browser may take for each ceremony.
+type TimeoutsConfig struct {
+	Registration time.Duration `yaml:"registration"`
+	Login        time.Duration `yaml:"login"`
+}
+
+// SessionsConfig sets ceremony and login session lifetimes.
+type SessionsConfig struct {
+	Ceremony     time.Duration `yaml:"ceremony"`     // Between begin and finish requests
+	Lifetime     time.Duration `yaml:"lifetime"`     // Absolute login session lifetime
+	IdleTimeout  time.Duration `yaml:"idleTimeout"`  // Maximum gap between requests
+	ReauthWindow time.Duration `yaml:"reauthWindow"` // How long user verification counts as recent
+}
+
+// CookiesConfig sets attributes shared by all session cookies.
+type CookiesConfig struct {
+	Secure   bool   `yaml:"secure"`   // Only send over HTTPS
+	SameSite string `yaml:"sameSite"` // strict, lax or none
+}
+
+// StorageConfig selects the Store backend.
+type StorageConfig struct {
+	Kind string `yaml:"kind"` // memory or sqlite
+	Path string `yaml:"path"` // SQLite database file
+}
+
+// LoggingConfig selects the log handler (see NewLogger).
+type LoggingConfig struct {
+	Format string `yaml:"format"` // text, json or console
+	Level  string `yaml:"level"`  // debug, info, warn or error
+}
+
+// AdminConfig enables the admin API (/api/admin/...).
+//
+// Requests authenticate with "Authorization: Bearer <token>". Without a
+// token the admin endpoints do not exist.
+type AdminConfig struct {
+	Token string `yaml:"token"` // Shared secret, at least minAdminTokenLength characters
+}
+
+// SecurityConfig decides how the server reacts to signs of a compromised
+// passkey.
+type SecurityConfig struct {
+	// What to do when an assertion's sign counter did not increase, which
+	// can mean the credential was cloned (see App.checkCredentialStanding):
+	//   - flag: allow the login and mark the passkey suspect
+	//   - step-up: require an assertion from a different passkey as well
+	//   - suspend: refuse the login and suspend the passkey
+	ClonePolicy string `yaml:"clonePolicy"`
+
+	Lockout LockoutConfig `yaml:"lockout"` // Locking after repeated failed assertions
+
+	// Wrong recovery codes allowed per account (see handleRecoveryLogin).
+	// Kept apart from Lockout, so failed assertions never block recovery.
+	RecoveryFailures RateLimit `yaml:"recoveryFailures"`
+
+	// Ed25519 seed (32 bytes, base64) signing transaction approvals. Without
+	// one a random key is used, and approvals stop verifying on restart.
+	TransactionKey string `yaml:"transactionKey"`
+}
+
+// LockoutConfig locks accounts and credentials that keep failing
+// assertions (see LockoutConfig.lockedUntil).
+//
+// Threshold consecutive failures lock for Duration; every further failure
+// doubles the lock, up to MaxDuration. A run of failures is forgotten after
+// MaxDuration without one. Threshold 0 disables lockout.
+type LockoutConfig struct {
+	Threshold   int           `yaml:"threshold"`
+	Duration    time.Duration `yaml:"duration"`
+	MaxDuration time.Duration `yaml:"maxDuration"`
+}
+
+// RateLimitConfig sets token bucket budgets for API requests (see
+// rateLimiter).
+//
+// Routes are keyed by exact path. A route's IP budget is shared by every
+// request from one client address; its user budget by every request naming
+// one account, from any address. API routes without an entry share the
+// default per-IP budget. Budgets are in memory, per process.
+type RateLimitConfig struct {
+	Enabled bool                  `yaml:"enabled"`
+	Default RateLimit             `yaml:"default"` // Per client IP, for routes not listed
+	Routes  map[string]RouteLimit `yaml:"routes"`  // A listed path replaces that path's whole default entry; other defaults are kept
+}
+
+// RateLimit is a token bucket budget: Requests per Per on average, with
+// bursts of up to Burst requests (default Requests). Zero Requests means no
+// limit.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
+
+// RouteLimit holds the budgets of one API route.
+type RouteLimit struct {
+	IP   RateLimit `yaml:"ip"`
+	User RateLimit `yaml:"user"`
+}
+
+// minAdminTokenLength keeps the admin token out of guessing range; 32 hex
+// characters (openssl rand -hex 16) carry 128 bits.
+const minAdminTokenLength = 32
+
+// AttestationConfig controls attestation requests and MDS3 validation.
+type AttestationConfig struct {
+	Conveyance string `yaml:"conveyance"` // none or direct
+	MDSBlob    string `yaml:"mdsBlob"`    // FIDO MDS3 BLOB (optional)
+	MDSRoot    string `yaml:"mdsRoot"`    // Root certificate for the BLOB (default: FIDO Alliance root)
+	MDSEnforce bool   `yaml:"mdsEnforce"` // Reject REVOKED or compromised authenticators
+}
+
+// DefaultConfig returns the settings for local development.
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:     ":8080",
+		Shutdown:   15 * time.Second,
+		DrainDelay: 5 * time.Second,
+		TLS: TLSConfig{
+			Dir:            "certs",
+			RedirectListen: ":80",
+		},
+		RelyingParty: RelyingPartyConfig{
+			ID:          "localhost",
+			DisplayName: "WebAuthn Passkey Demo",
+			Origins:     []string{"http://localhost:5173"}, // React dev server
+		},
+		Timeouts: TimeoutsConfig{
+			Registration: 60 * time.Second,
+			Login:        60 * time.Second,
+		},
+		Sessions: SessionsConfig{
+			Ceremony:     5 * time.Minute,
+			Lifetime:     24 * time.Hour,
+			IdleTimeout:  1 * time.Hour,
+			ReauthWindow: 5 * time.Minute,
+		},
+		Cookies: CookiesConfig{
+			Secure:   false, // Forced to true when tls.enabled
+			SameSite: "strict",
+		},
+		Storage: StorageConfig{
+			Kind: "memory",
+			Path: "passkey-demo.db",
+		},
+		Attestation: AttestationConfig{
+			Conveyance: "none",
+			MDSEnforce: true,
+		},
+		Logging: LoggingConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Security: SecurityConfig{
+			ClonePolicy: clonePolicyFlag,
+			Lockout: LockoutConfig{
+				Threshold:   5,
+				Duration:    time.Minute,
+				MaxDuration: time.Hour,
+			},
+			RecoveryFailures: RateLimit{Requests: 5, Per: 15 * time.Minute},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Default: RateLimit{Requests: 300, Per: time.Minute},
+			Routes: map[string]RouteLimit{
+				"/api/register/begin": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/register/finish": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/login/begin": {
+					IP:   RateLimit{Requests: 30, Per: time.Minute},
+					User: RateLimit{Requests: 10, Per: time.Minute},
+				},
+				"/api/login/finish": {
+					IP:   RateLimit{Requests: 30, Per: time.Minute},
+					User: RateLimit{Requests: 10, Per: time.Minute},
+				},
+				"/api/login/step-up/finish": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/user/passkeys/register/begin": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/user/passkeys/register/finish": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/reauth/begin": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/reauth/finish": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+				"/api/transactions/begin": {
+					IP:   RateLimit{Requests: 30, Per: time.Minute},
+					User: RateLimit{Requests: 10, Per: time.Minute},
+				},
+				"/api/transactions/finish": {
+					IP:   RateLimit{Requests: 30, Per: time.Minute},
+					User: RateLimit{Requests: 10, Per: time.Minute},
+				},
+				"/api/recovery/login": {
+					IP:   RateLimit{Requests: 10, Per: time.Minute},
+					User: RateLimit{Requests: 5, Per: time.Minute},
+				},
+			},
+		},
+	}
+}
+
+// configFlags holds the command line flags that override the configuration.
+type configFlags struct {
+	path      *string
+	localhost *bool
+
+	listen      *string
+	tls         *bool
+	tlsCert     *string
+	tlsKey      *string
+	rpID        *string
+	origins     *string
+	related     *string
+	storeKind   *string
+	dbPath      *string
+	aaguidPath  *string
+	attestation *string
+	mdsBlob     *string
+	mdsRoot     *string
+	mdsEnforce  *bool
+	policyPath  *string
+	logFormat   *string
+	logLevel    *string
+	clonePolicy *string
+	proxies     *string
+	rateLimit   *bool
+}
+
+// registerConfigFlags defines the configuration flags on fs.
+func registerConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		path:      fs.String("config", "", "YAML configuration file"),
+		localhost: fs.Bool("localhost", false, "Force localhost mode (ignore NGROK_URL)"),
+
+		listen:      fs.String("listen", "", "HTTP listen address (default :8080)"),
+		tls:         fs.Bool("tls", false, "Serve HTTPS, generating a local development CA and certificate unless -tls-cert/-tls-key are set"),
+		tlsCert:     fs.String("tls-cert", "", "PEM certificate for -tls"),
+		tlsKey:      fs.String("tls-key", "", "PEM private key for -tls"),
+		rpID:        fs.String("rpid", "", "WebAuthn relying party ID"),
+		origins:     fs.String("origins", "", "Comma-separated allowed origins"),
+		related:     fs.String("related-origins", "", "Comma-separated origins on other domains allowed to use the RP ID"),
+		storeKind:   fs.String("store", "", "Storage backend: memory or sqlite"),
+		dbPath:      fs.String("db", "", "SQLite database file (used with -store=sqlite)"),
+		aaguidPath:  fs.String("aaguid-file", "", "Passkey AAGUID listing (JSON) for authenticator names; reloaded on SIGHUP"),
+		attestation: fs.String("attestation", "", "Attestation conveyance to request: none or direct"),
+		mdsBlob:     fs.String("mds-blob", "", "FIDO MDS3 BLOB (JWT) used to validate attestation and authenticator status"),
+		mdsRoot:     fs.String("mds-root", "", "Root certificate (PEM or DER) that signs the MDS3 BLOB (default: FIDO Alliance root)"),
+		mdsEnforce:  fs.Bool("mds-enforce", true, "Reject authenticators whose MDS status is REVOKED or compromised (false: only flag them)"),
+		policyPath:  fs.String("policy", "", "Authenticator policy (JSON) evaluated when registrations finish"),
+		logFormat:   fs.String("log-format", "", "Log format: text, json or console (default text)"),
+		logLevel:    fs.String("log-level", "", "Minimum log level: debug, info, warn or error (default info)"),
+		clonePolicy: fs.String("clone-policy", "", "Response to a sign counter that did not increase: flag, step-up or suspend (default flag)"),
+		proxies:     fs.String("trusted-proxies", "", "Comma-separated proxy addresses or CIDRs whose X-Forwarded-For is believed"),
+		rateLimit:   fs.Bool("rate-limit", true, "Apply the rateLimit budgets (false disables rate limiting)"),
+	}
+}
+
+// LoadConfig builds the effective configuration from defaults, the file
+// named by -config, the environment and the flags explicitly set on fs.
+func LoadConfig(fs *flag.FlagSet, flags *configFlags) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if *flags.path != "" {
+		data, err := os.ReadFile(*flags.path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", *flags.path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(*flags.localhost); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *flags.listen
+		case "tls":
+			cfg.TLS.Enabled = *flags.tls
+		case "tls-cert":
+			cfg.TLS.Cert = *flags.tlsCert
+		case "tls-key":
+			cfg.TLS.Key = *flags.tlsKey
+		case "rpid":
+			cfg.RelyingParty.ID = *flags.rpID
+		case "origins":
+			cfg.RelyingParty.Origins = splitList(*flags.origins)
+		case "related-origins":
+			cfg.RelyingParty.RelatedOrigins = splitList(*flags.related)
+		case "store":
+			cfg.Storage.Kind = *flags.storeKind
+		case "db":
+			cfg.Storage.Path = *flags.dbPath
+		case "aaguid-file":
+			cfg.AAGUIDFile = *flags.aaguidPath
+		case "attestation":
+			cfg.Attestation.Conveyance = *flags.attestation
+		case "mds-blob":
+			cfg.Attestation.MDSBlob = *flags.mdsBlob
+		case "mds-root":
+			cfg.Attestation.MDSRoot = *flags.mdsRoot
+		case "mds-enforce":
+			cfg.Attestation.MDSEnforce = *flags.mdsEnforce
+		case "policy":
+			cfg.PolicyFile = *flags.policyPath
+		case "log-format":
+			cfg.Logging.Format = *flags.logFormat
+		case "log-level":
+			cfg.Logging.Level = *flags.logLevel
+		case "clone-policy":
+			cfg.Security.ClonePolicy = *flags.clonePolicy
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*flags.proxies)
+		case "rate-limit":
+			cfg.RateLimit.Enabled = *flags.rateLimit
+		}
+	})
+
+	// Cookies set over HTTPS are always marked Secure
+	if cfg.TLS.Enabled {
+		cfg.Cookies.Secure = true
+	}
+	for i := range cfg.Android.Apps {
+		cfg.Android.Apps[i].normalize()
+	}
+
+	return cfg, nil
+}
+
+// applyEnv overrides settings from PASSKEY_* environment variables.
+//
+// NGROK_URL is still honoured for the ngrok workflow: its host becomes the RP
+// ID and the tunnel the only origin, unless localhost mode was forced.
+// (localhost origins can never match an ngrok RP ID.)
+func (c *Config) applyEnv(localhost bool) error {
+	if ngrokURL := os.Getenv("NGROK_URL"); ngrokURL != "" && !localhost {
+		u, err := url.Parse(ngrokURL)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("invalid NGROK_URL %q", ngrokURL)
+		}
+		c.RelyingParty.ID = u.Hostname()
+		c.RelyingParty.Origins = []string{strings.TrimSuffix(ngrokURL, "/")}
+	}
+
+	stringVars := map[string]*string{
+		"PASSKEY_LISTEN":          &c.Listen,
+		"PASSKEY_TLS_CERT":        &c.TLS.Cert,
+		"PASSKEY_TLS_KEY":         &c.TLS.Key,
+		"PASSKEY_RP_ID":           &c.RelyingParty.ID,
+		"PASSKEY_RP_NAME":         &c.RelyingParty.DisplayName,
+		"PASSKEY_STORE":           &c.Storage.Kind,
+		"PASSKEY_DB":              &c.Storage.Path,
+		"PASSKEY_AAGUID_FILE":     &c.AAGUIDFile,
+		"PASSKEY_ATTESTATION":     &c.Attestation.Conveyance,
+		"PASSKEY_MDS_BLOB":        &c.Attestation.MDSBlob,
+		"PASSKEY_MDS_ROOT":        &c.Attestation.MDSRoot,
+		"PASSKEY_POLICY":          &c.PolicyFile,
+		"PASSKEY_COOKIE_SAMESITE": &c.Cookies.SameSite,
+		"PASSKEY_LOG_FORMAT":      &c.Logging.Format,
+		"PASSKEY_LOG_LEVEL":       &c.Logging.Level,
+		"PASSKEY_ADMIN_TOKEN":     &c.Admin.Token,
+		"PASSKEY_CLONE_POLICY":    &c.Security.ClonePolicy,
+		"PASSKEY_TRANSACTION_KEY": &c.Security.TransactionKey,
+	}
+	for name, target := range stringVars {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+
+	if value, ok := os.LookupEnv("PASSKEY_RP_ORIGINS"); ok {
+		c.RelyingParty.Origins = splitList(value)
+	}
+	if value, ok := os.LookupEnv("PASSKEY_RP_RELATED_ORIGINS"); ok {
+		c.RelyingParty.RelatedOrigins = splitList(value)
+	}
+	if value, ok := os.LookupEnv("PASSKEY_TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = splitList(value)
+	}
+
+	if value, ok := os.LookupEnv("PASSKEY_APPLE_APP_IDS"); ok {
+		apps, err := parseAppleAppIDs(value)
+		if err != nil {
+			return fmt.Errorf("invalid PASSKEY_APPLE_APP_IDS: %w", err)
+		}
+		c.Apple.Apps = apps
+	}
+
+	if value, ok := os.LookupEnv("PASSKEY_ANDROID_APPS"); ok {
+		apps, err := parseAndroidApps(value)
+		if err != nil {
+			return fmt.Errorf("invalid PASSKEY_ANDROID_APPS: %w", err)
+		}
+		c.Android.Apps = apps
+	}
+
+	boolVars := map[string]*bool{
+		"PASSKEY_TLS":           &c.TLS.Enabled,
+		"PASSKEY_COOKIE_SECURE": &c.Cookies.Secure,
+		"PASSKEY_MDS_ENFORCE":   &c.Attestation.MDSEnforce,
+		"PASSKEY_RATE_LIMIT":    &c.RateLimit.Enabled,
+	}
+	for name, target := range boolVars {
+		if value, ok := os.LookupEnv(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %w", name, value, err)
+			}
+			*target = parsed
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the configuration before anything is started.
+//
+// The most important rule comes from the WebAuthn spec: the RP ID must be
+// the host of every origin or a registrable domain suffix of it, and may not
+// be a public suffix such as "com" or "ngrok.io". Browsers reject ceremonies
+// that break it, so it is caught here instead of on the first login.
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	if err := c.TLS.validate(c.Listen); err != nil {
+		return err
+	}
+	if c.RelyingParty.DisplayName == "" {
+		return fmt.Errorf("relyingParty.displayName is required")
+	}
+	if err := validateRPID(c.RelyingParty.ID); err != nil {
+		return err
+	}
+	if len(c.RelyingParty.Origins) == 0 {
+		return fmt.Errorf("relyingParty.origins must list at least one origin")
+	}
+	for _, origin := range c.RelyingParty.Origins {
+		if err := validateOrigin(origin, c.RelyingParty.ID); err != nil {
+			return err
+		}
+	}
+	if err := validateRelatedOrigins(c.RelyingParty.RelatedOrigins, c.RelyingParty.ID); err != nil {
+		return err
+	}
+
+	for _, app := range c.Apple.Apps {
+		if err := app.validate(); err != nil {
+			return err
+		}
+	}
+	for _, app := range c.Android.Apps {
+		if err := app.validate(); err != nil {
+			return err
+		}
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"timeouts.registration", c.Timeouts.Registration},
+		{"timeouts.login", c.Timeouts.Login},
+		{"sessions.ceremony", c.Sessions.Ceremony},
+		{"sessions.lifetime", c.Sessions.Lifetime},
+		{"sessions.idleTimeout", c.Sessions.IdleTimeout},
+		{"sessions.reauthWindow", c.Sessions.ReauthWindow},
+		{"shutdownTimeout", c.Shutdown},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+	if c.DrainDelay < 0 {
+		return fmt.Errorf("drainDelay must not be negative, got %s", c.DrainDelay)
+	}
+
+	if _, err := c.Cookies.sameSite(); err != nil {
+		return err
+	}
+	if strings.EqualFold(c.Cookies.SameSite, "none") && !c.Cookies.Secure {
+		return fmt.Errorf("cookies.sameSite=none requires cookies.secure=true")
+	}
+
+	switch c.Storage.Kind {
+	case "memory":
+	case "sqlite":
+		if c.Storage.Path == "" {
+			return fmt.Errorf("storage.path is required with storage.kind=sqlite")
+		}
+	default:
+		return fmt.Errorf("storage.kind must be memory or sqlite, got %q", c.Storage.Kind)
+	}
+
+	switch c.Attestation.Conveyance {
+	case "none", "direct":
+	default:
+		return fmt.Errorf("attestation.conveyance must be none or direct, got %q", c.Attestation.Conveyance)
+	}
+
+	switch strings.ToLower(c.Logging.Format) {
+	case "text", "json", "console":
+	default:
+		return fmt.Errorf("logging.format must be text, json or console, got %q", c.Logging.Format)
+	}
+	if _, err := c.Logging.level(); err != nil {
+		return err
+	}
+
+	switch c.Security.ClonePolicy {
+	case clonePolicyFlag, clonePolicyStepUp, clonePolicySuspend:
+	default:
+		return fmt.Errorf("security.clonePolicy must be flag, step-up or suspend, got %q", c.Security.ClonePolicy)
+	}
+	if err := c.Security.Lockout.validate(); err != nil {
+		return err
+	}
+	if err := c.Security.RecoveryFailures.validate("security.recoveryFailures"); err != nil {
+		return err
+	}
+	if _, err := parseTransactionKey(c.Security.TransactionKey); err != nil {
+		return err
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < minAdminTokenLength {
+		return fmt.Errorf("admin.token must be at least %d characters", minAdminTokenLength)
+	}
+
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validate checks that an enabled lockout has sensible durations.
+func (l LockoutConfig) validate() error {
+	switch {
+	case l.Threshold < 0:
+		return fmt.Errorf("security.lockout.threshold may not be negative")
+	case l.Threshold == 0:
+		return nil
+	case l.Duration <= 0:
+		return fmt.Errorf("security.lockout.duration must be positive, got %s", l.Duration)
+	case l.MaxDuration < l.Duration:
+		return fmt.Errorf("security.lockout.maxDuration must be at least duration (%s)", l.Duration)
+	}
+	return nil
+}
+
+// validate checks that every budget is either unlimited or has a positive
+// period.
+func (r RateLimitConfig) validate() error {
+	if err := r.Default.validate("rateLimit.default"); err != nil {
+		return err
+	}
+	for path, limit := range r.Routes {
+		if !strings.HasPrefix(path, "/api/") {
+			return fmt.Errorf("rateLimit.routes: %q is not an API path", path)
+		}
+		if err := limit.IP.validate("rateLimit.routes[" + path + "].ip"); err != nil {
+			return err
+		}
+		if err := limit.User.validate("rateLimit.routes[" + path + "].user"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l RateLimit) validate(name string) error {
+	switch {
+	case l.Requests < 0 || l.Burst < 0:
+		return fmt.Errorf("%s: requests and burst may not be negative", name)
+	case l.Requests > 0 && l.Per <= 0:
+		return fmt.Errorf("%s.per must be positive", name)
+	}
+	return nil
+}
+
+// validate checks that TLS has either a complete cert/key pair or somewhere
+// to keep generated certificates, and that the redirect listener does not
+// collide with the HTTPS one.
+func (t TLSConfig) validate(listen string) error {
+	if !t.Enabled {
+		return nil
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return fmt.Errorf("tls.cert and tls.key must be set together")
+	}
+	if t.Cert == "" && t.Dir == "" {
+		return fmt.Errorf("tls.dir is required to generate a development certificate")
+	}
+	if t.RedirectListen != "" && t.RedirectListen == listen {
+		return fmt.Errorf("tls.redirectListen must differ from listen (%s)", listen)
+	}
+	return nil
+}
+
+// validateRPID checks that id is a bare domain that can own credentials.
+func validateRPID(id string) error {
+	if id == "" {
+		return fmt.Errorf("relyingParty.id is required")
+	}
+	if strings.ContainsAny(id, ":/") || id != strings.ToLower(id) {
+		return fmt.Errorf("relyingParty.id %q must be a lowercase domain without scheme or port", id)
+	}
+	if id == "localhost" {
+		return nil
+	}
+	if net.ParseIP(id) != nil {
+		return fmt.Errorf("relyingParty.id %q must be a domain, not an IP address", id)
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(id); suffix == id {
+		return fmt.Errorf("relyingParty.id %q is a public suffix and cannot be used as an RP ID", id)
+	}
+	return nil
+}
+
+// validateOrigin checks that origin is a secure context whose host is rpID
+// or a subdomain of it. Android app origins are only checked for form, since
+// they are bound to the RP ID through assetlinks.json instead.
+func validateOrigin(origin, rpID string) error {
+	if hash, ok := strings.CutPrefix(origin, androidOriginPrefix); ok {
+		if raw, err := base64.RawURLEncoding.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("origin %q is not a valid Android APK key hash", origin)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("origin %q is not a valid URL", origin)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("origin %q must not include a path", origin)
+	}
+
+	host := u.Hostname()
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host != "localhost" {
+			return fmt.Errorf("origin %q must use https (plain http is only allowed for localhost)", origin)
+		}
+	default:
+		return fmt.Errorf("origin %q must use https", origin)
+	}
+
+	if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+		return fmt.Errorf("relyingParty.id %q is not a registrable suffix of origin %q", rpID, origin)
+	}
+	return nil
+}
+
+// maxRelatedOriginLabels is the number of distinct registrable domain labels
+// browsers guarantee to honour in /.well-known/webauthn. Origins beyond the
+// limit are silently ignored, so the list is capped at startup instead.
+//
+// See: https://w3c.github.io/webauthn/#sctn-related-origins
+const maxRelatedOriginLabels = 5
+
+// validateRelatedOrigins checks the origins published for Related Origin
+// Requests.
+//
+// Each must be an https origin outside the RP ID (origins under it need no
+// opt-in and belong in relyingParty.origins), and together they may span at
+// most maxRelatedOriginLabels labels. The label is the registrable domain
+// minus its public suffix, so example.de and example.co.uk both count as
+// "example".
+func validateRelatedOrigins(origins []string, rpID string) error {
+	if len(origins) > 0 && rpID == "localhost" {
+		return fmt.Errorf("relyingParty.relatedOrigins requires a public RP ID, not localhost")
+	}
+
+	labels := map[string]bool{}
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("related origin %q is not a valid URL", origin)
+		}
+		if u.Scheme != "https" {
+			return fmt.Errorf("related origin %q must use https", origin)
+		}
+		if u.Path != "" && u.Path != "/" {
+			return fmt.Errorf("related origin %q must not include a path", origin)
+		}
+
+		host := u.Hostname()
+		if host == rpID || strings.HasSuffix(host, "."+rpID) {
+			return fmt.Errorf("related origin %q is already covered by relyingParty.id %q; list it in relyingParty.origins", origin, rpID)
+		}
+		registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+		if err != nil {
+			return fmt.Errorf("related origin %q has no registrable domain: %w", origin, err)
+		}
+		label, _, _ := strings.Cut(registrable, ".")
+		labels[label] = true
+	}
+
+	if len(labels) > maxRelatedOriginLabels {
+		return fmt.Errorf("relyingParty.relatedOrigins spans %d domain labels; browsers only honour %d", len(labels), maxRelatedOriginLabels)
+	}
+	return nil
+}
+
+// Apple identifier formats, checked at startup because iOS silently ignores
+// AASA entries it cannot parse.
+var (
+	appleTeamIDPattern   = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	appleBundleIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+$`)
+)
+
+// AppID returns the app identifier used in AASA: TeamID.BundleID.
+func (a AppleApp) AppID() string {
+	return a.TeamID + "." + a.BundleID
+}
+
+// validate checks the Team ID and bundle ID formats.
+func (a AppleApp) validate() error {
+	if !appleTeamIDPattern.MatchString(a.TeamID) {
+		return fmt.Errorf("apple app %s: team ID must be 10 uppercase letters or digits", a.AppID())
+	}
+	if !appleBundleIDPattern.MatchString(a.BundleID) {
+		return fmt.Errorf("apple app %s: invalid bundle ID %q", a.AppID(), a.BundleID)
+	}
+	if !a.WebCredentials && len(a.AppLinks) == 0 && !a.AppClip {
+		return fmt.Errorf("apple app %s: enable webCredentials, appLinks or appClip", a.AppID())
+	}
+	return nil
+}
+
+// parseAppleAppIDs parses "TEAMID.bundle.id,..." from the environment into
+// apps that share web credentials.
+func parseAppleAppIDs(value string) ([]AppleApp, error) {
+	var apps []AppleApp
+	for _, appID := range splitList(value) {
+		teamID, bundleID, ok := strings.Cut(appID, ".")
+		if !ok {
+			return nil, fmt.Errorf("%q must be TEAMID.bundle.id", appID)
+		}
+		apps = append(apps, AppleApp{TeamID: teamID, BundleID: bundleID, WebCredentials: true})
+	}
+	return apps, nil
+}
+
+// androidOriginPrefix starts the origin reported for native Android apps.
+const androidOriginPrefix = "android:apk-key-hash:"
+
+// androidPackagePattern matches Java-style Android application IDs.
+var androidPackagePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)+$`)
+
+// validate checks the package name and signing certificate fingerprints.
+func (a AndroidApp) validate() error {
+	if !androidPackagePattern.MatchString(a.PackageName) {
+		return fmt.Errorf("android app %q: invalid package name", a.PackageName)
+	}
+	if len(a.SHA256CertFingerprints) == 0 {
+		return fmt.Errorf("android app %s: at least one sha256CertFingerprint is required", a.PackageName)
+	}
+
+	for _, fingerprint := range a.SHA256CertFingerprints {
+		if _, err := parseCertFingerprint(fingerprint); err != nil {
+			return fmt.Errorf("android app %s: %w", a.PackageName, err)
+		}
+	}
+	return nil
+}
+
+// normalize rewrites fingerprints to the uppercase, colon-separated form
+// used by assetlinks.json. Unparseable ones are left for validate to report.
+func (a *AndroidApp) normalize() {
+	for i, fingerprint := range a.SHA256CertFingerprints {
+		if raw, err := parseCertFingerprint(fingerprint); err == nil {
+			a.SHA256CertFingerprints[i] = formatCertFingerprint(raw)
+		}
+	}
+}
+
+// Origins returns the android:apk-key-hash origins of the app's signing
+// certificates: the unpadded base64url SHA-256 fingerprint.
+func (a AndroidApp) Origins() []string {
+	origins := make([]string, 0, len(a.SHA256CertFingerprints))
+	for _, fingerprint := range a.SHA256CertFingerprints {
+		raw, err := parseCertFingerprint(fingerprint)
+		if err != nil {
+			continue // Rejected by Validate
+		}
+		origins = append(origins, androidOriginPrefix+base64.RawURLEncoding.EncodeToString(raw))
+	}
+	return origins
+}
+
+// parseCertFingerprint decodes a SHA-256 fingerprint with or without colons.
+func parseCertFingerprint(fingerprint string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(raw) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 certificate fingerprint %q", fingerprint)
+	}
+	return raw, nil
+}
+
+// formatCertFingerprint renders a fingerprint as "AB:CD:...".
+func formatCertFingerprint(raw []byte) string {
+	parts := make([]string, len(raw))
+	for i, b := range raw {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// parseAndroidApps parses "package=fingerprint,..." from the environment.
+// Repeating a package adds another signing certificate to it.
+func parseAndroidApps(value string) ([]AndroidApp, error) {
+	var apps []AndroidApp
+	index := map[string]int{}
+	for _, item := range splitList(value) {
+		name, fingerprint, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must be package=fingerprint", item)
+		}
+		i, seen := index[name]
+		if !seen {
+			i = len(apps)
+			index[name] = i
+			apps = append(apps, AndroidApp{PackageName: name})
+		}
+		apps[i].SHA256CertFingerprints = append(apps[i].SHA256CertFingerprints, fingerprint)
+	}
+	return apps, nil
+}
+
+// BrowserOrigins returns the web origins whose pages call the API: the
+// configured origins plus the related origins on other domains.
+func (c *Config) BrowserOrigins() []string {
+	return append(append([]string(nil), c.RelyingParty.Origins...), c.RelyingParty.RelatedOrigins...)
+}
+
+// WebAuthnOrigins returns every origin accepted in client data: the
+// configured web origins, the related origins on other domains and the
+// origins of the configured Android apps.
+func (c *Config) WebAuthnOrigins() []string {
+	origins := c.BrowserOrigins()
+	for _, app := range c.Android.Apps {
+		origins = append(origins, app.Origins()...)
+	}
+	return origins
+}
+
+// sameSite converts the configured SameSite mode for http.Cookie.
+func (c CookiesConfig) sameSite() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("cookies.sameSite must be strict, lax or none, got %q", c.SameSite)
+	}
+}
+
+// level parses the configured minimum log level.
+func (c LoggingConfig) level() (slog.Level, error) {
+	switch strings.ToLower(c.Level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Level)
+	}
+}
+
+// newCookie builds a session cookie with the configured attributes.
+// A negative maxAge deletes the cookie.
+func (c CookiesConfig) newCookie(name, value string, maxAge time.Duration) *http.Cookie {
+	sameSite, _ := c.sameSite() // Checked by Validate
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.Secure,
+		SameSite: sameSite,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// WriteYAML prints the configuration in the format accepted by -config,
+// with the admin token and transaction key masked.
+func (c *Config) WriteYAML(w io.Writer) error {
+	printed := *c
+	if printed.Admin.Token != "" {
+		printed.Admin.Token = "<redacted>"
+	}
+	if printed.Security.TransactionKey != "" {
+		printed.Security.TransactionKey = "<redacted>"
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&printed); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// splitList parses a comma-separated flag or environment value.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
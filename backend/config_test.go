@@ -0,0 +1,261 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateRelyingParty(t *testing.T) {
+	tests := []struct {
+		name    string
+		rpID    string
+		origins []string
+		wantErr string // Substring of the error, or "" for a valid config
+	}{
+		{"localhost", "localhost", []string{"http://localhost:5173"}, ""},
+		{"same host", "example.com", []string{"https://example.com"}, ""},
+		{"subdomain origin", "example.com", []string{"https://login.example.com", "https://example.com:8443"}, ""},
+		{"not a suffix", "example.com", []string{"https://example.org"}, "not a registrable suffix"},
+		{"suffix without dot", "example.com", []string{"https://badexample.com"}, "not a registrable suffix"},
+		{"origin is parent", "login.example.com", []string{"https://example.com"}, "not a registrable suffix"},
+		{"public suffix", "ngrok.io", []string{"https://abc.ngrok.io"}, "public suffix"},
+		{"ip address", "192.0.2.1", []string{"https://192.0.2.1"}, "not an IP address"},
+		{"scheme in rp id", "https://example.com", []string{"https://example.com"}, "without scheme or port"},
+		{"plain http", "example.com", []string{"http://example.com"}, "must use https"},
+		{"origin with path", "example.com", []string{"https://example.com/app"}, "must not include a path"},
+		{"no origins", "example.com", nil, "at least one origin"},
+		{"android origin", "example.com", []string{"https://example.com", testAndroidOrigin}, ""},
+		{"bad android origin", "example.com", []string{"android:apk-key-hash:AAEC"}, "not a valid Android APK key hash"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RelyingParty.ID = tc.rpID
+			cfg.RelyingParty.Origins = tc.origins
+
+			err := cfg.Validate()
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Validate() = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "listen: \":9000\"\n" +
+		"relyingParty:\n" +
+		"  id: file.example.com\n" +
+		"  displayName: From file\n" +
+		"  origins: [\"https://file.example.com\"]\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NGROK_URL", "")
+	t.Setenv("PASSKEY_RP_NAME", "From env")
+	t.Setenv("PASSKEY_LISTEN", ":9100")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-listen", ":9200"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(fs, flags)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.RelyingParty.ID != "file.example.com" {
+		t.Errorf("RelyingParty.ID = %q, want the file value", cfg.RelyingParty.ID)
+	}
+	if cfg.RelyingParty.DisplayName != "From env" {
+		t.Errorf("RelyingParty.DisplayName = %q, want the env value", cfg.RelyingParty.DisplayName)
+	}
+	if cfg.Listen != ":9200" {
+		t.Errorf("Listen = %q, want the flag value", cfg.Listen)
+	}
+	if cfg.Sessions.Lifetime != DefaultConfig().Sessions.Lifetime {
+		t.Errorf("Sessions.Lifetime = %s, want the default", cfg.Sessions.Lifetime)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestValidateRelatedOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		rpID    string
+		origins []string
+		wantErr string // Substring of the error, or "" for a valid list
+	}{
+		{"none", "localhost", nil, ""},
+		{"other domains", "example.com", []string{"https://example.de", "https://shop.example.co.uk"}, ""},
+		{"five labels", "example.com", []string{"https://a.com", "https://b.com", "https://c.com", "https://d.com", "https://e.com", "https://e.de"}, ""},
+		{"six labels", "example.com", []string{"https://a.com", "https://b.com", "https://c.com", "https://d.com", "https://e.com", "https://f.com"}, "spans 6 domain labels"},
+		{"localhost rp id", "localhost", []string{"https://example.de"}, "requires a public RP ID"},
+		{"plain http", "example.com", []string{"http://example.de"}, "must use https"},
+		{"with path", "example.com", []string{"https://example.de/app"}, "must not include a path"},
+		{"under rp id", "example.com", []string{"https://login.example.com"}, "already covered"},
+		{"public suffix", "example.com", []string{"https://co.uk"}, "no registrable domain"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRelatedOrigins(tc.origins, tc.rpID)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("validateRelatedOrigins() = %v, want nil", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("validateRelatedOrigins() = %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAppleAppValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		app     AppleApp
+		wantErr bool
+	}{
+		{"web credentials", AppleApp{TeamID: "ABCDE12345", BundleID: "com.passkey.demo.ios", WebCredentials: true}, false},
+		{"app clip", AppleApp{TeamID: "ABCDE12345", BundleID: "com.passkey.demo.ios.Clip", AppClip: true}, false},
+		{"short team id", AppleApp{TeamID: "ABCDE", BundleID: "com.passkey.demo.ios", WebCredentials: true}, true},
+		{"lowercase team id", AppleApp{TeamID: "abcde12345", BundleID: "com.passkey.demo.ios", WebCredentials: true}, true},
+		{"bad bundle id", AppleApp{TeamID: "ABCDE12345", BundleID: "passkey", WebCredentials: true}, true},
+		{"no service", AppleApp{TeamID: "ABCDE12345", BundleID: "com.passkey.demo.ios"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.app.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAppleAppIDs(t *testing.T) {
+	apps, err := parseAppleAppIDs("ABCDE12345.com.passkey.demo.ios, ABCDE12345.com.passkey.other")
+	if err != nil {
+		t.Fatalf("parseAppleAppIDs: %v", err)
+	}
+	if len(apps) != 2 || apps[0].AppID() != "ABCDE12345.com.passkey.demo.ios" || !apps[0].WebCredentials ||
+		apps[1].BundleID != "com.passkey.other" {
+		t.Errorf("parseAppleAppIDs = %+v", apps)
+	}
+	if _, err := parseAppleAppIDs("ABCDE12345"); err == nil {
+		t.Error("parseAppleAppIDs accepted an ID without a bundle ID")
+	}
+}
+
+// A signing certificate fingerprint and the android:apk-key-hash origin
+// Credential Manager reports for it.
+const (
+	testFingerprint   = "00:01:02:03:04:05:06:07:08:09:0A:0B:0C:0D:0E:0F:10:11:12:13:14:15:16:17:18:19:1A:1B:1C:1D:1E:1F"
+	testAndroidOrigin = "android:apk-key-hash:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+)
+
+func TestAndroidAppValidateNormalize(t *testing.T) {
+	app := AndroidApp{
+		PackageName:            "com.passkeydemo.android",
+		SHA256CertFingerprints: []string{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+	}
+	if err := app.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	app.normalize()
+	if app.SHA256CertFingerprints[0] != testFingerprint {
+		t.Errorf("fingerprint = %q, want %q", app.SHA256CertFingerprints[0], testFingerprint)
+	}
+
+	invalid := []AndroidApp{
+		{PackageName: "passkeydemo", SHA256CertFingerprints: []string{testFingerprint}},
+		{PackageName: "com.passkeydemo.android"},
+		{PackageName: "com.passkeydemo.android", SHA256CertFingerprints: []string{"AB:CD"}},
+	}
+	for _, app := range invalid {
+		if err := app.validate(); err == nil {
+			t.Errorf("validate(%+v) = nil, want an error", app)
+		}
+	}
+}
+
+func TestWebAuthnOrigins(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RelyingParty.Origins = []string{"https://example.com"}
+	cfg.RelyingParty.RelatedOrigins = []string{"https://example.de"}
+	cfg.Android.Apps = []AndroidApp{{PackageName: "com.passkeydemo.android", SHA256CertFingerprints: []string{testFingerprint}}}
+
+	want := []string{"https://example.com", "https://example.de", testAndroidOrigin}
+	if got := cfg.WebAuthnOrigins(); !slices.Equal(got, want) {
+		t.Errorf("WebAuthnOrigins() = %q, want %q", got, want)
+	}
+	// Native apps do not make CORS requests
+	if got := cfg.BrowserOrigins(); !slices.Equal(got, want[:2]) {
+		t.Errorf("BrowserOrigins() = %q, want %q", got, want[:2])
+	}
+	if len(cfg.RelyingParty.Origins) != 1 {
+		t.Errorf("WebAuthnOrigins modified RelyingParty.Origins: %q", cfg.RelyingParty.Origins)
+	}
+}
+
+func TestParseAndroidApps(t *testing.T) {
+	apps, err := parseAndroidApps("com.a.app=" + testFingerprint + ",com.b.app=AA, com.a.app=BB")
+	if err != nil {
+		t.Fatalf("parseAndroidApps: %v", err)
+	}
+	if len(apps) != 2 || apps[0].PackageName != "com.a.app" || len(apps[0].SHA256CertFingerprints) != 2 ||
+		apps[1].PackageName != "com.b.app" || len(apps[1].SHA256CertFingerprints) != 1 {
+		t.Errorf("parseAndroidApps = %+v", apps)
+	}
+	if _, err := parseAndroidApps("com.a.app"); err == nil {
+		t.Error("parseAndroidApps accepted an item without a fingerprint")
+	}
+}
+
+func TestLoadConfigRateLimitRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "rateLimit:\n" +
+		"  routes:\n" +
+		"    /api/login/begin:\n" +
+		"      ip: {requests: 100, per: 1m}\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NGROK_URL", "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := registerConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(fs, flags)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	// The listed path replaces its whole entry, including the user budget
+	login := cfg.RateLimit.Routes["/api/login/begin"]
+	if login.IP.Requests != 100 || !login.User.unlimited() {
+		t.Errorf("/api/login/begin = %+v, want only the file's ip budget", login)
+	}
+	// Other routes keep their defaults
+	defaults := DefaultConfig().RateLimit.Routes
+	for route, want := range defaults {
+		if route == "/api/login/begin" {
+			continue
+		}
+		if got := cfg.RateLimit.Routes[route]; got != want {
+			t.Errorf("%s = %+v, want default %+v", route, got, want)
+		}
+	}
+}
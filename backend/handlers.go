@@ -21,13 +21,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -85,24 +93,50 @@ type LoginBeginRequest struct {
 	Username string `json:"username,omitempty"` // Optional: specific user for traditional login
 }
 
+// RegisterFinishExtras holds optional fields a client may send to a
+// registration finish endpoint alongside (or instead of) the credential.
+//
+// Nickname names the new passkey; when omitted one is derived from the
+// User-Agent (e.g. "Safari on iPhone").
+//
+// ClientError lets the client report that the browser rejected the ceremony
+// instead of posting a credential. The most useful case is InvalidStateError,
+// which means the authenticator matched an entry in excludeCredentials and
+// already holds a passkey for this account.
+type RegisterFinishExtras struct {
+	Nickname    string `json:"nickname,omitempty"` // Optional: user-chosen passkey name
+	ClientError *struct {
+		Name    string `json:"name"`    // DOMException name, e.g. "InvalidStateError"
+		Message string `json:"message"` // Browser-provided message (logged only)
+	} `json:"clientError,omitempty"`
+}
+
+// RenamePasskeyRequest is the body of PATCH /api/user/passkeys/{id}.
+//
+// An empty name clears the nickname so the generated name is shown again.
+type RenamePasskeyRequest struct {
+	Name string `json:"name"` // New nickname
+}
+
 // ErrorResponse provides structured error information for API responses.
 //
 // This pattern ensures consistent error handling across all endpoints and
 // enables client applications to handle errors programmatically.
 type ErrorResponse struct {
-	Error   string `json:"error"`           // Human-readable error message
-	Code    string `json:"code,omitempty"`  // Machine-readable error code
+	Error   string `json:"error"`             // Human-readable error message
+	Code    string `json:"code,omitempty"`    // Machine-readable error code
 	Details string `json:"details,omitempty"` // Additional error context
 }
 
 // Username validation regex pattern for security and usability.
 //
 // Pattern breakdown:
-//   ^[a-zA-Z0-9._-]{3,30}$
-//   ^ = start of string
-//   [a-zA-Z0-9._-] = allowed characters (letters, numbers, dots, hyphens, underscores)
-//   {3,30} = length between 3 and 30 characters
-//   $ = end of string
+//
+//	^[a-zA-Z0-9._-]{3,30}$
+//	^ = start of string
+//	[a-zA-Z0-9._-] = allowed characters (letters, numbers, dots, hyphens, underscores)
+//	{3,30} = length between 3 and 30 characters
+//	$ = end of string
 //
 // This pattern prevents:
 //   - SQL injection through special characters
@@ -120,10 +154,10 @@ var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]{3,30}$`)
 //  4. Cannot start/end with special characters (prevents confusion)
 //
 // Security Considerations:
-//  - Prevents injection attacks through special characters
-//  - Avoids Unicode normalization vulnerabilities
-//  - Blocks directory traversal attempts
-//  - Ensures consistent display across different systems
+//   - Prevents injection attacks through special characters
+//   - Avoids Unicode normalization vulnerabilities
+//   - Blocks directory traversal attempts
+//   - Ensures consistent display across different systems
 //
 // Returns nil if valid, or descriptive error if validation fails.
 func validateUsername(username string) error {
@@ -153,27 +187,62 @@ func validateUsername(username string) error {
 	return nil
 }
 
+// maxNicknameLength bounds passkey nicknames so they fit management screens.
+const maxNicknameLength = 64
+
+// validateNickname trims a passkey nickname and checks it is displayable.
+//
+// Validation Rules:
+//  1. Leading and trailing whitespace is removed
+//  2. At most maxNicknameLength characters (empty is allowed)
+//  3. No control characters (prevents log and UI injection)
+//
+// Returns the cleaned nickname, or an error if validation fails.
+func validateNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+
+	if utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return "", fmt.Errorf("passkey name must be no more than %d characters long", maxNicknameLength)
+	}
+
+	for _, r := range nickname {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("passkey name cannot contain control characters")
+		}
+	}
+
+	return nickname, nil
+}
+
 // SuccessResponse provides structured success information for API responses.
 //
 // This ensures consistent response format across all endpoints and makes
 // it easier for clients to handle successful operations.
 type SuccessResponse struct {
-	Message string      `json:"message"`           // Human-readable success message
-	Data    interface{} `json:"data,omitempty"`    // Optional response data
+	Message string      `json:"message"`        // Human-readable success message
+	Data    interface{} `json:"data,omitempty"` // Optional response data
 }
 
 // App encapsulates application dependencies and provides handler methods.
 //
 // This struct follows the dependency injection pattern, making the code:
-//  - Easier to test (dependencies can be mocked)
-//  - More maintainable (clear dependency relationships)
-//  - Thread-safe (all dependencies are immutable after creation)
+//   - Easier to test (dependencies can be mocked)
+//   - More maintainable (clear dependency relationships)
+//   - Thread-safe (all dependencies are immutable after creation)
 //
 // The App pattern is common in Go web applications and demonstrates
 // proper separation of concerns between HTTP handling and business logic.
 type App struct {
-	webAuthn *webauthn.WebAuthn // WebAuthn library instance with configuration
-	store    *InMemoryStore     // User and session storage (interface in production)
+	config   *Config              // Validated configuration
+	webAuthn *webauthn.WebAuthn   // WebAuthn library instance with configuration
+	store    Store                // User and session storage (in-memory or SQLite)
+	aaguids  *AAGUIDRegistry      // Authenticator model names (nil if not configured)
+	mds      *MetadataService     // FIDO MDS3 BLOB (nil if not configured)
+	policy   *AuthenticatorPolicy // Registration allow/deny rules (nil allows all)
+	draining atomic.Bool          // Set on shutdown so readiness fails while connections drain
+	limiter  *rateLimiter         // Token buckets for rateLimit budgets
+
+	approvalKey ed25519.PrivateKey // Signs transaction approvals (security.transactionKey)
 }
 
 // WebAuthn Registration Handlers
@@ -182,27 +251,37 @@ type App struct {
 // users to create new passkeys. The process involves two rounds:
 //  1. Begin: Generate challenge and return credential creation options
 //  2. Finish: Verify the new credential and store it
+//
+// Registration comes in two flavours with separate endpoints:
+//   - /api/register/*: create a brand new account with its first passkey
+//   - /api/user/passkeys/register/*: add a passkey to the logged-in account
+//
+// Keeping them apart means knowing a username is never enough to attach an
+// authenticator to somebody else's account.
 
-// handleRegisterBegin initiates the WebAuthn credential registration ceremony.
+// handleRegisterBegin initiates the WebAuthn credential registration ceremony
+// for a new account.
 //
 // This endpoint implements the first phase of WebAuthn registration:
 //  1. Validates the registration request (username, display name)
-//  2. Creates or retrieves the user account
-//  3. Generates WebAuthn credential creation options with passkey settings
-//  4. Creates a temporary session to store challenge data
-//  5. Returns options to client for credential creation
+//  2. Rejects usernames that are already taken (409 USER_EXISTS)
+//  3. Prepares the account without storing it yet
+//  4. Generates WebAuthn credential creation options with passkey settings
+//  5. Creates a temporary session holding the challenge and pending account
 //
 // WebAuthn Flow:
-//  Client -> POST /api/register/begin -> Server generates challenge
-//  Server -> Returns credential options -> Client calls navigator.credentials.create()
-//  Client -> Authenticator prompts user -> User provides biometric/PIN
-//  Client -> POST /api/register/finish -> Server verifies and stores credential
+//
+//	Client -> POST /api/register/begin -> Server generates challenge
+//	Server -> Returns credential options -> Client calls navigator.credentials.create()
+//	Client -> Authenticator prompts user -> User provides biometric/PIN
+//	Client -> POST /api/register/finish -> Server verifies, creates account, stores credential
 //
 // Security Features:
-//  - Input validation prevents injection attacks
-//  - Cryptographically secure challenge generation
-//  - Session timeout prevents replay attacks
-//  - Passkey configuration enforces strong authentication
+//   - Input validation prevents injection attacks
+//   - Existing accounts cannot be extended through this endpoint
+//   - Cryptographically secure challenge generation
+//   - Session timeout prevents replay attacks
+//   - Passkey configuration enforces strong authentication
 //
 // Request Body: RegisterBeginRequest (JSON)
 // Response: WebAuthn CredentialCreationOptions (JSON)
@@ -218,35 +297,244 @@ func (app *App) handleRegisterBegin(w http.ResponseWriter, r *http.Request) {
 		app.writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !app.allowUser(w, r, req.Username) {
+		return
+	}
 
-	// Create or get user
-	user, exists := app.store.GetUser(req.Username)
-	if !exists {
-		displayName := req.DisplayName
-		if displayName == "" {
-			displayName = req.Username
+	if _, exists := app.store.GetUser(req.Username); exists {
+		app.writeAppError(w, ErrUserExists, http.StatusConflict)
+		return
+	}
+
+	displayName := req.DisplayName
+	if displayName == "" {
+		displayName = req.Username
+	}
+
+	// The account is only stored once the first passkey is verified
+	user := NewUser(req.Username, displayName)
+
+	app.beginRegistration(w, r, user, &Session{
+		Ceremony:    ceremonyRegister,
+		PendingUser: user,
+	})
+}
+
+// handleRegisterFinish completes a new-account registration.
+//
+// The pending account from the begin step is created together with its first
+// credential. If the username was claimed by someone else in the meantime the
+// request fails with 409 USER_EXISTS and nothing is stored.
+func (app *App) handleRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyRegister)
+	if !ok {
+		return
+	}
+
+	user := session.PendingUser
+	if user == nil {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	extras, ok := app.readRegisterFinishExtras(w, r, sessionID, user)
+	if !ok {
+		return
+	}
+
+	// Finish registration
+	credential, err := app.webAuthn.FinishRegistration(user, session.SessionData, r)
+	if err != nil {
+		app.writeRegistrationError(w, r, user, err)
+		return
+	}
+	app.flagAuthenticatorStatus(r, user, credential)
+	if !app.enforcePolicy(w, r, sessionID, user, credential) {
+		return
+	}
+
+	record := NewCredentialRecord(credential, r)
+	record.Nickname = extras.Nickname
+	user.Credentials = append(user.Credentials, record)
+	if err := app.store.CreateUser(user); err != nil {
+		if err == ErrUserExists {
+			app.writeAppError(w, ErrUserExists, http.StatusConflict)
+		} else {
+			app.writeError(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
 		}
+		return
+	}
+	logCredential(r.Context(), credential.ID)
+	logger.InfoContext(r.Context(), "New account registered")
+	app.audit(r, AuditEvent{
+		Type:         auditRegistration,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credential.ID),
+		Outcome:      auditSuccess,
+	})
 
-		var err error
-		user, err = app.store.CreateUser(req.Username, displayName)
-		if err != nil {
-			app.writeError(w, err.Error(), http.StatusConflict)
+	// Recovery codes are shown once, with the registration result. The
+	// account works without them; the user can generate a set later.
+	codes, err := app.issueRecoveryCodes(r, user)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to generate recovery codes", "error", err)
+	}
+
+	// Start login session (so user is logged in after registration)
+	if err := app.startLoginSession(w, r, user, credential); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to create login session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Clean up session
+	app.store.DeleteSession(sessionID)
+
+	app.writeSuccess(w, "Registration successful", map[string]interface{}{
+		"credentialId":  credential.ID,
+		"username":      user.Username,
+		"displayName":   user.DisplayName,
+		"userId":        user.ID,
+		"recoveryCodes": codes,
+	})
+}
+
+// handleAddPasskeyBegin starts registering an additional passkey for the
+// logged-in user.
+//
+// Requires a valid login session whose user verification happened within
+// the configured reauthentication window; otherwise 401 AUTH_REQUIRED or 403
+// REAUTH_REQUIRED is returned so the client can run the reauth ceremony
+// (see handleReauthBegin) before retrying.
+//
+// Response: WebAuthn CredentialCreationOptions (JSON)
+// HTTP Status: 200 (success), 401 (not logged in), 403 (verification too old), 500 (server error)
+func (app *App) handleAddPasskeyBegin(w http.ResponseWriter, r *http.Request) {
+	loginSession, user, ok := app.requireRecentVerification(w, r)
+	if !ok {
+		return
+	}
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	app.beginRegistration(w, r, user, &Session{
+		UserID:   loginSession.UserID,
+		Ceremony: ceremonyAddPasskey,
+	})
+}
+
+// handleAddPasskeyFinish verifies and stores an additional passkey for the
+// logged-in user.
+//
+// The ceremony session must belong to the same user as the login session, so
+// a challenge obtained by one account cannot be completed by another.
+func (app *App) handleAddPasskeyFinish(w http.ResponseWriter, r *http.Request) {
+	loginSession, ok := getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyAddPasskey)
+	if !ok {
+		return
+	}
+	if string(session.UserID) != string(loginSession.UserID) {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		app.writeError(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	extras, ok := app.readRegisterFinishExtras(w, r, sessionID, user)
+	if !ok {
+		return
+	}
+
+	// Finish registration
+	credential, err := app.webAuthn.FinishRegistration(user, session.SessionData, r)
+	if err != nil {
+		app.writeRegistrationError(w, r, user, err)
+		return
+	}
+	app.flagAuthenticatorStatus(r, user, credential)
+	if !app.enforcePolicy(w, r, sessionID, user, credential) {
+		return
+	}
+
+	// Clean up session
+	app.store.DeleteSession(sessionID)
+
+	record := NewCredentialRecord(credential, r)
+	record.Nickname = extras.Nickname
+
+	// excludeCredentials should stop this in the authenticator; the store
+	// catches clients that ignore it
+	if err := app.store.AddCredential(user.ID, record); err != nil {
+		if err == ErrCredentialExists {
+			logCredential(r.Context(), credential.ID)
+			logger.WarnContext(r.Context(), "Attempted to register duplicate credential")
+			app.writeAppError(w, ErrCredentialExists, http.StatusConflict)
 			return
 		}
+		app.writeError(w, fmt.Sprintf("Failed to save credential: %v", err), http.StatusInternalServerError)
+		return
 	}
+	logCredential(r.Context(), credential.ID)
+	logger.InfoContext(r.Context(), "New credential registered")
+	app.audit(r, AuditEvent{
+		Type:         auditPasskeyAdded,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credential.ID),
+		Outcome:      auditSuccess,
+	})
+
+	app.writeSuccess(w, "Passkey added successfully", map[string]interface{}{
+		"credentialId": credential.ID,
+		"username":     user.Username,
+		"displayName":  user.DisplayName,
+		"userId":       user.ID,
+	})
+}
+
+// beginRegistration generates creation options for user, stores the ceremony
+// session and writes the options to the client.
+//
+// Shared by new-account and add-passkey registration so both flows request
+// exactly the same authenticator properties.
+func (app *App) beginRegistration(w http.ResponseWriter, r *http.Request, user *User, session *Session) {
+	logUser(r.Context(), user.Username)
 
 	// Begin registration with best practice passkey configuration
 	// Force platform authenticators and resident keys for true passkey experience
 	options, sessionData, err := app.webAuthn.BeginRegistration(
 		user,
+		// Authenticators already registered to this user must refuse to
+		// create a second passkey (browser raises InvalidStateError)
+		webauthn.WithExclusions(user.CredentialDescriptors()),
 		// Required for passkeys: must be stored on device
 		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
 		// Best practice: platform authenticators with user verification
 		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
-			// Force platform authenticators (built-in biometrics)
-			AuthenticatorAttachment: protocol.Platform,
+			// Platform authenticators (built-in biometrics) unless the
+			// authenticator policy asks for something else
+			AuthenticatorAttachment: app.policy.RequestedAttachment(),
 			// Required for passkeys
-			ResidentKey: protocol.ResidentKeyRequirementRequired,
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
 			RequireResidentKey: protocol.ResidentKeyRequired(),
 			// Require user verification for security
 			UserVerification: protocol.VerificationRequired,
@@ -257,96 +545,148 @@ func (app *App) handleRegisterBegin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Comprehensive registration debugging
-	logger.Printf("=== REGISTRATION DEBUG INFO FOR %s ===", user.Username)
-	logger.Printf("AuthenticatorAttachment: %s", options.Response.AuthenticatorSelection.AuthenticatorAttachment)
-	logger.Printf("ResidentKey: %s", options.Response.AuthenticatorSelection.ResidentKey)
-	logger.Printf("RequireResidentKey: %t", *options.Response.AuthenticatorSelection.RequireResidentKey)
-	logger.Printf("UserVerification: %s", options.Response.AuthenticatorSelection.UserVerification)
-	logger.Printf("Attestation: %s", options.Response.Attestation)
-	logger.Printf("Timeout: %d ms", options.Response.Timeout)
-	logger.Printf("RPID: %s", options.Response.RelyingParty.ID)
-	logger.Printf("RPName: %s", options.Response.RelyingParty.Name)
-	logger.Printf("Challenge: %s", options.Response.Challenge)
-	logger.Printf("=========================================")
+	// Comprehensive registration debugging (-log-level=debug)
+	logger.DebugContext(r.Context(), "Registration options",
+		"ceremony", session.Ceremony,
+		"authenticator_attachment", options.Response.AuthenticatorSelection.AuthenticatorAttachment,
+		"resident_key", options.Response.AuthenticatorSelection.ResidentKey,
+		"require_resident_key", *options.Response.AuthenticatorSelection.RequireResidentKey,
+		"user_verification", options.Response.AuthenticatorSelection.UserVerification,
+		"attestation", options.Response.Attestation,
+		"timeout_ms", options.Response.Timeout,
+		"rp_id", options.Response.RelyingParty.ID,
+		"rp_name", options.Response.RelyingParty.Name,
+		"challenge", options.Response.Challenge.String(),
+		"exclude_credentials", len(options.Response.CredentialExcludeList),
+	)
 
 	// Store session
 	sessionID := uuid.New().String()
-	app.store.StoreSession(sessionID, user.ID, *sessionData)
-
-	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "webauthn-session",
-		Value:    sessionID,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   300, // 5 minutes
-	})
+	session.SessionData = *sessionData
+	if err := app.store.StoreSession(sessionID, session); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Set session cookie (expires with the ceremony session)
+	http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
 
 	// Return options to client
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(options)
 }
 
-func (app *App) handleRegisterFinish(w http.ResponseWriter, r *http.Request) {
-	sessionID, ok := getSessionID(r.Context())
-	if !ok {
-		app.writeError(w, "No session found", http.StatusBadRequest)
-		return
+// readRegisterFinishExtras decodes RegisterFinishExtras from a registration
+// finish request and handles client error reports.
+//
+// The request body is buffered and restored so FinishRegistration can still
+// parse the credential from it. The nickname is validated, or derived from
+// the User-Agent when the client sent none.
+//
+// If the client reported an error instead of a credential, the ceremony
+// session is discarded and a coded error is written: 409 CREDENTIAL_EXISTS
+// for InvalidStateError, 400 CEREMONY_ABORTED for anything else. ok is false
+// whenever a response has already been written.
+func (app *App) readRegisterFinishExtras(w http.ResponseWriter, r *http.Request, sessionID string, user *User) (extras RegisterFinishExtras, ok bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return extras, false
 	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
 
-	session, exists := app.store.GetSession(sessionID)
-	if !exists {
-		app.writeError(w, "Invalid or expired session", http.StatusBadRequest)
-		return
-	}
+	// A malformed body is reported by FinishRegistration, not here
+	_ = json.Unmarshal(body, &extras)
 
-	user, exists := app.store.GetUserByID(session.UserID)
-	if !exists {
-		app.writeError(w, "User not found", http.StatusBadRequest)
-		return
+	if extras.ClientError != nil {
+		app.store.DeleteSession(sessionID)
+		logger.InfoContext(r.Context(), "Registration aborted by client",
+			"client_error", extras.ClientError.Name,
+			"client_message", extras.ClientError.Message)
+
+		if extras.ClientError.Name == "InvalidStateError" {
+			app.writeAppError(w, ErrCredentialExists, http.StatusConflict)
+		} else {
+			app.writeAppError(w, ErrCeremonyAborted, http.StatusBadRequest)
+		}
+		return extras, false
 	}
 
-	// Finish registration
-	credential, err := app.webAuthn.FinishRegistration(user, session.SessionData, r)
+	extras.Nickname, err = validateNickname(extras.Nickname)
 	if err != nil {
-		app.writeError(w, fmt.Sprintf("Registration failed: %v", err), http.StatusBadRequest)
-		return
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return extras, false
+	}
+	if extras.Nickname == "" {
+		extras.Nickname = describeUserAgent(r.UserAgent())
 	}
 
-	// Check if this credential already exists (prevent duplicates)
-	credentialExists := false
-	for _, existingCred := range user.Credentials {
-		if string(existingCred.ID) == string(credential.ID) {
-			credentialExists = true
-			fmt.Printf("WARNING: Attempted to register duplicate credential for user %s, CredentialID: %s\n", 
-				user.Username, base64.URLEncoding.EncodeToString(credential.ID))
-			break
-		}
+	return extras, true
+}
+
+// writeRegistrationError reports a FinishRegistration failure.
+//
+// Rejections based on FIDO metadata (untrusted attestation, REVOKED or
+// compromised authenticators) get 403 UNTRUSTED_AUTHENTICATOR so clients can
+// tell them apart from malformed responses; the details are only logged.
+func (app *App) writeRegistrationError(w http.ResponseWriter, r *http.Request, user *User, err error) {
+	var protoErr *protocol.Error
+	if errors.As(err, &protoErr) && protoErr.Type == protocol.ErrMetadata.Type {
+		logger.WarnContext(r.Context(), "Rejected untrusted authenticator", "reason", protoErr.DevInfo)
+		app.writeAppError(w, ErrUntrustedAuthenticator, http.StatusForbidden)
+		return
 	}
 
-	// Only add credential if it doesn't already exist
-	if !credentialExists {
-		user.Credentials = append(user.Credentials, *credential)
-		app.store.UpdateUser(user)
-		fmt.Printf("SUCCESS: New credential registered for user %s, CredentialID: %s\n", 
-			user.Username, base64.URLEncoding.EncodeToString(credential.ID))
+	app.writeError(w, fmt.Sprintf("Registration failed: %v", err), http.StatusBadRequest)
+}
+
+// flagAuthenticatorStatus logs registrations from authenticators whose MDS
+// status reports are undesired. This only fires when the metadata service is
+// not enforcing, since otherwise FinishRegistration already rejected them.
+func (app *App) flagAuthenticatorStatus(r *http.Request, user *User, credential *webauthn.Credential) {
+	aaguid := fmt.Sprintf("%x", credential.Authenticator.AAGUID)
+	if err := app.mds.CheckStatus(aaguid); err != nil {
+		logger.WarnContext(r.Context(), "Registered a flagged authenticator", "aaguid", aaguid, "reason", err)
 	}
+}
 
-	// Set user session cookie (so user is logged in after registration)
-	app.setUserSession(w, user.Username)
+// enforcePolicy evaluates the authenticator policy for a verified credential.
+//
+// A denied credential ends the ceremony: the session is deleted and 403 is
+// written with the denying rule's error code. Returns false in that case.
+func (app *App) enforcePolicy(w http.ResponseWriter, r *http.Request, sessionID string, user *User, credential *webauthn.Credential) bool {
+	appErr := app.policy.Evaluate(credential)
+	if appErr == nil {
+		return true
+	}
 
-	// Clean up session
 	app.store.DeleteSession(sessionID)
+	logger.WarnContext(r.Context(), "Policy refused authenticator",
+		"aaguid", fmt.Sprintf("%x", credential.Authenticator.AAGUID),
+		"attachment", credential.Authenticator.Attachment,
+		"code", appErr.Code)
+	app.writeAppError(w, appErr, http.StatusForbidden)
+	return false
+}
 
-	app.writeSuccess(w, "Registration successful", map[string]interface{}{
-		"credentialId": credential.ID,
-		"username":     user.Username,
-		"displayName":  user.DisplayName,
-		"userId":       user.ID,
-	})
+// ceremonySession loads the WebAuthn session referenced by the request's
+// webauthn-session cookie and checks it was created for the given ceremony.
+//
+// On failure an error response has already been written and ok is false.
+func (app *App) ceremonySession(w http.ResponseWriter, r *http.Request, ceremony string) (sessionID string, session *Session, ok bool) {
+	sessionID, ok = getSessionID(r.Context())
+	if !ok {
+		app.writeError(w, "No session found", http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	session, exists := app.store.GetSession(sessionID)
+	if !exists || session.Ceremony != ceremony {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	return sessionID, session, true
 }
 
 // Authentication handlers
@@ -364,6 +704,10 @@ func (app *App) handleLoginBegin(w http.ResponseWriter, r *http.Request) {
 			app.writeError(w, "Authentication failed", http.StatusUnauthorized) // Don't reveal validation details
 			return
 		}
+		// Counted for unknown usernames too, so limits don't reveal which exist
+		if !app.allowUser(w, r, req.Username) {
+			return
+		}
 
 		// Traditional login with username
 		user, exists := app.store.GetUser(req.Username)
@@ -373,22 +717,33 @@ func (app *App) handleLoginBegin(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Traditional login with username - use best practices
-		options, sessionData, err := app.webAuthn.BeginLogin(
-			user,
+		loginOptions := []webauthn.LoginOption{
 			// Request user verification for security
 			webauthn.WithUserVerification(protocol.VerificationRequired),
-		)
-		
+		}
+		// A locked account only offers the passkeys that can unlock it
+		if until := app.lockedUntil(lockoutUserKey(user)); !until.IsZero() {
+			allowed := app.unlockingCredentials(user)
+			if len(allowed) == 0 {
+				app.writeLocked(w, until)
+				return
+			}
+			loginOptions = append(loginOptions, webauthn.WithAllowedCredentials(allowed))
+		}
+		options, sessionData, err := app.webAuthn.BeginLogin(user, loginOptions...)
+
 		if err == nil {
-			logger.Printf("=== TRADITIONAL LOGIN DEBUG INFO FOR %s ===", user.Username)
-			logger.Printf("UserVerification: %s", options.Response.UserVerification)
-			logger.Printf("Timeout: %d ms", options.Response.Timeout)
-			logger.Printf("RPID: %s", options.Response.RelyingPartyID)
-			logger.Printf("AllowCredentials count: %d", len(options.Response.AllowedCredentials))
+			allowed := make([]string, len(options.Response.AllowedCredentials))
 			for i, cred := range options.Response.AllowedCredentials {
-				logger.Printf("  Credential %d: ID=%s, Type=%s", i+1, base64.URLEncoding.EncodeToString(cred.CredentialID), cred.Type)
+				allowed[i] = encodeCredentialID(cred.CredentialID)
 			}
-			logger.Printf("============================================")
+			logUser(r.Context(), user.Username)
+			logger.DebugContext(r.Context(), "Login options",
+				"user_verification", options.Response.UserVerification,
+				"timeout_ms", options.Response.Timeout,
+				"rp_id", options.Response.RelyingPartyID,
+				"allow_credentials", allowed,
+			)
 		}
 		if err != nil {
 			app.writeError(w, fmt.Sprintf("Failed to begin login: %v", err), http.StatusInternalServerError)
@@ -397,17 +752,12 @@ func (app *App) handleLoginBegin(w http.ResponseWriter, r *http.Request) {
 
 		// Store session
 		sessionID := uuid.New().String()
-		app.store.StoreSession(sessionID, user.ID, *sessionData)
-
-		http.SetCookie(w, &http.Cookie{
-			Name:     "webauthn-session",
-			Value:    sessionID,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false,
-			SameSite: http.SameSiteStrictMode,
-			MaxAge:   300,
-		})
+		if err := app.store.StoreSession(sessionID, &Session{UserID: user.ID, Ceremony: ceremonyLogin, SessionData: *sessionData}); err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
 
 		json.NewEncoder(w).Encode(options)
 	} else {
@@ -416,15 +766,15 @@ func (app *App) handleLoginBegin(w http.ResponseWriter, r *http.Request) {
 			// Require user verification for security
 			webauthn.WithUserVerification(protocol.VerificationRequired),
 		)
-		
+
 		if err == nil {
-			logger.Printf("=== DISCOVERABLE LOGIN DEBUG INFO ===")
-			logger.Printf("UserVerification: %s", options.Response.UserVerification)
-			logger.Printf("Timeout: %d ms", options.Response.Timeout)
-			logger.Printf("RPID: %s", options.Response.RelyingPartyID)
-			logger.Printf("Challenge: %s", options.Response.Challenge)
-			logger.Printf("AllowCredentials count: %d", len(options.Response.AllowedCredentials))
-			logger.Printf("=====================================")
+			logger.DebugContext(r.Context(), "Discoverable login options",
+				"user_verification", options.Response.UserVerification,
+				"timeout_ms", options.Response.Timeout,
+				"rp_id", options.Response.RelyingPartyID,
+				"challenge", options.Response.Challenge.String(),
+				"allow_credentials", len(options.Response.AllowedCredentials),
+			)
 		}
 		if err != nil {
 			app.writeError(w, fmt.Sprintf("Failed to begin discoverable login: %v", err), http.StatusInternalServerError)
@@ -433,32 +783,20 @@ func (app *App) handleLoginBegin(w http.ResponseWriter, r *http.Request) {
 
 		// Store session without user ID for discoverable login
 		sessionID := uuid.New().String()
-		app.store.StoreSession(sessionID, nil, *sessionData)
-
-		http.SetCookie(w, &http.Cookie{
-			Name:     "webauthn-session",
-			Value:    sessionID,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   false,
-			SameSite: http.SameSiteStrictMode,
-			MaxAge:   300,
-		})
+		if err := app.store.StoreSession(sessionID, &Session{Ceremony: ceremonyLogin, SessionData: *sessionData}); err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
 
 		json.NewEncoder(w).Encode(options)
 	}
 }
 
 func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
-	sessionID, ok := getSessionID(r.Context())
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyLogin)
 	if !ok {
-		app.writeError(w, "No session found", http.StatusBadRequest)
-		return
-	}
-
-	session, exists := app.store.GetSession(sessionID)
-	if !exists {
-		app.writeError(w, "Invalid or expired session", http.StatusBadRequest)
 		return
 	}
 
@@ -470,11 +808,32 @@ func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		credential, err := app.webAuthn.FinishLogin(user, session.SessionData, r)
+		logUser(r.Context(), user.Username)
+		if !app.allowUser(w, r, user.Username) {
+			return
+		}
+		parsedResponse, err := protocol.ParseCredentialRequestResponse(r)
 		if err != nil {
 			app.writeError(w, fmt.Sprintf("Authentication failed: %v", err), http.StatusUnauthorized)
 			return
 		}
+		if !app.checkLockout(w, r, user, parsedResponse.RawID) {
+			return
+		}
+
+		credential, err := app.webAuthn.ValidateLogin(user, session.SessionData, parsedResponse)
+		if err != nil {
+			app.audit(r, AuditEvent{
+				Type:         auditLogin,
+				Username:     user.Username,
+				CredentialID: encodeCredentialID(parsedResponse.RawID),
+				Outcome:      auditFailure,
+				Detail:       assertionFailure(err),
+			})
+			app.recordLoginFailure(r, user, parsedResponse.RawID)
+			app.writeError(w, fmt.Sprintf("Authentication failed: %v", err), http.StatusUnauthorized)
+			return
+		}
 
 		// SECURITY: Verify the returned credential still exists in the user's current credential list
 		// This prevents authentication with deleted credentials that might still be in device keychain
@@ -485,25 +844,46 @@ func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
 				break
 			}
 		}
+		logCredential(r.Context(), credential.ID)
 		if !credentialExists {
-			fmt.Printf("SECURITY: Authentication attempt with deleted credential. User: %s, CredentialID: %s\n",
-				user.Username, base64.URLEncoding.EncodeToString(credential.ID))
+			logger.WarnContext(r.Context(), "Authentication attempt with deleted credential")
+			metrics.DeletedCredentialAttempts.Inc()
+			app.audit(r, AuditEvent{
+				Type:         auditDeletedCredential,
+				Username:     user.Username,
+				CredentialID: encodeCredentialID(credential.ID),
+				Outcome:      auditFailure,
+			})
 			app.writeError(w, "Authentication failed: credential no longer valid", http.StatusUnauthorized)
 			return
 		}
 
-		// Check for clone warning
-		if credential.Authenticator.CloneWarning {
-			// Log security event but allow login for demo
-			fmt.Printf("WARNING: Clone detected for user %s\n", user.Username)
+		// Apply the clone policy and refuse suspended credentials
+		if !app.checkCredentialStanding(w, r, ceremonyLogin, sessionID, user, credential, parsedResponse.Response.AuthenticatorData.Counter) {
+			return
 		}
+		app.clearLoginFailures(r, user, credential.ID)
 
 		// Update credential
-		app.updateUserCredential(user, credential)
+		if !app.updateUserCredential(w, r, user, credential) {
+			return
+		}
 
-		// Set user session cookie
-		app.setUserSession(w, user.Username)
+		// Start login session
+		if err := app.startLoginSession(w, r, user, credential); err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to create login session: %v", err), http.StatusInternalServerError)
+			return
+		}
 
+		metrics.Logins.Inc("username")
+		app.audit(r, AuditEvent{
+			Type:         auditLogin,
+			Actor:        user.Username,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(credential.ID),
+			Outcome:      auditSuccess,
+			Detail:       "username",
+		})
 		app.writeSuccess(w, "Authentication successful", map[string]interface{}{
 			"username":    user.Username,
 			"displayName": user.DisplayName,
@@ -526,8 +906,27 @@ func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Lockout applies to the account the response claims to belong to
+		owner, ownerExists := app.store.GetUserByID(parsedResponse.Response.UserHandle)
+		if ownerExists && !app.checkLockout(w, r, owner, parsedResponse.RawID) {
+			return
+		}
+
 		user, credential, err := app.webAuthn.ValidatePasskeyLogin(userHandler, session.SessionData, parsedResponse)
 		if err != nil {
+			event := AuditEvent{
+				Type:         auditLogin,
+				CredentialID: encodeCredentialID(parsedResponse.RawID),
+				Outcome:      auditFailure,
+				Detail:       assertionFailure(err),
+			}
+			if ownerExists {
+				event.Username = owner.Username
+			}
+			app.audit(r, event)
+			if ownerExists {
+				app.recordLoginFailure(r, owner, parsedResponse.RawID)
+			}
 			app.writeError(w, fmt.Sprintf("Discoverable authentication failed: %v", err), http.StatusUnauthorized)
 			return
 		}
@@ -535,6 +934,7 @@ func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
 		// SECURITY: Verify the returned credential still exists in the user's current credential list
 		// This prevents authentication with deleted credentials that might still be in device keychain
 		appUser := user.(*User)
+		logUser(r.Context(), appUser.Username)
 		credentialExists := false
 		for _, userCred := range appUser.Credentials {
 			if string(userCred.ID) == string(credential.ID) {
@@ -542,24 +942,46 @@ func (app *App) handleLoginFinish(w http.ResponseWriter, r *http.Request) {
 				break
 			}
 		}
+		logCredential(r.Context(), credential.ID)
 		if !credentialExists {
-			fmt.Printf("SECURITY: Authentication attempt with deleted credential. User: %s, CredentialID: %s\n",
-				appUser.Username, base64.URLEncoding.EncodeToString(credential.ID))
+			logger.WarnContext(r.Context(), "Authentication attempt with deleted credential")
+			metrics.DeletedCredentialAttempts.Inc()
+			app.audit(r, AuditEvent{
+				Type:         auditDeletedCredential,
+				Username:     appUser.Username,
+				CredentialID: encodeCredentialID(credential.ID),
+				Outcome:      auditFailure,
+			})
 			app.writeError(w, "Authentication failed: credential no longer valid", http.StatusUnauthorized)
 			return
 		}
 
-		// Check for clone warning
-		if credential.Authenticator.CloneWarning {
-			fmt.Printf("WARNING: Clone detected for user %s\n", user.WebAuthnName())
+		// Apply the clone policy and refuse suspended credentials
+		if !app.checkCredentialStanding(w, r, ceremonyLogin, sessionID, appUser, credential, parsedResponse.Response.AuthenticatorData.Counter) {
+			return
 		}
+		app.clearLoginFailures(r, appUser, credential.ID)
 
 		// Update credential
-		app.updateUserCredential(appUser, credential)
+		if !app.updateUserCredential(w, r, appUser, credential) {
+			return
+		}
 
-		// Set user session cookie
-		app.setUserSession(w, appUser.Username)
+		// Start login session
+		if err := app.startLoginSession(w, r, appUser, credential); err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to create login session: %v", err), http.StatusInternalServerError)
+			return
+		}
 
+		metrics.Logins.Inc("discoverable")
+		app.audit(r, AuditEvent{
+			Type:         auditLogin,
+			Actor:        appUser.Username,
+			Username:     appUser.Username,
+			CredentialID: encodeCredentialID(credential.ID),
+			Outcome:      auditSuccess,
+			Detail:       "discoverable",
+		})
 		app.writeSuccess(w, "Discoverable authentication successful", map[string]interface{}{
 			"username":    appUser.Username,
 			"displayName": appUser.DisplayName,
@@ -585,9 +1007,20 @@ func (app *App) handleGetPasskeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.aaguids.Annotate(passkeys)
+	app.mds.Annotate(passkeys)
 	json.NewEncoder(w).Encode(passkeys)
 }
 
+// handleDeletePasskey removes one of the current user's passkeys.
+//
+// Routed through requireReauth. The account's only passkey is protected by
+// the store, which refuses to delete it unless checkLastCredential allows
+// it, so concurrent deletions cannot leave the account without one.
+//
+// Query: confirm=true to delete the last passkey without recovery codes
+// HTTP Status: 200 (success), 400 (invalid ID), 401 (not authenticated),
+// 403 REAUTH_REQUIRED, 409 LAST_CREDENTIAL
 func (app *App) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
 	username := app.getCurrentUser(r)
 	if username == "" {
@@ -595,36 +1028,127 @@ func (app *App) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Extract credential ID from URL path (base64-encoded)
-	credentialIDStr := strings.TrimPrefix(r.URL.Path, "/api/user/passkeys/")
-	if credentialIDStr == "" {
-		app.writeError(w, "Credential ID required", http.StatusBadRequest)
+	// Extract credential ID from URL path (base64url-encoded)
+	_, credentialID, ok := app.credentialIDFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	// The credential ID comes as a base64-encoded string in the URL
-	// We need to use it directly as string for our storage layer
-	credentialID := []byte(credentialIDStr)
-	err := app.store.DeleteUserPasskey(username, credentialID)
+	user, exists := app.store.GetUser(username)
+	if !exists {
+		app.writeAppError(w, ErrUserNotFound, http.StatusUnauthorized)
+		return
+	}
+	allowLast, detail, ok := app.checkLastCredential(w, r, user, credentialID)
+	if !ok {
+		return
+	}
+
+	err := app.store.DeleteUserPasskey(username, credentialID, allowLast)
+	if err == ErrLastCredential {
+		logger.WarnContext(r.Context(), "Refused to delete the last passkey")
+		app.writeAppError(w, ErrLastCredential, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		app.writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Log with readable credential ID (the base64 string)
-	fmt.Printf("SECURITY: Passkey deleted for user %s, CredentialID: %s\n", username, credentialIDStr)
+	logger.WarnContext(r.Context(), "Passkey deleted")
+	app.audit(r, AuditEvent{
+		Type:         auditPasskeyDeleted,
+		Actor:        username,
+		Username:     username,
+		CredentialID: encodeCredentialID(credentialID),
+		Outcome:      auditSuccess,
+		Detail:       detail,
+	})
 	app.writeSuccess(w, "Passkey deleted successfully", nil)
 }
 
+// checkLastCredential decides whether a deletion may remove the user's only
+// passkey, which would leave the account with no way to sign in.
+//
+// allowLast is set if the user still has recovery codes to get back in
+// with, or if the request says ?confirm=true; requireReauth has already
+// checked the session is freshly verified. The store applies allowLast when
+// it deletes. detail describes the exception for the audit log; it is empty
+// when credentialID is not the last passkey. ok is false if the recovery
+// codes could not be loaded.
+func (app *App) checkLastCredential(w http.ResponseWriter, r *http.Request, user *User, credentialID []byte) (allowLast bool, detail string, ok bool) {
+	hashes, err := app.store.GetRecoveryCodes(user.ID)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to load recovery codes: %v", err), http.StatusInternalServerError)
+		return false, "", false
+	}
+
+	switch {
+	case len(hashes) > 0:
+		allowLast, detail = true, fmt.Sprintf("last credential, %d recovery codes left", len(hashes))
+	case r.URL.Query().Get("confirm") == "true":
+		allowLast, detail = true, "last credential, confirmed without recovery codes"
+	}
+
+	if len(user.Credentials) != 1 || user.credentialRecord(credentialID) == nil {
+		detail = ""
+	}
+	return allowLast, detail, true
+}
+
+// handleRenamePasskey sets or clears the nickname of one of the current
+// user's passkeys.
+//
+// Request Body: RenamePasskeyRequest (JSON)
+// HTTP Status: 200 (success), 400 (invalid name or ID), 401 (not authenticated)
+func (app *App) handleRenamePasskey(w http.ResponseWriter, r *http.Request) {
+	username := app.getCurrentUser(r)
+	if username == "" {
+		app.writeError(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	credentialIDStr, credentialID, ok := app.credentialIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req RenamePasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	nickname, err := validateNickname(req.Name)
+	if err != nil {
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := app.store.RenameUserPasskey(username, credentialID, nickname); err != nil {
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Passkey renamed")
+	app.writeSuccess(w, "Passkey renamed successfully", map[string]interface{}{
+		"id":       credentialIDStr,
+		"nickname": nickname,
+	})
+}
+
 func (app *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// Revoke the server-side session so the token is useless even if the
+	// client keeps the cookie
+	if session, ok := getLoginSession(r.Context()); ok {
+		if err := app.store.DeleteLoginSession(session.ID); err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to end session: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Clear user session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "user-session",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	app.clearUserSessionCookie(w)
 
 	app.writeSuccess(w, "Logged out successfully", nil)
 }
@@ -691,35 +1215,88 @@ func hasBackupEligiblePasskeys(passkeys []PasskeyInfo) bool {
 }
 
 // Helper methods
-func (app *App) updateUserCredential(user *User, credential *webauthn.Credential) {
-	// Find and update the existing credential
-	for i, cred := range user.Credentials {
-		if string(cred.ID) == string(credential.ID) {
-			user.Credentials[i] = *credential
-			app.store.UpdateUser(user)
-			return
+
+// updateUserCredential stores the credential state returned by a successful
+// login (sign count, flags) and records when, where and how often it was used.
+//
+// Only this credential is written back, so a passkey deleted or added while
+// the login was in flight is not undone by our copy of the user. If the
+// credential itself was deleted in the meantime, the login fails with 401
+// and ok is false.
+func (app *App) updateUserCredential(w http.ResponseWriter, r *http.Request, user *User, credential *webauthn.Credential) (ok bool) {
+	err := app.store.UpdateCredential(user.ID, credential.ID, func(record *CredentialRecord) {
+		record.Credential = *credential
+		// The library never clears CloneWarning once set, so it is not
+		// saved: suspect state lives in SuspectAt and Suspended
+		if !credential.Authenticator.CloneWarning {
+			record.LastSignCount = credential.Authenticator.SignCount
 		}
+		record.Authenticator.CloneWarning = false
+		record.LastUsedAt = time.Now()
+		record.LastUsedIP = clientIP(r)
+		record.LastUsedUserAgent = r.UserAgent()
+		record.UseCount++
+	})
+	if err == ErrCredentialNotFound {
+		logger.WarnContext(r.Context(), "Credential deleted during authentication")
+		app.writeError(w, "Authentication failed: credential no longer valid", http.StatusUnauthorized)
+		return false
 	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to update credential", "error", err)
+	}
+	return true
 }
 
-func (app *App) setUserSession(w http.ResponseWriter, username string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "user-session",
-		Value:    username,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   3600, // 1 hour
-	})
+// getCurrentUser returns the username of the authenticated user, or an empty
+// string if the request has no valid login session.
+//
+// The username is resolved from the server-side session's user ID; nothing
+// the client sends is trusted as an identity.
+func (app *App) getCurrentUser(r *http.Request) string {
+	session, ok := getLoginSession(r.Context())
+	if !ok {
+		return ""
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		return ""
+	}
+	logUser(r.Context(), user.Username)
+	return user.Username
 }
 
-func (app *App) getCurrentUser(r *http.Request) string {
-	cookie, err := r.Cookie("user-session")
+// encodeCredentialID formats a credential ID the way browsers expose
+// PublicKeyCredential.id: unpadded base64url.
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// decodeCredentialID parses a credential ID produced by encodeCredentialID,
+// tolerating trailing padding from clients that add it.
+func decodeCredentialID(encoded string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+}
+
+// credentialIDFromPath extracts and decodes the {id} segment of
+// /api/user/passkeys/{id}. On failure a 400 has been written and ok is false.
+func (app *App) credentialIDFromPath(w http.ResponseWriter, r *http.Request) (encoded string, id []byte, ok bool) {
+	encoded = strings.TrimPrefix(r.URL.Path, "/api/user/passkeys/")
+	encoded, _, _ = strings.Cut(encoded, "/") // e.g. {id}/reinstate
+	if encoded == "" {
+		app.writeError(w, "Credential ID required", http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	id, err := decodeCredentialID(encoded)
 	if err != nil {
-		return ""
+		app.writeError(w, "Invalid credential ID", http.StatusBadRequest)
+		return "", nil, false
 	}
-	return cookie.Value
+	logCredential(r.Context(), id)
+
+	return encoded, id, true
 }
 
 func (app *App) writeError(w http.ResponseWriter, message string, status int) {
@@ -729,6 +1306,16 @@ func (app *App) writeError(w http.ResponseWriter, message string, status int) {
 	})
 }
 
+// writeAppError writes an AppError including its machine-readable code.
+func (app *App) writeAppError(w http.ResponseWriter, err *AppError, status int) {
+	recordErrorCode(w, err.Code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: err.Message,
+		Code:  err.Code,
+	})
+}
+
 func (app *App) writeSuccess(w http.ResponseWriter, message string, data interface{}) {
 	json.NewEncoder(w).Encode(SuccessResponse{
 		Message: message,
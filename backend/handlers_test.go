@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func TestCeremonySession(t *testing.T) {
+	app := &App{store: NewInMemoryStore()}
+	app.store.StoreSession("login-1", &Session{Ceremony: ceremonyLogin, SessionData: webauthn.SessionData{Challenge: "c"}})
+
+	tests := []struct {
+		name      string
+		sessionID string // empty for no webauthn-session cookie
+		ceremony  string
+		wantOK    bool
+	}{
+		{"matching ceremony", "login-1", ceremonyLogin, true},
+		{"login session used to register", "login-1", ceremonyRegister, false},
+		{"login session used to add a passkey", "login-1", ceremonyAddPasskey, false},
+		{"unknown session", "missing", ceremonyLogin, false},
+		{"no session", "", ceremonyLogin, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/login/finish", nil)
+			if tc.sessionID != "" {
+				req = req.WithContext(setSessionID(req.Context(), tc.sessionID))
+			}
+			rec := httptest.NewRecorder()
+
+			_, session, ok := app.ceremonySession(rec, req, tc.ceremony)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v (status %d)", ok, tc.wantOK, rec.Code)
+			}
+			if ok {
+				if session.SessionData.Challenge != "c" {
+					t.Errorf("session = %+v", session)
+				}
+				return
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400", rec.Code)
+			}
+			if tc.sessionID != "" {
+				if resp := decodeError(t, rec); resp.Code != ErrInvalidSession.Code {
+					t.Errorf("error code %q, want %q", resp.Code, ErrInvalidSession.Code)
+				}
+			}
+		})
+	}
+}
+
+// decodeError reads the ErrorResponse written by writeAppError.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return resp
+}
+
+// addPasskeyFinish runs handleAddPasskeyFinish for user with a fresh
+// add-passkey ceremony, posting the body built from its challenge.
+func addPasskeyFinish(t *testing.T, app *App, user *User, body func(challenge string) []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	_, sessionData, err := app.webAuthn.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	session := &Session{UserID: user.ID, Ceremony: ceremonyAddPasskey, SessionData: *sessionData}
+	if err := app.store.StoreSession("add-1", session); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/passkeys/register/finish", bytes.NewReader(body(sessionData.Challenge)))
+	ctx := setSessionID(req.Context(), "add-1")
+	ctx = setLoginSession(ctx, &LoginSession{UserID: user.ID})
+	rec := httptest.NewRecorder()
+	app.handleAddPasskeyFinish(rec, req.WithContext(ctx))
+	return rec
+}
+
+func TestAddPasskeyFinishDuplicate(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice", "cred-1")
+	authenticator := newTestAuthenticator(t)
+
+	register := func(challenge string) []byte { return authenticator.register(t, challenge) }
+	if rec := addPasskeyFinish(t, app, user, register); rec.Code != http.StatusOK {
+		t.Fatalf("first registration: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// A client that ignores excludeCredentials enrolls the same authenticator again
+	rec := addPasskeyFinish(t, app, user, register)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate registration: status %d, want 409", rec.Code)
+	}
+	if resp := decodeError(t, rec); resp.Code != ErrCredentialExists.Code {
+		t.Errorf("error code %q, want %q", resp.Code, ErrCredentialExists.Code)
+	}
+	if stored, _ := app.store.GetUser("alice"); len(stored.Credentials) != 2 {
+		t.Errorf("%d credentials stored, want 2", len(stored.Credentials))
+	}
+	if _, ok := app.store.GetSession("add-1"); ok {
+		t.Error("ceremony session kept after a duplicate registration")
+	}
+}
+
+func TestAddPasskeyFinishClientError(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientErr  string
+		wantStatus int
+		wantCode   string
+	}{
+		{"excluded authenticator", "InvalidStateError", http.StatusConflict, ErrCredentialExists.Code},
+		{"cancelled", "NotAllowedError", http.StatusBadRequest, ErrCeremonyAborted.Code},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp(t)
+			user := createTestUser(t, app.store, "alice", "cred-1")
+
+			rec := addPasskeyFinish(t, app, user, func(string) []byte {
+				return []byte(`{"clientError":{"name":"` + tc.clientErr + `","message":"test"}}`)
+			})
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if resp := decodeError(t, rec); resp.Code != tc.wantCode {
+				t.Errorf("error code %q, want %q", resp.Code, tc.wantCode)
+			}
+			if _, ok := app.store.GetSession("add-1"); ok {
+				t.Error("ceremony session kept after the client aborted")
+			}
+		})
+	}
+}
+
+func TestLoginRecordsCredentialUsage(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice")
+	authenticator := newTestAuthenticator(t)
+	authenticator.enroll(t, app, user)
+
+	for range 2 {
+		if rec := authenticator.loginFinish(t, app, user); rec.Code != http.StatusOK {
+			t.Fatalf("login: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	stored, _ := app.store.GetUser("alice")
+	record := stored.Credentials[0]
+	if record.UseCount != 2 || record.Authenticator.SignCount != 2 {
+		t.Errorf("use count %d, sign count %d; want 2 and 2", record.UseCount, record.Authenticator.SignCount)
+	}
+	if record.LastUsedUserAgent != "test-agent" || record.LastUsedIP == "" || time.Since(record.LastUsedAt) > time.Minute {
+		t.Errorf("last use not recorded: %+v", record)
+	}
+
+	passkeys, _ := app.store.GetUserPasskeys("alice")
+	if passkeys[0].LastUsed == nil || passkeys[0].UseCount != 2 {
+		t.Errorf("PasskeyInfo = %+v", passkeys[0])
+	}
+}
+
+func TestValidateNickname(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"  Work laptop ", "Work laptop", false},
+		{"", "", false},
+		{strings.Repeat("é", maxNicknameLength), strings.Repeat("é", maxNicknameLength), false},
+		{strings.Repeat("a", maxNicknameLength+1), "", true},
+		{"bad\nname", "", true},
+	}
+	for _, tc := range tests {
+		got, err := validateNickname(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("validateNickname(%q) = %q, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestHandleRenamePasskey(t *testing.T) {
+	app := &App{store: NewInMemoryStore()}
+	user := createTestUser(t, app.store, "alice", "cred-1")
+	path := "/api/user/passkeys/" + encodeCredentialID([]byte("cred-1"))
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		signedIn   bool
+		wantStatus int
+		wantName   string
+	}{
+		{"rename", path, `{"name":"  Work laptop "}`, true, http.StatusOK, "Work laptop"},
+		{"too long", path, `{"name":"` + strings.Repeat("a", maxNicknameLength+1) + `"}`, true, http.StatusBadRequest, "Work laptop"},
+		{"unknown passkey", "/api/user/passkeys/" + encodeCredentialID([]byte("nope")), `{"name":"x"}`, true, http.StatusBadRequest, "Work laptop"},
+		{"signed out", path, `{"name":"x"}`, false, http.StatusUnauthorized, "Work laptop"},
+		{"clear", path, `{"name":""}`, true, http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tc.path, strings.NewReader(tc.body))
+			if tc.signedIn {
+				req = req.WithContext(setLoginSession(req.Context(), &LoginSession{UserID: user.ID}))
+			}
+			rec := httptest.NewRecorder()
+			app.handleRenamePasskey(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			stored, _ := app.store.GetUser("alice")
+			if got := stored.Credentials[0].Nickname; got != tc.wantName {
+				t.Errorf("nickname %q, want %q", got, tc.wantName)
+			}
+		})
+	}
+}
+
+func TestHandleDeletePasskeyLastCredential(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice", "cred-1", "cred-2")
+
+	deletePasskey := func(id, query string, verified bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/user/passkeys/"+encodeCredentialID([]byte(id))+query, nil)
+		session := &LoginSession{UserID: user.ID}
+		if verified {
+			session.VerifiedAt = time.Now()
+		}
+		req = req.WithContext(setLoginSession(req.Context(), session))
+		rec := httptest.NewRecorder()
+		app.requireReauth(app.handleDeletePasskey)(rec, req)
+		return rec
+	}
+
+	if rec := deletePasskey("cred-2", "", true); rec.Code != http.StatusOK {
+		t.Fatalf("delete second passkey: status %d: %s", rec.Code, rec.Body)
+	}
+	passkeys, _ := app.store.GetUserPasskeys("alice")
+	if len(passkeys) != 1 || !passkeys[0].LastCredential {
+		t.Fatalf("passkeys = %+v, want one marked lastCredential", passkeys)
+	}
+
+	rec := deletePasskey("cred-1", "", true)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("delete last passkey: status %d, want 409", rec.Code)
+	}
+	if resp := decodeError(t, rec); resp.Code != ErrLastCredential.Code {
+		t.Errorf("error code %q, want %q", resp.Code, ErrLastCredential.Code)
+	}
+	rec = deletePasskey("cred-1", "?confirm=true", false)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("confirmed without verification: status %d, want 403", rec.Code)
+	}
+	if resp := decodeError(t, rec); resp.Code != ErrReauthRequired.Code {
+		t.Errorf("error code %q, want %q", resp.Code, ErrReauthRequired.Code)
+	}
+
+	if err := app.store.ReplaceRecoveryCodes(user.ID, []string{"hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := deletePasskey("cred-1", "", true); rec.Code != http.StatusOK {
+		t.Fatalf("delete last passkey with recovery codes: status %d: %s", rec.Code, rec.Body)
+	}
+	events, _ := app.store.ListAuditEvents(AuditFilter{Types: []string{auditPasskeyDeleted}, Limit: 1})
+	if len(events) != 1 || events[0].Detail != "last credential, 1 recovery codes left" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+// TestLoginFailsForCredentialDeletedDuringLogin deletes the passkey after a
+// login verified it but before the login saved its sign count.
+func TestLoginFailsForCredentialDeletedDuringLogin(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice", "cred-1", "cred-2")
+	credential := user.credentialRecord([]byte("cred-2")).Credential
+
+	if err := app.store.DeleteUserPasskey("alice", []byte("cred-2"), false); err != nil {
+		t.Fatal(err)
+	}
+	credential.Authenticator.SignCount = 9
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/login/finish", nil)
+	if app.updateUserCredential(rec, req, user, &credential) || rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with a passkey deleted mid-flight: status %d, want 401", rec.Code)
+	}
+
+	stored, _ := app.store.GetUser("alice")
+	if ids := credentialIDs(stored); len(ids) != 1 || ids[0] != "cred-1" {
+		t.Errorf("credentials = %v, the login resurrected the deleted passkey", ids)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds each readiness check so a hung database cannot
+// stall the probe past the orchestrator's own timeout.
+const readinessTimeout = 2 * time.Second
+
+// HealthResponse is the body of the health endpoints.
+//
+// Checks maps each readiness check to "ok" or the reason it failed; it is
+// omitted by the liveness probe, which runs no checks.
+type HealthResponse struct {
+	Status string            `json:"status"`           // "ok", "ready" or "unavailable"
+	Time   time.Time         `json:"time"`             // Server time of the response
+	Checks map[string]string `json:"checks,omitempty"` // Readiness check results
+}
+
+// handleLive reports that the process is up and serving HTTP.
+//
+// Liveness deliberately checks nothing else: a restart will not fix an
+// unreachable database, so only a wedged process should fail it.
+//
+// HTTP Status: 200 (always)
+func (app *App) handleLive(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok", Time: time.Now()})
+}
+
+// handleReady reports whether the server should receive traffic.
+//
+// Checks:
+//   - shutdown: not draining connections after SIGINT/SIGTERM
+//   - store: the Store answers a ping
+//   - config: the loaded configuration still passes Validate
+//   - mds: the MDS BLOB is not past its nextUpdate (when configured)
+//   - aaguids: the last AAGUID listing reload succeeded (when configured)
+//
+// The mds and aaguids checks are reported for operators but do not fail the
+// probe: the server keeps serving with the data it loaded, and no other
+// replica would do better.
+//
+// HTTP Status: 200 (ready), 503 (shutdown, store or config failed)
+func (app *App) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{"shutdown": "ok", "store": "ok", "config": "ok"}
+	ready := true
+	if app.draining.Load() {
+		checks["shutdown"] = "draining"
+		ready = false
+	}
+	if err := app.store.Ping(ctx); err != nil {
+		checks["store"] = err.Error()
+		ready = false
+	}
+	if err := app.config.Validate(); err != nil {
+		checks["config"] = err.Error()
+		ready = false
+	}
+	if app.mds != nil {
+		checks["mds"] = "ok"
+		if next := app.mds.NextUpdate(); time.Now().After(next) {
+			checks["mds"] = "stale since " + next.Format(time.DateOnly)
+		}
+	}
+	if app.aaguids != nil {
+		checks["aaguids"] = "ok"
+		if err := app.aaguids.Err(); err != nil {
+			checks["aaguids"] = err.Error()
+		}
+	}
+
+	resp := HealthResponse{Status: "ready", Time: time.Now(), Checks: checks}
+	if !ready {
+		resp.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// shutdown stops servers gracefully once SIGINT/SIGTERM arrives.
+//
+// Readiness starts failing at once, but the listeners keep serving for
+// delay so load balancers polling the probe can take this instance out of
+// rotation before connections are refused. Then in-flight requests (such as
+// half-finished ceremonies) get up to timeout to complete. Nil servers are
+// skipped.
+func (app *App) shutdown(delay, timeout time.Duration, servers ...*http.Server) error {
+	app.draining.Store(true)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleReady(t *testing.T) {
+	listing := filepath.Join(t.TempDir(), "aaguids.json")
+	if err := os.WriteFile(listing, []byte(`{"fbfc3007-154e-4ecc-8c0b-6e020557d7bd": {"name": "iCloud Keychain"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	aaguids, err := NewAAGUIDRegistry(listing)
+	if err != nil {
+		t.Fatalf("NewAAGUIDRegistry: %v", err)
+	}
+	app := &App{config: DefaultConfig(), store: NewInMemoryStore(), aaguids: aaguids}
+
+	ready := func() (int, HealthResponse) {
+		rec := httptest.NewRecorder()
+		app.handleReady(rec, httptest.NewRequest(http.MethodGet, "/api/health/ready", nil))
+		var resp HealthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := ready(); code != http.StatusOK || resp.Checks["aaguids"] != "ok" {
+		t.Errorf("ready = %d %+v, want 200 with aaguids ok", code, resp)
+	}
+
+	// A failed reload is reported, but the old listing is still served
+	if err := os.WriteFile(listing, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := aaguids.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid listing")
+	}
+	if code, resp := ready(); code != http.StatusOK || resp.Checks["aaguids"] == "ok" {
+		t.Errorf("after bad reload: ready = %d %+v, want 200 with the reload error", code, resp)
+	}
+	if aaguids.Len() != 1 {
+		t.Errorf("Len after bad reload = %d, want the previous listing", aaguids.Len())
+	}
+
+	// A configuration that no longer validates takes the instance out
+	app.config.Cookies.SameSite = "bogus"
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Checks["config"] == "ok" {
+		t.Errorf("invalid config: ready = %d %+v, want 503 with the config error", code, resp)
+	}
+	app.config.Cookies.SameSite = "strict"
+
+	app.draining.Store(true)
+	if code, resp := ready(); code != http.StatusServiceUnavailable || resp.Checks["shutdown"] != "draining" {
+		t.Errorf("draining: ready = %d %+v, want 503", code, resp)
+	}
+}
+
+func TestShutdownDrainsBeforeClosing(t *testing.T) {
+	app := &App{config: DefaultConfig(), store: NewInMemoryStore()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(app.handleReady)}
+	go server.Serve(listener)
+	url := "http://" + listener.Addr().String() + "/api/health/ready"
+
+	if resp, err := http.Get(url); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("before shutdown: %v %v, want 200", resp, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- app.shutdown(500*time.Millisecond, time.Second, server, nil) }()
+
+	// During the drain delay the listener still answers, with readiness failing
+	time.Sleep(100 * time.Millisecond)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("during drain delay: %v, want the server to keep serving", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("during drain delay: status = %d, want 503", resp.StatusCode)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
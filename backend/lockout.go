@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// LoginFailures counts the failed assertions of one account or credential
+// since its last successful login.
+//
+// Counters are kept per account (lockoutUserKey) and per credential
+// (lockoutCredentialKey). A counter that has seen no failure for
+// LockoutConfig.MaxDuration starts again from zero.
+type LoginFailures struct {
+	Key    string    // lockoutUserKey or lockoutCredentialKey
+	Count  int       // Failures in the current run
+	LastAt time.Time // Most recent failure
+}
+
+func lockoutUserKey(user *User) string {
+	return "user:" + encodeCredentialID(user.ID)
+}
+
+func lockoutCredentialKey(credentialID []byte) string {
+	return "credential:" + encodeCredentialID(credentialID)
+}
+
+// lockedUntil returns when the lock earned by f ends, or the zero time if f
+// has not reached the threshold.
+//
+// The first lock lasts Duration and each further failure doubles it, up to
+// MaxDuration: with the defaults, 1m, 2m, 4m, ... 1h.
+func (c LockoutConfig) lockedUntil(f *LoginFailures) time.Time {
+	if f == nil || c.Threshold == 0 || f.Count < c.Threshold {
+		return time.Time{}
+	}
+	exponent := float64(f.Count - c.Threshold)
+	lock := time.Duration(math.Min(float64(c.Duration)*math.Pow(2, exponent), float64(c.MaxDuration)))
+	return f.LastAt.Add(lock)
+}
+
+// loginFailures returns the current failure run for key, or nil if there is
+// none.
+func (app *App) loginFailures(key string) *LoginFailures {
+	failures, exists := app.store.GetLoginFailures(key)
+	if !exists || time.Since(failures.LastAt) > app.config.Security.Lockout.MaxDuration {
+		return nil
+	}
+	return failures
+}
+
+// lockedUntil returns when the lock on key ends, or the zero time if key is
+// not locked.
+func (app *App) lockedUntil(key string) time.Time {
+	until := app.config.Security.Lockout.lockedUntil(app.loginFailures(key))
+	if until.Before(time.Now()) {
+		return time.Time{}
+	}
+	return until
+}
+
+// unlockingCredentials returns descriptors for the user's credentials that
+// may still sign in while the account is locked: those without failures of
+// their own. A successful login with one of them lifts the lock.
+func (app *App) unlockingCredentials(user *User) []protocol.CredentialDescriptor {
+	var allowed []protocol.CredentialDescriptor
+	for _, cred := range user.Credentials {
+		if app.loginFailures(lockoutCredentialKey(cred.ID)) == nil {
+			allowed = append(allowed, cred.Descriptor())
+		}
+	}
+	return allowed
+}
+
+// checkLockout refuses an assertion from credentialID for user while the
+// credential or the account is locked.
+//
+// A locked credential is always refused. A locked account still accepts
+// its other credentials, as long as they have not failed themselves, so the
+// owner can unlock it with a different passkey. Refusals are audited and
+// answered with 423 ACCOUNT_LOCKED and Retry-After; ok is false then.
+func (app *App) checkLockout(w http.ResponseWriter, r *http.Request, user *User, credentialID []byte) (ok bool) {
+	if app.config.Security.Lockout.Threshold == 0 {
+		return true
+	}
+
+	until := app.lockedUntil(lockoutCredentialKey(credentialID))
+	if accountUntil := app.lockedUntil(lockoutUserKey(user)); !accountUntil.IsZero() {
+		different := user.credentialRecord(credentialID) != nil &&
+			app.loginFailures(lockoutCredentialKey(credentialID)) == nil
+		if !different && accountUntil.After(until) {
+			until = accountUntil
+		}
+	}
+	if until.IsZero() {
+		return true
+	}
+
+	app.audit(r, AuditEvent{
+		Type:         auditLogin,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credentialID),
+		Outcome:      auditFailure,
+		Detail:       ErrAccountLocked.Code,
+	})
+	app.writeLocked(w, until)
+	return false
+}
+
+// writeLocked answers 423 ACCOUNT_LOCKED with Retry-After set to the end of
+// the lock.
+func (app *App) writeLocked(w http.ResponseWriter, until time.Time) {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.writeAppError(w, ErrAccountLocked, http.StatusLocked)
+}
+
+// recordLoginFailure counts a failed assertion against the account and, if
+// it is one of the account's credentials, against the credential. Reaching
+// the threshold, or failing again while over it, locks and is audited.
+func (app *App) recordLoginFailure(r *http.Request, user *User, credentialID []byte) {
+	cfg := app.config.Security.Lockout
+	if cfg.Threshold == 0 {
+		return
+	}
+
+	keys := []string{lockoutUserKey(user)}
+	if user.credentialRecord(credentialID) != nil {
+		keys = append(keys, lockoutCredentialKey(credentialID))
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		failures, err := app.store.RecordLoginFailure(key, now, now.Add(-cfg.MaxDuration))
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to record login failure", "error", err)
+			continue
+		}
+		until := cfg.lockedUntil(failures)
+		if until.IsZero() {
+			continue
+		}
+
+		scope, _, _ := strings.Cut(key, ":")
+		logger.WarnContext(r.Context(), "Login locked after repeated failures",
+			"scope", scope, "failures", failures.Count, "locked_until", until)
+		metrics.Lockouts.Inc(scope)
+		event := AuditEvent{
+			Type:     auditAccountLocked,
+			Username: user.Username,
+			Outcome:  auditWarning,
+			Detail:   fmt.Sprintf("%s locked after %d failures until %s", scope, failures.Count, until.UTC().Format(time.RFC3339)),
+		}
+		if scope == "credential" {
+			event.CredentialID = encodeCredentialID(credentialID)
+		}
+		app.audit(r, event)
+	}
+}
+
+// clearLoginFailures resets the account's and the credential's counters
+// after a successful login. If the account was locked, the login came from
+// a different credential (checkLockout let it through), which unlocks it.
+func (app *App) clearLoginFailures(r *http.Request, user *User, credentialID []byte) {
+	if app.config.Security.Lockout.Threshold == 0 {
+		return
+	}
+
+	wasLocked := !app.lockedUntil(lockoutUserKey(user)).IsZero()
+	if err := app.store.ClearLoginFailures(lockoutUserKey(user), lockoutCredentialKey(credentialID)); err != nil {
+		logger.ErrorContext(r.Context(), "Failed to clear login failures", "error", err)
+		return
+	}
+
+	if wasLocked {
+		logger.InfoContext(r.Context(), "Account unlocked by a different credential")
+		app.audit(r, AuditEvent{
+			Type:         auditAccountUnlocked,
+			Actor:        user.Username,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(credentialID),
+			Outcome:      auditSuccess,
+			Detail:       "credential",
+		})
+	}
+}
+
+// handleAdminUnlock clears every failure counter of an account and its
+// credentials, lifting any lock.
+//
+// HTTP Status: 200 (success), 401 (no admin token), 404 (unknown user)
+func (app *App) handleAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	if !app.requireAdmin(w, r) {
+		return
+	}
+
+	username := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/unlock")
+	user, exists := app.store.GetUser(username)
+	if !exists {
+		app.writeAppError(w, ErrUserNotFound, http.StatusNotFound)
+		return
+	}
+	logUser(r.Context(), user.Username)
+
+	keys := []string{lockoutUserKey(user)}
+	for _, cred := range user.Credentials {
+		keys = append(keys, lockoutCredentialKey(cred.ID))
+	}
+	if err := app.store.ClearLoginFailures(keys...); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to unlock account: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "Account unlocked by admin")
+	app.audit(r, AuditEvent{
+		Type:     auditAccountUnlocked,
+		Actor:    "admin",
+		Username: user.Username,
+		Outcome:  auditSuccess,
+		Detail:   "admin",
+	})
+	app.writeSuccess(w, "Account unlocked", nil)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLockedUntilBackoff(t *testing.T) {
+	cfg := LockoutConfig{Threshold: 5, Duration: time.Minute, MaxDuration: time.Hour}
+	last := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		count int
+		want  time.Duration // Zero for no lock
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{11, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tc := range tests {
+		got := cfg.lockedUntil(&LoginFailures{Count: tc.count, LastAt: last})
+		want := time.Time{}
+		if tc.want != 0 {
+			want = last.Add(tc.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("count %d: locked until %v, want %v", tc.count, got, want)
+		}
+	}
+
+	if got := cfg.lockedUntil(nil); !got.IsZero() {
+		t.Errorf("no failures: locked until %v", got)
+	}
+	disabled := LockoutConfig{Threshold: 0, Duration: time.Minute, MaxDuration: time.Hour}
+	if got := disabled.lockedUntil(&LoginFailures{Count: 100, LastAt: last}); !got.IsZero() {
+		t.Errorf("threshold 0: locked until %v", got)
+	}
+}
+
+func TestLockoutUnlockWithDifferentCredential(t *testing.T) {
+	app := newTestApp(t)
+	user := testUser("alice", "cred-1", "cred-2")
+	if err := app.store.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/login/finish", nil)
+
+	for i := 0; i < app.config.Security.Lockout.Threshold; i++ {
+		app.recordLoginFailure(req, user, []byte("cred-1"))
+	}
+
+	rec := httptest.NewRecorder()
+	if app.checkLockout(rec, req, user, []byte("cred-1")) {
+		t.Fatal("checkLockout let the failing credential through")
+	}
+	if rec.Code != http.StatusLocked || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("locked response = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !app.checkLockout(httptest.NewRecorder(), req, user, []byte("cred-2")) {
+		t.Fatal("checkLockout refused a different credential of the locked account")
+	}
+	if allowed := app.unlockingCredentials(user); len(allowed) != 1 || string(allowed[0].CredentialID) != "cred-2" {
+		t.Errorf("unlockingCredentials = %v, want only cred-2", allowed)
+	}
+
+	app.clearLoginFailures(req, user, []byte("cred-2"))
+	if until := app.lockedUntil(lockoutUserKey(user)); !until.IsZero() {
+		t.Errorf("account still locked until %v after a login with cred-2", until)
+	}
+	if until := app.lockedUntil(lockoutCredentialKey([]byte("cred-1"))); until.IsZero() {
+		t.Error("the failing credential was unlocked by another credential's login")
+	}
+
+	events, _ := app.store.ListAuditEvents(AuditFilter{Types: []string{auditAccountLocked, auditAccountUnlocked}})
+	if len(events) != 3 || events[0].Type != auditAccountUnlocked {
+		t.Errorf("audited %v, want account and credential locks then an unlock", auditIDs(events))
+	}
+}
@@ -1,92 +1,115 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
-// CustomLogger implements logging with Console.app compatible timestamps
+// logServiceName is attached to every JSON and text record.
+const logServiceName = "passkey-backend"
+
+// requestIDHeader carries the request ID in both directions. A well-formed
+// ID sent by a proxy or client is kept, so one ID follows a request through
+// every hop; otherwise a new one is generated.
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// CustomLogger is the backend's structured logger, built on log/slog.
+//
+// Records logged with a request context (InfoContext, WarnContext, ...) carry
+// the request-scoped attributes added by LogHTTP and addLogAttrs: request_id,
+// username and credential_id. Printf and Errorf remain for plain messages.
 type CustomLogger struct {
-	serviceName string
+	*slog.Logger
 }
 
-// NewLogger creates a new logger with the given service name
-func NewLogger(serviceName string) *CustomLogger {
-	return &CustomLogger{
-		serviceName: serviceName,
+// NewLogger creates a logger writing in the configured format and level.
+//
+// json and text write slog's standard handlers to stdout. console keeps the
+// original Console.app friendly lines: a local timestamp, the message, then
+// any attributes as key=value, with errors going to stderr.
+func NewLogger(serviceName string, cfg LoggingConfig) *CustomLogger {
+	level, err := cfg.level()
+	if err != nil {
+		level = slog.LevelInfo // Rejected by Validate
 	}
-}
+	options := &slog.HandlerOptions{Level: level}
 
-// getTimestamp returns a timestamp in Console.app format: HH:MM:SS.microseconds-ZZZZ
-func (l *CustomLogger) getTimestamp() string {
-	now := time.Now()
-	_, offset := now.Zone()
-	offsetHours := offset / 3600
-	offsetMinutes := (offset % 3600) / 60
-	
-	// Format: HH:MM:SS.microseconds-HHMM
-	timestamp := fmt.Sprintf("%02d:%02d:%02d.%06d%+03d%02d",
-		now.Hour(),
-		now.Minute(),
-		now.Second(),
-		now.Nanosecond()/1000, // Convert to microseconds
-		offsetHours,
-		offsetMinutes,
-	)
-	
-	return timestamp
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, options).WithAttrs([]slog.Attr{slog.String("service", serviceName)})
+	case "console":
+		handler = newConsoleHandler(os.Stdout, os.Stderr, level)
+	default:
+		handler = slog.NewTextHandler(os.Stdout, options).WithAttrs([]slog.Attr{slog.String("service", serviceName)})
+	}
+
+	return &CustomLogger{Logger: slog.New(contextHandler{handler})}
 }
 
-// Printf logs a formatted message with timestamp
+// Printf logs a formatted message at info level
 func (l *CustomLogger) Printf(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Printf("%s    %s\n", l.getTimestamp(), message)
+	l.Info(fmt.Sprintf(format, args...))
 }
 
-// Errorf logs an error message with timestamp
+// Errorf logs a formatted message at error level
 func (l *CustomLogger) Errorf(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stderr, "%s    ERROR: %s\n", l.getTimestamp(), message)
-}
-
-// Info logs an info message with timestamp
-func (l *CustomLogger) Info(message string) {
-	fmt.Printf("%s    %s\n", l.getTimestamp(), message)
-}
-
-// Error logs an error message with timestamp
-func (l *CustomLogger) Error(message string) {
-	fmt.Fprintf(os.Stderr, "%s    ERROR: %s\n", l.getTimestamp(), message)
+	l.Error(fmt.Sprintf(format, args...))
 }
 
 // HTTP middleware for logging requests
+//
+// Each request gets an ID, returned in X-Request-ID and attached to every
+// record logged with the request context, and one access record when it
+// completes.
 func (l *CustomLogger) LogHTTP(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		
-		// Log incoming request
-		l.Printf("→ %s %s (from %s, User-Agent: %s)",
-			r.Method,
-			r.URL.Path,
-			r.RemoteAddr,
-			r.UserAgent(),
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := withLogScope(r.Context(), slog.String("request_id", requestID))
+		r = r.WithContext(ctx)
+
+		l.DebugContext(ctx, "Request started",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
 		)
-		
+
 		// Wrap response writer to capture status
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		
+
 		// Handle request
 		handler.ServeHTTP(wrapped, r)
-		
-		// Log response
-		duration := time.Since(start)
-		l.Printf("← %s %s [%d] (%v)",
-			r.Method,
-			r.URL.Path,
-			wrapped.statusCode,
-			duration,
+
+		level := slog.LevelInfo
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		l.Log(ctx, level, "Request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", wrapped.statusCode,
+			"duration", time.Since(start),
 		)
 	})
 }
@@ -100,4 +123,196 @@ type responseWriter struct {
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
-}
\ No newline at end of file
+}
+
+// Unwrap exposes the wrapped writer (see recordErrorCode).
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// logScopeKey stores the request's *logScope in its context.
+const logScopeKey contextKey = "logScope"
+
+// logScope holds the attributes shared by every record of one request.
+// Handlers add to it as they learn who the request is about, so the access
+// record written by LogHTTP ends up carrying them too.
+type logScope struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// withLogScope returns a context with a new log scope holding attrs.
+func withLogScope(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, logScopeKey, &logScope{attrs: attrs})
+}
+
+// addLogAttrs adds attributes to the request's log scope, replacing any
+// earlier value for the same key. It does nothing outside LogHTTP.
+func addLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	scope, ok := ctx.Value(logScopeKey).(*logScope)
+	if !ok {
+		return
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+	for _, attr := range attrs {
+		replaced := false
+		for i := range scope.attrs {
+			if scope.attrs[i].Key == attr.Key {
+				scope.attrs[i] = attr
+				replaced = true
+			}
+		}
+		if !replaced {
+			scope.attrs = append(scope.attrs, attr)
+		}
+	}
+}
+
+// logUser adds the username to the request's log scope.
+func logUser(ctx context.Context, username string) {
+	addLogAttrs(ctx, slog.String("username", username))
+}
+
+// logCredential adds the credential ID (as shown in the API) to the
+// request's log scope.
+func logCredential(ctx context.Context, credentialID []byte) {
+	addLogAttrs(ctx, slog.String("credential_id", encodeCredentialID(credentialID)))
+}
+
+// contextHandler adds the log scope of the record's context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if scope, ok := ctx.Value(logScopeKey).(*logScope); ok {
+		scope.mu.Lock()
+		record.AddAttrs(scope.attrs...)
+		scope.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// consoleHandler writes records in the Console.app friendly format:
+//
+//	15:04:05.000000+0100    WARNING: Clone detected username=alice
+type consoleHandler struct {
+	out    io.Writer // Debug, info and warnings
+	errOut io.Writer // Errors
+	level  slog.Leveler
+	attrs  []slog.Attr // From WithAttrs, keys already prefixed
+	prefix string      // Open groups, as "group."
+	mu     *sync.Mutex
+}
+
+func newConsoleHandler(out, errOut io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{out: out, errOut: errOut, level: level, mu: &sync.Mutex{}}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	b.WriteString(consoleTimestamp(record.Time))
+	b.WriteString("    ")
+	switch {
+	case record.Level >= slog.LevelError:
+		b.WriteString("ERROR: ")
+	case record.Level >= slog.LevelWarn:
+		b.WriteString("WARNING: ")
+	case record.Level < slog.LevelInfo:
+		b.WriteString("DEBUG: ")
+	}
+	b.WriteString(record.Message)
+
+	for _, attr := range h.attrs {
+		writeConsoleAttr(&b, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		writeConsoleAttr(&b, h.prefix, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	w := h.out
+	if record.Level >= slog.LevelError {
+		w = h.errOut
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+// writeConsoleAttr appends " key=value", flattening groups into dotted keys
+// and quoting values that contain spaces.
+func writeConsoleAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			writeConsoleAttr(b, prefix, member)
+		}
+		return
+	}
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	text := value.String()
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		text = strconv.Quote(text)
+	}
+	b.WriteByte(' ')
+	b.WriteString(prefix + attr.Key)
+	b.WriteByte('=')
+	b.WriteString(text)
+}
+
+// consoleTimestamp formats t for Console.app: HH:MM:SS.microseconds-ZZZZ
+func consoleTimestamp(t time.Time) string {
+	_, offset := t.Zone()
+	offsetHours := offset / 3600
+	offsetMinutes := (offset % 3600) / 60
+	if offsetMinutes < 0 {
+		offsetMinutes = -offsetMinutes
+	}
+
+	return fmt.Sprintf("%02d:%02d:%02d.%06d%+03d%02d",
+		t.Hour(),
+		t.Minute(),
+		t.Second(),
+		t.Nanosecond()/1000, // Convert to microseconds
+		offsetHours,
+		offsetMinutes,
+	)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogHTTPRequestScope(t *testing.T) {
+	var buf bytes.Buffer
+	l := &CustomLogger{Logger: slog.New(contextHandler{slog.NewJSONHandler(&buf, nil)})}
+	handler := l.LogHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logUser(r.Context(), "alice")
+		l.InfoContext(r.Context(), "Handled")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/", nil)
+	req.Header.Set(requestIDHeader, "proxy-id-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "proxy-id-1" {
+		t.Errorf("%s = %q, want the incoming ID", requestIDHeader, got)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want the handler's and the access record:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode %s: %v", line, err)
+		}
+		// The access record is written after the handler learned the user
+		if record["request_id"] != "proxy-id-1" || record["username"] != "alice" {
+			t.Errorf("record %q missing request scope: %s", record["msg"], line)
+		}
+	}
+
+	// Malformed IDs are replaced rather than logged
+	req = httptest.NewRequest(http.MethodGet, "/api/user/", nil)
+	req.Header.Set(requestIDHeader, "bad id\nwith newline")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got == "" || strings.Contains(got, " ") {
+		t.Errorf("%s = %q, want a generated ID", requestIDHeader, got)
+	}
+}
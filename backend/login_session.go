@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// userSessionCookie carries the opaque login session token.
+const userSessionCookie = "user-session"
+
+// loginSessionKey is used to store the validated LoginSession in request context.
+const loginSessionKey contextKey = "loginSession"
+
+// setLoginSession adds a validated login session to the request context.
+//
+// This is called by sessionMiddleware once the user-session cookie has been
+// checked against the store, so handlers never read the cookie directly.
+func setLoginSession(ctx context.Context, session *LoginSession) context.Context {
+	return context.WithValue(ctx, loginSessionKey, session)
+}
+
+// getLoginSession retrieves the validated login session from request context.
+//
+// Returns the session and true for authenticated requests, or nil and false
+// if the request carried no valid session.
+func getLoginSession(ctx context.Context) (*LoginSession, bool) {
+	session, ok := ctx.Value(loginSessionKey).(*LoginSession)
+	return session, ok && session != nil
+}
+
+// newSessionToken returns a 256-bit random token encoded for use in a cookie.
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSessionToken derives the store key for a session token.
+//
+// Only the hash is persisted, so reading the store does not reveal tokens
+// that could be replayed as cookies.
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the IP address of the client, as resolved by
+// clientIPMiddleware from trusted proxy headers, or the directly connected
+// address outside the API.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// startLoginSession creates a server-side login session for user and sets
+// the user-session cookie to its opaque token.
+//
+// The credential records which passkey completed the ceremony so the session
+// can later be attributed to a specific authenticator, and whether the user
+// was verified (biometric or PIN) rather than merely present.
+func (app *App) startLoginSession(w http.ResponseWriter, r *http.Request, user *User, credential *webauthn.Credential) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return fmt.Errorf("generate session token: %w", err)
+	}
+
+	now := time.Now()
+	session := &LoginSession{
+		ID:           hashSessionToken(token),
+		UserID:       user.ID,
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		ExpiresAt:    now.Add(loginSessionLifetime),
+		IP:           clientIP(r),
+		UserAgent:    r.UserAgent(),
+		CredentialID: credential.ID,
+	}
+	if credential.Flags.UserVerified {
+		session.VerifiedAt = now
+	}
+	if err := app.store.CreateLoginSession(session); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, app.config.Cookies.newCookie(userSessionCookie, token, loginSessionLifetime))
+
+	return nil
+}
+
+// loadLoginSession validates the user-session cookie against the store.
+//
+// Expired or idle sessions are deleted on sight. Valid sessions have their
+// LastSeenAt refreshed so the idle timeout slides with activity.
+func (app *App) loadLoginSession(r *http.Request) (*LoginSession, bool) {
+	cookie, err := r.Cookie(userSessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+
+	id := hashSessionToken(cookie.Value)
+	session, exists := app.store.GetLoginSession(id)
+	if !exists {
+		return nil, false
+	}
+
+	now := time.Now()
+	if session.Expired(now) {
+		if err := app.store.DeleteLoginSession(id); err != nil {
+			logger.Errorf("Failed to delete expired login session: %v", err)
+		}
+		return nil, false
+	}
+
+	if err := app.store.TouchLoginSession(id, now); err != nil {
+		logger.Errorf("Failed to refresh login session: %v", err)
+	}
+	session.LastSeenAt = now
+
+	return session, true
+}
+
+// requireRecentVerification checks that the request has a login session with
+// user verification inside the configured window (Config.Sessions.ReauthWindow),
+// the period during which sensitive operations such as adding a passkey are
+// allowed without verifying again.
+//
+// On failure it writes 401 AUTH_REQUIRED or 403 REAUTH_REQUIRED and returns
+// ok=false; callers simply return.
+func (app *App) requireRecentVerification(w http.ResponseWriter, r *http.Request) (session *LoginSession, user *User, ok bool) {
+	session, ok = getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	logUser(r.Context(), user.Username)
+
+	if !session.RecentlyVerified(time.Now(), app.config.Sessions.ReauthWindow) {
+		app.writeAppError(w, ErrReauthRequired, http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	return session, user, true
+}
+
+// clearUserSessionCookie tells the browser to drop the user-session cookie.
+func (app *App) clearUserSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, app.config.Cookies.newCookie(userSessionCookie, "", -1))
+}
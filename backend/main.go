@@ -1,65 +1,97 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
 )
 
-// Global logger instance
-var logger = NewLogger("passkey-backend")
+// Global logger instance, reconfigured from the configuration at startup
+var logger = NewLogger(logServiceName, DefaultConfig().Logging)
 
 func main() {
-	// Parse command line flags
-	localhost := flag.Bool("localhost", false, "Force localhost mode (ignore NGROK_URL)")
+	// Parse command line flags (see config.go for the full configuration)
+	flags := registerConfigFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [print-config]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// Get ngrok URL from environment variable or force localhost
-	var ngrokURL string
-	if *localhost {
-		ngrokURL = "https://your-tunnel.ngrok.io" // Force localhost mode
-		logger.Printf("🏠 Localhost mode forced via -localhost flag")
-	} else {
-		ngrokURL = os.Getenv("NGROK_URL")
-		if ngrokURL == "" {
-			ngrokURL = "https://your-tunnel.ngrok.io" // Placeholder
+	cfg, err := LoadConfig(flag.CommandLine, flags)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logger = NewLogger(logServiceName, cfg.Logging)
+
+	// print-config shows the effective configuration and exits
+	switch flag.Arg(0) {
+	case "":
+	case "print-config":
+		if err := cfg.WriteYAML(os.Stdout); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
 		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *flags.localhost {
+		logger.Printf("🏠 Localhost mode forced via -localhost flag")
 	}
-	
-	// Extract domain from ngrok URL for RPID
-	rpid := "localhost" // Default fallback
-	if ngrokURL != "https://your-tunnel.ngrok.io" {
-		// Extract domain from ngrok URL (e.g., "https://abc123.ngrok.io" -> "abc123.ngrok.io")
-		if len(ngrokURL) > 8 { // Remove "https://"
-			rpid = ngrokURL[8:]
+
+	// Session lifetimes are shared by the stores and handlers
+	sessionTTL = cfg.Sessions.Ceremony
+	loginSessionLifetime = cfg.Sessions.Lifetime
+	loginSessionIdleTimeout = cfg.Sessions.IdleTimeout
+
+	// Attestation is only worth requesting when something verifies it
+	attestationPreference := protocol.PreferNoAttestation
+	if cfg.Attestation.Conveyance == "direct" {
+		attestationPreference = protocol.PreferDirectAttestation
+	}
+
+	// Load FIDO metadata, if configured
+	var mds *MetadataService
+	if cfg.Attestation.MDSBlob != "" {
+		mds, err = NewMetadataService(cfg.Attestation.MDSBlob, cfg.Attestation.MDSRoot, cfg.Attestation.MDSEnforce)
+		if err != nil {
+			log.Fatalf("Failed to load MDS BLOB: %v", err)
+		}
+		if next := mds.NextUpdate(); time.Now().After(next) {
+			logger.Warn("MDS BLOB is stale", "next_update", next.Format(time.DateOnly))
 		}
 	}
 
-	// Initialize WebAuthn with ngrok-based configuration
-	// This enables passkey sharing across web and iOS platforms using ngrok tunneling
+	// Initialize WebAuthn from the relying party configuration
+	// The same RP ID lets passkeys be shared across web and iOS platforms
 	config := &webauthn.Config{
-		RPDisplayName: "WebAuthn Passkey Demo",
-		RPID:          rpid, // Use ngrok domain for cross-platform compatibility
-		RPOrigins: []string{
-			// ngrok tunnel (primary for production-like testing)
-			ngrokURL,
-			// Localhost fallback for development
-			"http://localhost:5173", // React dev server fallback
-		},
-		AttestationPreference: protocol.PreferNoAttestation,
+		RPDisplayName:         cfg.RelyingParty.DisplayName,
+		RPID:                  cfg.RelyingParty.ID,
+		RPOrigins:             cfg.WebAuthnOrigins(), // Web origins plus Android app origins
+		AttestationPreference: attestationPreference,
+		MDS:                   mds.Provider(),
 		// Default authenticator selection - will be overridden per-request
 		AuthenticatorSelection: protocol.AuthenticatorSelection{
 			// Platform authenticators (built-in biometrics) preferred but not required
 			AuthenticatorAttachment: protocol.Platform,
 			// Require resident keys for discoverable credentials (passkeys)
-			ResidentKey: protocol.ResidentKeyRequirementPreferred,
+			ResidentKey:        protocol.ResidentKeyRequirementPreferred,
 			RequireResidentKey: protocol.ResidentKeyNotRequired(),
 			// User verification preferred to allow fallback if biometrics unavailable
 			UserVerification: protocol.VerificationPreferred,
@@ -67,11 +99,11 @@ func main() {
 		Timeouts: webauthn.TimeoutsConfig{
 			Registration: webauthn.TimeoutConfig{
 				Enforce: true,
-				Timeout: 60 * time.Second,
+				Timeout: cfg.Timeouts.Registration,
 			},
 			Login: webauthn.TimeoutConfig{
 				Enforce: true,
-				Timeout: 60 * time.Second,
+				Timeout: cfg.Timeouts.Login,
 			},
 		},
 	}
@@ -81,39 +113,144 @@ func main() {
 		log.Fatalf("Failed to create WebAuthn instance: %v", err)
 	}
 
-	// Initialize in-memory store
-	store := NewInMemoryStore()
+	// Initialize storage backend
+	store, err := NewStore(cfg.Storage.Kind, cfg.Storage.Path)
+	if err != nil {
+		log.Fatalf("Failed to initialize %s store: %v", cfg.Storage.Kind, err)
+	}
+	store = InstrumentStore(store)
+	metrics.ObserveStore(store)
+
+	// Load authenticator model names, if configured
+	var aaguids *AAGUIDRegistry
+	if cfg.AAGUIDFile != "" {
+		aaguids, err = NewAAGUIDRegistry(cfg.AAGUIDFile)
+		if err != nil {
+			log.Fatalf("Failed to load AAGUID listing: %v", err)
+		}
+
+		// Reload the listing on SIGHUP so it can be updated without a restart
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := aaguids.Reload(); err != nil {
+					logger.Errorf("Failed to reload AAGUID listing: %v", err)
+					continue
+				}
+				logger.Printf("Reloaded AAGUID listing: %d authenticators", aaguids.Len())
+			}
+		}()
+	}
+
+	// Load authenticator policy, if configured
+	var policy *AuthenticatorPolicy
+	if cfg.PolicyFile != "" {
+		policy, err = NewAuthenticatorPolicy(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("Failed to load authenticator policy: %v", err)
+		}
+	}
+
+	// Key signing transaction approvals; a temporary one unless configured
+	approvalKey, err := parseTransactionKey(cfg.Security.TransactionKey)
+	if err != nil {
+		log.Fatalf("Failed to load transaction key: %v", err)
+	}
+	if approvalKey == nil {
+		if _, approvalKey, err = ed25519.GenerateKey(nil); err != nil {
+			log.Fatalf("Failed to generate transaction key: %v", err)
+		}
+		logger.Warn("No security.transactionKey set, transaction approvals will not verify after a restart")
+	}
 
 	// Create app with dependencies
 	app := &App{
-		webAuthn: webAuthn,
-		store:    store,
+		config:      cfg,
+		webAuthn:    webAuthn,
+		store:       store,
+		aaguids:     aaguids,
+		mds:         mds,
+		policy:      policy,
+		limiter:     newRateLimiter(),
+		approvalKey: approvalKey,
 	}
 
+	// SIGINT/SIGTERM start a graceful shutdown (see the end of main)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Start cleanup routine for expired sessions
+	cleanupDone := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			store.CleanupExpiredSessions()
-		}
+		defer close(cleanupDone)
+		runSessionCleanup(ctx, store, 1*time.Minute)
 	}()
 
 	// Setup routes - organized by middleware requirements
 
 	// Main mux for all routes
 	mainMux := http.NewServeMux()
-	
+
 	// Create separate API mux with proper routing
 	apiMux := http.NewServeMux()
-	
+
 	// Registration endpoints
 	apiMux.HandleFunc("/api/register/begin", app.handleRegisterBegin)
 	apiMux.HandleFunc("/api/register/finish", app.handleRegisterFinish)
 
-	// Authentication endpoints  
+	// Authentication endpoints
 	apiMux.HandleFunc("/api/login/begin", app.handleLoginBegin)
 	apiMux.HandleFunc("/api/login/finish", app.handleLoginFinish)
+	apiMux.HandleFunc("/api/login/step-up/finish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleStepUpFinish(w, r)
+	})
+	apiMux.HandleFunc("/api/reauth/begin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleReauthBegin(w, r)
+	})
+	apiMux.HandleFunc("/api/reauth/finish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleReauthFinish(w, r)
+	})
+	apiMux.HandleFunc("/api/transactions/begin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleTransactionBegin(w, r)
+	})
+	apiMux.HandleFunc("/api/transactions/finish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleTransactionFinish(w, r)
+	})
+	apiMux.HandleFunc("/api/transactions/key", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleTransactionKey(w, r)
+	})
+	apiMux.HandleFunc("/api/recovery/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleRecoveryLogin(w, r)
+	})
 
 	// Other endpoints
 	apiMux.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
@@ -124,21 +261,68 @@ func main() {
 		app.handleLogout(w, r)
 	})
 
-	// Health check
-	apiMux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"ok","time":"%s"}`, time.Now().Format(time.RFC3339))
+	// Health checks: liveness (process is up) and readiness (can serve traffic)
+	apiMux.HandleFunc("/api/health", app.handleLive)
+	apiMux.HandleFunc("/api/health/live", app.handleLive)
+	apiMux.HandleFunc("/api/health/ready", app.handleReady)
+
+	// Admin API, enabled by admin.token
+	apiMux.HandleFunc("/api/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleAdminAudit(w, r)
+	})
+	apiMux.HandleFunc("/api/admin/audit/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleAdminAuditVerify(w, r)
+	})
+	apiMux.HandleFunc("/api/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+		// Lift a lockout: /api/admin/users/{username}/unlock
+		if !strings.HasSuffix(r.URL.Path, "/unlock") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		app.handleAdminUnlock(w, r)
 	})
 
 	// User routes handler - handles all /api/user/* routes
 	apiMux.HandleFunc("/api/user/", func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
-		
-		if strings.HasPrefix(path, "/api/user/passkeys/") && len(path) > len("/api/user/passkeys/") {
-			// Handle passkey deletion: /api/user/passkeys/{id}
+
+		if path == "/api/user/passkeys/register/begin" || path == "/api/user/passkeys/register/finish" {
+			// Add a passkey to the logged-in account
+			if r.Method != "POST" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if strings.HasSuffix(path, "/begin") {
+				app.handleAddPasskeyBegin(w, r)
+			} else {
+				app.handleAddPasskeyFinish(w, r)
+			}
+		} else if strings.HasPrefix(path, "/api/user/passkeys/") && strings.HasSuffix(path, "/reinstate") {
+			// Clear a clone warning: /api/user/passkeys/{id}/reinstate
+			if r.Method != "POST" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			app.handleReinstatePasskey(w, r)
+		} else if strings.HasPrefix(path, "/api/user/passkeys/") && len(path) > len("/api/user/passkeys/") && !strings.Contains(strings.TrimPrefix(path, "/api/user/passkeys/"), "/") {
+			// Handle passkey deletion and rename: /api/user/passkeys/{id}
 			switch r.Method {
 			case "DELETE":
-				app.handleDeletePasskey(w, r)
+				app.requireReauth(app.handleDeletePasskey)(w, r)
+			case "PATCH":
+				app.requireReauth(app.handleRenamePasskey)(w, r)
 			default:
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
@@ -150,6 +334,20 @@ func main() {
 			default:
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
+		} else if path == "/api/user/security-activity" {
+			// Recent audit events for the logged-in user
+			if r.Method != "GET" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			app.handleSecurityActivity(w, r)
+		} else if path == "/api/user/recovery-codes" {
+			// Remaining count (GET) or a new set (POST)
+			if r.Method != "GET" && r.Method != "POST" {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			app.handleRecoveryCodes(w, r)
 		} else if strings.Contains(path, "/profile") || path == "/api/user/" {
 			// Handle profile: /api/user/ or /api/user/{username}/profile
 			if r.Method != "GET" {
@@ -161,39 +359,42 @@ func main() {
 			http.NotFound(w, r)
 		}
 	})
-	
+
 	// Apply middleware to API routes
-	apiHandler := corsMiddleware(
+	trustedProxies, _ := parseTrustedProxies(cfg.TrustedProxies) // Checked by Validate
+	apiHandler := corsMiddleware(cfg.BrowserOrigins(),
 		logger.LogHTTP(
-			app.sessionMiddleware(
-				jsonMiddleware(apiMux),
+			clientIPMiddleware(trustedProxies,
+				app.sessionMiddleware(
+					jsonMiddleware(
+						app.rateLimitMiddleware(apiMux),
+					),
+				),
 			),
 		),
 	)
-	
-	// Mount API handler  
+
+	// Mount API handler
 	mainMux.Handle("/api/", apiHandler)
-	
+
 	// Static files without middleware
-	mainMux.HandleFunc("/.well-known/apple-app-site-association", func(w http.ResponseWriter, r *http.Request) {
-		logger.Printf("🍎 AASA file requested from: %s (User-Agent: %s)", r.RemoteAddr, r.UserAgent())
-		w.Header().Set("Content-Type", "application/json")
-		http.ServeFile(w, r, "static/.well-known/apple-app-site-association")
-	})
-	mainMux.Handle("/.well-known/", http.StripPrefix("/.well-known/", http.FileServer(http.Dir("static/.well-known/"))))
+	mainMux.HandleFunc("/.well-known/apple-app-site-association", app.handleAppleAppSiteAssociation)
+	mainMux.HandleFunc("/.well-known/assetlinks.json", app.handleAssetLinks)
+	mainMux.HandleFunc("/.well-known/webauthn", app.handleWebAuthnRelatedOrigins)
+	mainMux.Handle("/metrics", metrics.Handler())
 	mainMux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-	
+
 	// React app serving
 	reactDistPath := "../frontend-react/dist"
 	if _, err := os.Stat(reactDistPath); err == nil {
 		fmt.Println("📦 Serving React build from /frontend-react/dist")
-		
+
 		// Serve React static assets
 		mainMux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir(reactDistPath+"/assets/"))))
 		mainMux.HandleFunc("/vite.svg", func(w http.ResponseWriter, r *http.Request) {
 			http.ServeFile(w, r, reactDistPath+"/vite.svg")
 		})
-		
+
 		// Catch-all: serve index.html for SPA routing
 		mainMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			logger.Printf("Serving HTML for: %s", r.URL.Path)
@@ -206,23 +407,52 @@ func main() {
 	fmt.Println("🚀 WebAuthn Passkey Demo Backend")
 	fmt.Println("=================================")
 	fmt.Printf("🔐 RPID: %s\n", config.RPID)
-	
-	if rpid == "localhost" {
+	for _, origin := range cfg.RelyingParty.RelatedOrigins {
+		fmt.Printf("🌐 Related origin: %s\n", origin)
+	}
+	if cfg.Storage.Kind == "sqlite" {
+		fmt.Printf("💾 Store: sqlite (%s)\n", cfg.Storage.Path)
+	} else {
+		fmt.Println("💾 Store: memory (data is lost on restart)")
+	}
+	fmt.Printf("📜 Attestation: %s\n", cfg.Attestation.Conveyance)
+	if mds != nil {
+		fmt.Printf("🛡️  MDS BLOB: %s (%d authenticators, enforce=%t)\n", cfg.Attestation.MDSBlob, mds.Len(), cfg.Attestation.MDSEnforce)
+	}
+	if policy != nil {
+		fmt.Printf("📋 Policy: %s (%d rules, default %s)\n", cfg.PolicyFile, policy.Len(), policy.Default)
+	}
+	for _, apple := range cfg.Apple.Apps {
+		fmt.Printf("🍎 Apple app: %s\n", apple.AppID())
+	}
+	for _, android := range cfg.Android.Apps {
+		fmt.Printf("🤖 Android app: %s (%d signing certificates)\n", android.PackageName, len(android.SHA256CertFingerprints))
+	}
+	if aaguids != nil {
+		fmt.Printf("🏷️  AAGUID listing: %s (%d authenticators)\n", cfg.AAGUIDFile, aaguids.Len())
+	}
+
+	scheme := "http"
+	if cfg.TLS.Enabled {
+		scheme = "https"
+	}
+	publicURL := cfg.RelyingParty.Origins[0]
+	if cfg.RelyingParty.ID == "localhost" {
 		fmt.Println("📍 Mode: Local Development")
-		fmt.Println("🏠 API: http://localhost:8080")
+		fmt.Printf("🏠 API: %s://localhost%s\n", scheme, cfg.Listen)
 		fmt.Println("")
 		fmt.Println("🌐 Access frontend at:")
-		fmt.Println("   http://localhost:5173 (with hot reload)")
+		fmt.Printf("   %s (with hot reload)\n", publicURL)
 		fmt.Println("")
 		fmt.Println("⚠️  Note: Cross-platform passkeys won't work in localhost mode")
 		fmt.Println("   Use ngrok mode for iOS/cross-platform testing")
 	} else {
-		fmt.Println("🌍 Mode: ngrok (Cross-Platform)")
-		fmt.Printf("📡 Public API: %s/api\n", ngrokURL)
+		fmt.Println("🌍 Mode: Public (Cross-Platform)")
+		fmt.Printf("📡 Public API: %s/api\n", publicURL)
 		fmt.Println("")
 		if _, err := os.Stat("../frontend-react/dist"); err == nil {
 			fmt.Println("🌐 Access app at:")
-			fmt.Printf("   %s\n", ngrokURL)
+			fmt.Printf("   %s\n", publicURL)
 			fmt.Println("   (serving React build)")
 		} else {
 			fmt.Println("⚠️  React build not found!")
@@ -234,10 +464,62 @@ func main() {
 
 	// Start server
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: mainMux,
+		Addr:    cfg.Listen,
+		Handler: metrics.InstrumentHTTP(mainMux),
 	}
 
-	fmt.Println("🌟 Starting server on port 8080...")
-	log.Fatal(server.ListenAndServe())
-}
\ No newline at end of file
+	// Built-in HTTPS: configured certificate or generated development CA
+	var redirect *http.Server
+	if cfg.TLS.Enabled {
+		server.TLSConfig, err = cfg.TLS.serverTLSConfig(cfg.RelyingParty.ID)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		if cfg.TLS.Cert == "" {
+			fmt.Printf("🔏 TLS: development certificate (trust %s on test devices)\n", filepath.Join(cfg.TLS.Dir, devCAFile))
+		} else {
+			fmt.Printf("🔏 TLS: %s\n", cfg.TLS.Cert)
+		}
+		if cfg.TLS.RedirectListen != "" {
+			redirect = &http.Server{Addr: cfg.TLS.RedirectListen, Handler: httpsRedirectHandler(cfg.Listen)}
+			go func() {
+				// Redirecting is a convenience; HTTPS keeps running without it
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Errorf("HTTP redirect on %s stopped: %v", cfg.TLS.RedirectListen, err)
+				}
+			}()
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS.Enabled {
+			fmt.Printf("🌟 Starting HTTPS server on %s...\n", cfg.Listen)
+			serveErr <- server.ListenAndServeTLS("", "")
+		} else {
+			fmt.Printf("🌟 Starting server on %s...\n", cfg.Listen)
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Server failed: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process immediately
+
+	// Graceful shutdown: fail readiness while still serving, stop accepting
+	// connections and wait for in-flight requests, then stop background work
+	// and flush the store
+	logger.Printf("🛑 Shutting down: failing readiness for %s, then draining connections for up to %s...", cfg.DrainDelay, cfg.Shutdown)
+	if err := app.shutdown(cfg.DrainDelay, cfg.Shutdown, server, redirect); err != nil {
+		logger.Errorf("Connections still open after %s: %v", cfg.Shutdown, err)
+	}
+
+	<-cleanupDone
+	if err := store.Close(); err != nil {
+		logger.Errorf("Failed to close %s store: %v", cfg.Storage.Kind, err)
+	}
+	logger.Printf("👋 Shutdown complete")
+}
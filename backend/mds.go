@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/go-webauthn/webauthn/metadata/providers/memory"
+	"github.com/google/uuid"
+)
+
+// MetadataService holds a FIDO Metadata Service (MDS3) BLOB loaded from disk.
+//
+// The BLOB is a JWT signed by the FIDO Alliance; its certificate chain is
+// verified against a locally configured root before any entry is trusted.
+// Once loaded it serves two purposes:
+//   - Registration: it is installed as webauthn.Config.MDS, so attestation
+//     statements are checked against the authenticator's metadata and trust
+//     anchors, and (when enforcing) authenticators with undesired status
+//     reports such as REVOKED or USER_KEY_REMOTE_COMPROMISE are rejected
+//   - Display: Annotate adds certification level and status to PasskeyInfo
+//
+// A nil *MetadataService disables both.
+type MetadataService struct {
+	provider   metadata.Provider
+	entries    map[uuid.UUID]*metadata.Entry
+	nextUpdate time.Time
+}
+
+// NewMetadataService loads and verifies the MDS3 BLOB at blobPath.
+//
+// rootPath is the trust anchor for the BLOB signature, as PEM or DER. If it
+// is empty the FIDO Alliance production root is used.
+//
+// When enforce is true, registrations from authenticators with undesired
+// status reports fail. Otherwise they succeed and are only flagged.
+// Attestation statements from authenticators the BLOB describes are always
+// checked against its trust anchors.
+func NewMetadataService(blobPath, rootPath string, enforce bool) (*MetadataService, error) {
+	opts := []metadata.DecoderOption{metadata.WithIgnoreEntryParsingErrors()}
+	if rootPath != "" {
+		root, err := loadMDSRoot(rootPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, metadata.WithRootCertificate(root))
+	}
+
+	decoder, err := metadata.NewDecoder(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create MDS decoder: %w", err)
+	}
+
+	blob, err := os.ReadFile(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("read MDS BLOB: %w", err)
+	}
+
+	payload, err := decoder.DecodeBytes(blob)
+	if err != nil {
+		return nil, fmt.Errorf("verify MDS BLOB %s: %w", blobPath, err)
+	}
+
+	parsed, err := decoder.Parse(payload)
+	if err != nil {
+		return nil, fmt.Errorf("parse MDS BLOB %s: %w", blobPath, err)
+	}
+	if n := len(parsed.Unparsed); n != 0 {
+		logger.Printf("MDS BLOB: skipped %d entries that could not be parsed", n)
+	}
+
+	entries := parsed.ToMap()
+	provider, err := memory.New(
+		memory.WithMetadata(entries),
+		// Most passkey providers have no MDS entry; only vet the ones that do
+		memory.WithValidateEntry(false),
+		memory.WithValidateEntryPermitZeroAAGUID(true),
+		memory.WithValidateTrustAnchor(true),
+		memory.WithValidateStatus(enforce),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create MDS provider: %w", err)
+	}
+
+	return &MetadataService{
+		provider:   provider,
+		entries:    entries,
+		nextUpdate: parsed.Parsed.NextUpdate,
+	}, nil
+}
+
+// loadMDSRoot reads a root certificate and returns it in the base64 DER form
+// expected by metadata.WithRootCertificate.
+func loadMDSRoot(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read MDS root certificate: %w", err)
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Provider returns the metadata.Provider to install as webauthn.Config.MDS,
+// or nil when no BLOB is configured.
+func (m *MetadataService) Provider() metadata.Provider {
+	if m == nil {
+		return nil
+	}
+	return m.provider
+}
+
+// Len returns the number of authenticators described by the BLOB.
+func (m *MetadataService) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+// NextUpdate is the date by which the FIDO Alliance publishes a newer BLOB.
+func (m *MetadataService) NextUpdate() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return m.nextUpdate
+}
+
+// Entry looks up the metadata for an AAGUID given in hex or dashed form.
+func (m *MetadataService) Entry(aaguid string) (*metadata.Entry, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	id, err := uuid.Parse(aaguid)
+	if err != nil || id == uuid.Nil {
+		return nil, false
+	}
+
+	entry, ok := m.entries[id]
+	return entry, ok
+}
+
+// CheckStatus reports whether the authenticator's status reports include any
+// undesired status (REVOKED, key compromise, UV bypass). The error describes
+// which ones, and is nil for unknown authenticators.
+func (m *MetadataService) CheckStatus(aaguid string) error {
+	entry, ok := m.Entry(aaguid)
+	if !ok {
+		return nil
+	}
+	return metadata.ValidateStatusReports(entry.StatusReports, nil, metadata.DefaultUndesiredAuthenticatorStatuses())
+}
+
+// Annotate adds certification level and metadata status to passkeys whose
+// authenticator is described by the BLOB.
+func (m *MetadataService) Annotate(passkeys []PasskeyInfo) {
+	for i := range passkeys {
+		entry, ok := m.Entry(passkeys[i].AAGUID)
+		if !ok {
+			continue
+		}
+
+		passkeys[i].CertificationLevel = certificationLevel(entry.StatusReports)
+		if n := len(entry.StatusReports); n > 0 {
+			passkeys[i].MetadataStatus = string(entry.StatusReports[n-1].Status)
+		}
+		passkeys[i].MetadataFlagged = m.CheckStatus(passkeys[i].AAGUID) != nil
+
+		if passkeys[i].AuthenticatorName == "" {
+			passkeys[i].AuthenticatorName = entry.MetadataStatement.Description
+		}
+	}
+}
+
+// certificationLevel returns the most recent FIDO certification status, e.g.
+// "FIDO_CERTIFIED_L2", or "" if the authenticator was never certified.
+//
+// MDS3 lists status reports in chronological order, so the last
+// certification report wins.
+func certificationLevel(reports []metadata.StatusReport) string {
+	level := ""
+	for _, report := range reports {
+		status := string(report.Status)
+		if strings.HasPrefix(status, "FIDO_CERTIFIED") || status == string(metadata.NotFidoCertified) {
+			level = status
+		}
+	}
+	return level
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+)
+
+func TestCertificationLevel(t *testing.T) {
+	tests := []struct {
+		statuses []metadata.AuthenticatorStatus
+		want     string
+	}{
+		{nil, ""},
+		{[]metadata.AuthenticatorStatus{metadata.FidoCertifiedL1, metadata.UpdateAvailable, metadata.FidoCertifiedL2}, "FIDO_CERTIFIED_L2"},
+		{[]metadata.AuthenticatorStatus{metadata.FidoCertifiedL2, metadata.Revoked}, "FIDO_CERTIFIED_L2"},
+		{[]metadata.AuthenticatorStatus{metadata.NotFidoCertified}, "NOT_FIDO_CERTIFIED"},
+	}
+	for _, tc := range tests {
+		var reports []metadata.StatusReport
+		for _, status := range tc.statuses {
+			reports = append(reports, metadata.StatusReport{Status: status})
+		}
+		if got := certificationLevel(reports); got != tc.want {
+			t.Errorf("certificationLevel(%v) = %q, want %q", tc.statuses, got, tc.want)
+		}
+	}
+}
+
+func TestMetadataServiceAnnotate(t *testing.T) {
+	certified, revoked := uuid.New(), uuid.New()
+	mds := &MetadataService{entries: map[uuid.UUID]*metadata.Entry{
+		certified: {
+			MetadataStatement: metadata.Statement{Description: "Example Key"},
+			StatusReports:     []metadata.StatusReport{{Status: metadata.FidoCertifiedL1}},
+		},
+		revoked: {
+			StatusReports: []metadata.StatusReport{{Status: metadata.FidoCertifiedL1}, {Status: metadata.Revoked}},
+		},
+	}}
+
+	passkeys := []PasskeyInfo{
+		{AAGUID: fmt.Sprintf("%x", certified[:])},
+		{AAGUID: fmt.Sprintf("%x", revoked[:]), AuthenticatorName: "From listing"},
+		{AAGUID: fmt.Sprintf("%x", uuid.Nil[:])},
+	}
+	mds.Annotate(passkeys)
+
+	if p := passkeys[0]; p.CertificationLevel != "FIDO_CERTIFIED_L1" || p.MetadataFlagged || p.AuthenticatorName != "Example Key" {
+		t.Errorf("certified passkey = %+v", p)
+	}
+	if p := passkeys[1]; p.MetadataStatus != "REVOKED" || !p.MetadataFlagged || p.AuthenticatorName != "From listing" {
+		t.Errorf("revoked passkey = %+v", p)
+	}
+	if p := passkeys[2]; p.CertificationLevel != "" || p.MetadataFlagged {
+		t.Errorf("zero AAGUID passkey = %+v", p)
+	}
+
+	var none *MetadataService
+	if none.Provider() != nil || none.Len() != 0 || none.CheckStatus(passkeys[1].AAGUID) != nil {
+		t.Error("nil metadata service is not a no-op")
+	}
+}
+
+func TestNewMetadataServiceMissingBlob(t *testing.T) {
+	if _, err := NewMetadataService(filepath.Join(t.TempDir(), "missing.jwt"), "", true); err == nil {
+		t.Error("NewMetadataService accepted a missing BLOB")
+	}
+}
+
+func TestWriteRegistrationError(t *testing.T) {
+	app := &App{}
+	user := &User{Username: "alice"}
+	req := httptest.NewRequest(http.MethodPost, "/api/register/finish", nil)
+
+	rec := httptest.NewRecorder()
+	app.writeRegistrationError(rec, req, user, protocol.ErrMetadata.WithDetails("revoked"))
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != ErrUntrustedAuthenticator.Code {
+		t.Errorf("metadata rejection: status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	app.writeRegistrationError(rec, req, user, protocol.ErrVerification.WithDetails("bad signature"))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("verification failure: status %d, want 400", rec.Code)
+	}
+}
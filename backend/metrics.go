@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics holds the backend's Prometheus metrics, served in the text
+// exposition format at /metrics.
+//
+// The registry is deliberately small (counters, histograms and gauges read
+// at scrape time) so the demo does not need the Prometheus client library.
+// Label values must come from bounded sets: route templates, ceremony names,
+// error codes. Never usernames or credential IDs.
+//
+// Metric families:
+//   - passkey_ceremonies_total: begin/finish requests by ceremony, outcome and failure reason
+//   - passkey_logins_total: successful logins by mode (username or discoverable)
+//   - passkey_clone_warnings_total: logins whose sign counter went backwards
+//   - passkey_deleted_credential_attempts_total: assertions from credentials no longer registered
+//   - passkey_webauthn_sessions, passkey_login_sessions: live sessions in the store
+//   - passkey_store_operation_duration_seconds, passkey_store_errors_total: Store calls
+//   - passkey_http_request_duration_seconds: HTTP latency by route
+//   - passkey_rate_limited_total: requests refused with 429, by route and scope (ip or user)
+//   - passkey_lockouts_total: accounts and credentials locked after failed assertions
+type Metrics struct {
+	mu         sync.Mutex
+	collectors []collector // Exposition order
+
+	Ceremonies                *CounterVec
+	Logins                    *CounterVec
+	CloneWarnings             *CounterVec
+	DeletedCredentialAttempts *CounterVec
+	StoreDuration             *HistogramVec
+	StoreErrors               *CounterVec
+	HTTPDuration              *HistogramVec
+	RateLimited               *CounterVec
+	Lockouts                  *CounterVec
+}
+
+// metrics is the process-wide registry, like logger.
+var metrics = NewMetrics()
+
+// Histogram buckets in seconds. Store calls are expected to be far faster
+// than whole HTTP requests.
+var (
+	httpDurationBuckets  = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	storeDurationBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25}
+)
+
+// NewMetrics creates a registry with every backend metric registered.
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.Ceremonies = m.NewCounterVec("passkey_ceremonies_total",
+		"WebAuthn ceremony requests by ceremony, phase, outcome and failure reason.",
+		"ceremony", "phase", "outcome", "reason")
+	m.Logins = m.NewCounterVec("passkey_logins_total",
+		"Successful logins by mode (username, discoverable or recovery_code).", "mode")
+	m.CloneWarnings = m.NewCounterVec("passkey_clone_warnings_total",
+		"Logins whose authenticator sign counter did not increase.")
+	m.DeletedCredentialAttempts = m.NewCounterVec("passkey_deleted_credential_attempts_total",
+		"Valid assertions from credentials that have been deleted from the account.")
+	m.StoreDuration = m.NewHistogramVec("passkey_store_operation_duration_seconds",
+		"Store call latency by operation.", storeDurationBuckets, "operation")
+	m.StoreErrors = m.NewCounterVec("passkey_store_errors_total",
+		"Store calls that failed with a storage error, by operation.", "operation")
+	m.HTTPDuration = m.NewHistogramVec("passkey_http_request_duration_seconds",
+		"HTTP request latency by method, route and status code.", httpDurationBuckets, "method", "route", "code")
+	m.RateLimited = m.NewCounterVec("passkey_rate_limited_total",
+		"Requests refused because a rate limit budget was spent, by route and scope (ip or user).", "route", "scope")
+	m.Lockouts = m.NewCounterVec("passkey_lockouts_total",
+		"Failed assertions that locked, or extended the lock on, an account or credential, by scope (user or credential).", "scope")
+	return m
+}
+
+// collector writes one metric family in the text exposition format.
+type collector interface {
+	writeTo(w io.Writer)
+}
+
+func (m *Metrics) register(c collector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, c)
+}
+
+// Handler serves every registered metric family.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		collectors := slices.Clone(m.collectors)
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, c := range collectors {
+			c.writeTo(w)
+		}
+	})
+}
+
+// CounterVec is a family of monotonically increasing counters partitioned
+// by label values.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func (m *Metrics) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+	m.register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter with the given label values by v.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := seriesKey(c.name, c.labels, labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labelValues: labelValues}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *CounterVec) writeTo(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name) // Unlabelled counters start at zero
+		return
+	}
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // Upper bounds, ascending; +Inf is implied
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // Per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec registers a histogram family with the given buckets and
+// label names.
+func (m *Metrics) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	m.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := seriesKey(h.name, h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) writeTo(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bucketLabels := append(slices.Clone(h.labels), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(bucketLabels, append(slices.Clone(s.labelValues), formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			formatLabels(bucketLabels, append(slices.Clone(s.labelValues), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when /metrics is scraped.
+type GaugeFunc struct {
+	name, help string
+	value      func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge computed by value at scrape time. If value
+// fails, the error is logged and the sample omitted for that scrape.
+func (m *Metrics) NewGaugeFunc(name, help string, value func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	m.register(g)
+	return g
+}
+
+func (g *GaugeFunc) writeTo(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	v, err := g.value()
+	if err != nil {
+		logger.Errorf("metrics: %s: %v", g.name, err)
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(v))
+}
+
+// ObserveStore registers gauges for the sessions currently held by store.
+func (m *Metrics) ObserveStore(store Store) {
+	m.NewGaugeFunc("passkey_webauthn_sessions", "Unexpired WebAuthn ceremony sessions in the store.", func() (float64, error) {
+		ceremony, _, err := store.SessionCounts()
+		return float64(ceremony), err
+	})
+	m.NewGaugeFunc("passkey_login_sessions", "Unexpired login sessions in the store.", func() (float64, error) {
+		_, login, err := store.SessionCounts()
+		return float64(login), err
+	})
+}
+
+// seriesKey joins label values into a map key, panicking on a label count
+// mismatch since that is always a programming error.
+func seriesKey(name string, labels, values []string) string {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelValueEscaper escapes label values as the exposition format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelValueEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Ceremony phases used as the "phase" label.
+const (
+	phaseBegin  = "begin"
+	phaseFinish = "finish"
+)
+
+// ceremonyRoutes maps ceremony endpoints to their ceremony and phase, so
+// InstrumentHTTP can count outcomes without every handler doing it.
+var ceremonyRoutes = map[string]struct{ ceremony, phase string }{
+	"/api/register/begin":                {ceremonyRegister, phaseBegin},
+	"/api/register/finish":               {ceremonyRegister, phaseFinish},
+	"/api/user/passkeys/register/begin":  {ceremonyAddPasskey, phaseBegin},
+	"/api/user/passkeys/register/finish": {ceremonyAddPasskey, phaseFinish},
+	"/api/login/begin":                   {ceremonyLogin, phaseBegin},
+	"/api/login/finish":                  {ceremonyLogin, phaseFinish},
+	"/api/login/step-up/finish":          {ceremonyStepUp, phaseFinish},
+	"/api/reauth/begin":                  {ceremonyReauth, phaseBegin},
+	"/api/reauth/finish":                 {ceremonyReauth, phaseFinish},
+	"/api/transactions/begin":            {ceremonyTransaction, phaseBegin},
+	"/api/transactions/finish":           {ceremonyTransaction, phaseFinish},
+}
+
+// InstrumentHTTP records request latency by route and, for ceremony
+// endpoints, the outcome.
+//
+// A ceremony request succeeds when it returns a 2xx/3xx status. Failures are
+// labelled with the AppError code the handler wrote (see recordErrorCode),
+// or HTTP_<status> for plain errors.
+func (m *Metrics) InstrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &metricsResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		m.HTTPDuration.ObserveSince(start, r.Method, routeLabel(r.URL.Path, rec.status), strconv.Itoa(rec.status))
+
+		route, ok := ceremonyRoutes[r.URL.Path]
+		if !ok || r.Method != http.MethodPost {
+			return
+		}
+		outcome, reason := "success", ""
+		if rec.status >= 400 {
+			outcome, reason = "failure", rec.code
+			if reason == "" {
+				reason = "HTTP_" + strconv.Itoa(rec.status)
+			}
+		}
+		m.Ceremonies.Inc(route.ceremony, route.phase, outcome, reason)
+	})
+}
+
+// metricRoutes lists every route template that may appear in the "route"
+// label. A segment in braces matches any single non-empty path segment, and
+// the first matching template wins.
+var metricRoutes = []string{
+	"/api/register/begin",
+	"/api/register/finish",
+	"/api/login/begin",
+	"/api/login/finish",
+	"/api/login/step-up/finish",
+	"/api/recovery/login",
+	"/api/reauth/begin",
+	"/api/reauth/finish",
+	"/api/transactions/begin",
+	"/api/transactions/finish",
+	"/api/transactions/key",
+	"/api/logout",
+	"/api/health",
+	"/api/health/live",
+	"/api/health/ready",
+	"/api/admin/audit",
+	"/api/admin/audit/verify",
+	"/api/admin/users/{username}/unlock",
+	"/api/user/",
+	"/api/user/passkeys",
+	"/api/user/security-activity",
+	"/api/user/recovery-codes",
+	"/api/user/passkeys/register/begin",
+	"/api/user/passkeys/register/finish",
+	"/api/user/passkeys/{id}",
+	"/api/user/passkeys/{id}/reinstate",
+	"/api/user/{username}/profile",
+	"/.well-known/apple-app-site-association",
+	"/.well-known/assetlinks.json",
+	"/.well-known/webauthn",
+	"/metrics",
+}
+
+// routeLabel collapses a request path to one of metricRoutes.
+//
+// Anything else under the API, the well-known files or /metrics is grouped
+// as "unmatched", whatever status it got (CORS preflights and session checks
+// answer before the router does), so scanners cannot create new series. The
+// remaining paths (React assets, SPA routes) count as "static".
+func routeLabel(path string, status int) string {
+	if status == http.StatusNotFound {
+		return "unmatched"
+	}
+	for _, route := range metricRoutes {
+		if matchRoute(route, path) {
+			return route
+		}
+	}
+	if strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/.well-known/") || path == "/metrics" {
+		return "unmatched"
+	}
+	return "static"
+}
+
+// matchRoute reports whether path matches a metricRoutes template.
+func matchRoute(route, path string) bool {
+	routeSegments := strings.Split(route, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(routeSegments) != len(pathSegments) {
+		return false
+	}
+	for i, segment := range routeSegments {
+		if strings.HasPrefix(segment, "{") {
+			if pathSegments[i] == "" {
+				return false
+			}
+		} else if segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// metricsResponseWriter captures the status and error code of a response.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status int
+	code   string // AppError code, if the handler wrote one
+}
+
+func (rw *metricsResponseWriter) WriteHeader(code int) {
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController and recordErrorCode reach the
+// underlying writer.
+func (rw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// recordErrorCode notes an AppError code on the response so InstrumentHTTP
+// can use it as the failure reason. Other middleware wrapping the writer
+// must implement Unwrap for the code to be found.
+func recordErrorCode(w http.ResponseWriter, code string) {
+	for w != nil {
+		if rw, ok := w.(*metricsResponseWriter); ok {
+			rw.code = code
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouteLabel(t *testing.T) {
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/api/login/finish", http.StatusOK, "/api/login/finish"},
+		{"/api/user/passkeys", http.StatusOK, "/api/user/passkeys"},
+		{"/api/user/passkeys/register/begin", http.StatusOK, "/api/user/passkeys/register/begin"},
+		{"/api/user/passkeys/Y3JlZC0x", http.StatusNoContent, "/api/user/passkeys/{id}"},
+		{"/api/user/alice/profile", http.StatusOK, "/api/user/{username}/profile"},
+		{"/.well-known/webauthn", http.StatusOK, "/.well-known/webauthn"},
+		{"/metrics", http.StatusOK, "/metrics"},
+		{"/api/login/finish", http.StatusNotFound, "unmatched"},
+		{"/api/wp-login.php", http.StatusOK, "unmatched"}, // e.g. a CORS preflight
+		{"/api/user/passkeys/a/b", http.StatusUnauthorized, "unmatched"},
+		{"/api/user/passkeys/register/other", http.StatusUnauthorized, "unmatched"},
+		{"/api/user/a/b/profile", http.StatusUnauthorized, "unmatched"},
+		{"/.well-known/security.txt", http.StatusOK, "unmatched"},
+		{"/profile", http.StatusOK, "static"},
+		{"/assets/index-abc123.js", http.StatusOK, "static"},
+	}
+	for _, tc := range tests {
+		if got := routeLabel(tc.path, tc.status); got != tc.want {
+			t.Errorf("routeLabel(%q, %d) = %q, want %q", tc.path, tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestInstrumentHTTPBoundedRoutes(t *testing.T) {
+	m := NewMetrics()
+	handler := m.InstrumentHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/login/finish" {
+			recordErrorCode(w, "CEREMONY_ABORTED")
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	// Scanner-style traffic must not create a series per path
+	for i := 0; i < 100; i++ {
+		for _, path := range []string{"/api/probe-%d", "/api/user/passkeys/%d/x", "/api/user/%d/profile", "/.well-known/%d"} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodOptions, fmt.Sprintf(path, i), nil))
+		}
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/login/finish", nil))
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	var routes []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "passkey_http_request_duration_seconds_count") {
+			continue
+		}
+		_, route, _ := strings.Cut(line, `route="`)
+		route, _, _ = strings.Cut(route, `"`)
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	if want := []string{"/api/login/finish", "/api/user/{username}/profile", "unmatched"}; !slices.Equal(routes, want) {
+		t.Errorf("route labels = %q, want %q", routes, want)
+	}
+
+	want := `passkey_ceremonies_total{ceremony="login",phase="finish",outcome="failure",reason="CEREMONY_ABORTED"} 1`
+	if !strings.Contains(body, want) {
+		t.Errorf("metrics missing %s", want)
+	}
+}
@@ -2,60 +2,33 @@ package main
 
 import (
 	"net/http"
-	"os"
 	"strings"
 )
 
-// CORS middleware for multi-platform development with ngrok
-func corsMiddleware(next http.Handler) http.Handler {
+// corsMiddleware lets the configured frontend origins call the API with
+// cookies.
+//
+// The allowed origins are the relying party origins from the configuration:
+// a page that may run WebAuthn ceremonies against this server may also call
+// its API. Requests from any other origin get no CORS headers, so the
+// browser refuses to hand them the response.
+func corsMiddleware(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow requests from multiple frontend platforms
-		origin := r.Header.Get("Origin")
-		
-		// Get ngrok URL from environment
-		ngrokURL := os.Getenv("NGROK_URL")
-		
-		allowedOrigins := []string{
-			// Local development
-			"http://localhost:3000",          // React dev server
-			"http://localhost:5173",          // Vite dev server
-			"https://localhost:3000",         // React dev server HTTPS
-			"https://localhost:5173",         // Vite dev server HTTPS
-			// ngrok tunnel (dynamic)
-			ngrokURL,                         // Main ngrok URL
-		}
-		
-		// Check if origin is allowed
-		originAllowed := false
-		for _, allowed := range allowedOrigins {
-			if origin == allowed {
-				originAllowed = true
-				break
-			}
-		}
-		
-		// Also allow any ngrok.io domain for flexibility
-		if !originAllowed && origin != "" {
-			if strings.Contains(origin, ".ngrok.io") || 
-			   strings.Contains(origin, "localhost") {
-				originAllowed = true
-			}
-		}
-		
-		if originAllowed {
+		// Responses differ per origin, so caches must key on it
+		w.Header().Add("Vary", "Origin")
+
+		if origin := r.Header.Get("Origin"); allowed[origin] {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			// Default to ngrok URL
-			if ngrokURL != "" {
-				w.Header().Set("Access-Control-Allow-Origin", ngrokURL)
-			} else {
-				w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
-		
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -79,18 +52,33 @@ func jsonMiddleware(next http.Handler) http.Handler {
 }
 
 // Session middleware to extract session info
+//
+// Two cookies are handled here:
+//   - webauthn-session: ceremony session ID, passed through for finish handlers
+//   - user-session: opaque login token, validated against the store on every
+//     request; invalid or expired tokens are cleared from the browser
 func (app *App) sessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
 		// Extract session ID from cookie
 		cookie, err := r.Cookie("webauthn-session")
 		if err == nil {
 			// Add session ID to request context
-			ctx := r.Context()
 			ctx = setSessionID(ctx, cookie.Value)
-			r = r.WithContext(ctx)
 		}
 
-		next.ServeHTTP(w, r)
+		// Validate login session. Recovery sessions only count on the routes
+		// needed to enroll a passkey; elsewhere the request is signed out.
+		if session, ok := app.loadLoginSession(r); ok {
+			if !session.Recovery || recoverySessionPaths[r.URL.Path] {
+				ctx = setLoginSession(ctx, session)
+			}
+		} else if _, err := r.Cookie(userSessionCookie); err == nil {
+			app.clearUserSessionCookie(w)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
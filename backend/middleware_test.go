@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := corsMiddleware([]string{"https://example.com/", "https://login.example.com"}, next)
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		wantOrigin string
+		wantStatus int
+	}{
+		{"configured origin", http.MethodGet, "https://example.com", "https://example.com", http.StatusNoContent},
+		{"second origin", http.MethodGet, "https://login.example.com", "https://login.example.com", http.StatusNoContent},
+		{"unknown origin", http.MethodGet, "https://evil.example", "", http.StatusNoContent},
+		{"no origin", http.MethodGet, "", "", http.StatusNoContent},
+		{"preflight", http.MethodOptions, "https://example.com", "https://example.com", http.StatusOK},
+		{"preflight from unknown origin", http.MethodOptions, "https://evil.example", "", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/user", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tc.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); (got == "true") != (tc.wantOrigin != "") {
+				t.Errorf("Access-Control-Allow-Credentials = %q", got)
+			}
+		})
+	}
+}
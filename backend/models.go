@@ -13,12 +13,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net/http"
+	"slices"
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 )
 
 // User represents a user in the WebAuthn system and implements the webauthn.User interface.
@@ -27,17 +32,17 @@ import (
 //   - ID: Unique identifier for the user (must be consistent across sessions)
 //   - Username: Human-readable username (must be unique)
 //   - DisplayName: User's preferred display name (can be changed)
-//   - Credentials: All registered WebAuthn credentials for this user
+//   - Credentials: All registered WebAuthn credentials with usage metadata
 //   - CreatedAt: Account creation timestamp
 //
 // This implementation uses a UUID as the user ID to ensure uniqueness and
 // prevent user enumeration attacks.
 type User struct {
-	ID          []byte                  `json:"id"`          // WebAuthn user ID (UUID bytes)
-	Username    string                  `json:"username"`    // Unique username for login
-	DisplayName string                  `json:"displayName"` // User's display name
-	Credentials []webauthn.Credential   `json:"credentials"` // All registered credentials
-	CreatedAt   time.Time               `json:"createdAt"`   // Account creation time
+	ID          []byte             `json:"id"`          // WebAuthn user ID (UUID bytes)
+	Username    string             `json:"username"`    // Unique username for login
+	DisplayName string             `json:"displayName"` // User's display name
+	Credentials []CredentialRecord `json:"credentials"` // All registered credentials
+	CreatedAt   time.Time          `json:"createdAt"`   // Account creation time
 }
 
 // WebAuthnID returns the user's unique identifier for WebAuthn operations.
@@ -80,7 +85,102 @@ func (u User) WebAuthnDisplayName() string {
 // For security, only return credentials that are still valid and haven't been
 // revoked or deleted.
 func (u User) WebAuthnCredentials() []webauthn.Credential {
-	return u.Credentials
+	credentials := make([]webauthn.Credential, len(u.Credentials))
+	for i, record := range u.Credentials {
+		credentials[i] = record.Credential
+	}
+	return credentials
+}
+
+// CredentialRecord wraps a webauthn.Credential with bookkeeping that the
+// WebAuthn library does not track.
+//
+// The embedded credential is what the library verifies against; the extra
+// fields exist so the management screens can show real data:
+//   - Nickname: user-assigned label, set at registration and via rename
+//   - CreatedAt/RegisteredUserAgent: captured once at registration
+//   - LastUsedAt/LastUsedIP/LastUsedUserAgent/UseCount: updated on every
+//     successful login by App.updateUserCredential
+//   - SuspectAt/Suspended: set by the clone policy (see
+//     App.checkCredentialStanding) and cleared when the owner reinstates it
+//   - LastSignCount: the counter of the latest assertion, which differs from
+//     Authenticator.SignCount after a clone warning
+//
+// The embedded fields are flattened in JSON, so records written before this
+// metadata existed still decode (with zero values for the new fields).
+type CredentialRecord struct {
+	webauthn.Credential
+	CreatedAt           time.Time `json:"createdAt"`           // When the credential was registered
+	RegisteredUserAgent string    `json:"registeredUserAgent"` // User-Agent that registered it
+	LastUsedAt          time.Time `json:"lastUsedAt"`          // Last successful login (zero if never)
+	LastUsedIP          string    `json:"lastUsedIp"`          // Client IP of last successful login
+	LastUsedUserAgent   string    `json:"lastUsedUserAgent"`   // User-Agent of last successful login
+	UseCount            uint64    `json:"useCount"`            // Successful logins with this credential
+	Nickname            string    `json:"nickname,omitempty"`  // User-assigned name (empty to use a generated one)
+	SuspectAt           time.Time `json:"suspectAt"`           // First clone warning not cleared by the owner (zero if none)
+	Suspended           bool      `json:"suspended"`           // Refused for login until the owner reinstates it
+	LastSignCount       uint32    `json:"lastSignCount"`       // Sign count of the latest assertion, even one that raised a clone warning
+}
+
+// NewCredentialRecord wraps a freshly registered credential, recording when
+// and from which client it was created.
+func NewCredentialRecord(credential *webauthn.Credential, r *http.Request) CredentialRecord {
+	return CredentialRecord{
+		Credential:          *credential,
+		CreatedAt:           time.Now(),
+		RegisteredUserAgent: r.UserAgent(),
+	}
+}
+
+// clone returns a deep copy of the user that shares no memory with u.
+//
+// InMemoryStore hands out clones so that, as with SQLiteStore, editing a
+// looked-up user never changes the stored one behind the store's lock.
+func (u *User) clone() *User {
+	c := *u
+	c.ID = bytes.Clone(u.ID)
+	c.Credentials = make([]CredentialRecord, len(u.Credentials))
+	for i, cred := range u.Credentials {
+		c.Credentials[i] = cloneCredential(cred)
+	}
+	return &c
+}
+
+// cloneCredential deep-copies every byte slice of a credential record.
+func cloneCredential(cred CredentialRecord) CredentialRecord {
+	cred.ID = bytes.Clone(cred.ID)
+	cred.PublicKey = bytes.Clone(cred.PublicKey)
+	cred.Transport = slices.Clone(cred.Transport)
+	cred.Authenticator.AAGUID = bytes.Clone(cred.Authenticator.AAGUID)
+	cred.Attestation.ClientDataJSON = bytes.Clone(cred.Attestation.ClientDataJSON)
+	cred.Attestation.ClientDataHash = bytes.Clone(cred.Attestation.ClientDataHash)
+	cred.Attestation.AuthenticatorData = bytes.Clone(cred.Attestation.AuthenticatorData)
+	cred.Attestation.Object = bytes.Clone(cred.Attestation.Object)
+	return cred
+}
+
+// CredentialDescriptors returns descriptors for all of the user's credentials,
+// including their transports.
+//
+// Passed as excludeCredentials during registration so an authenticator that
+// already holds a passkey for this account refuses to create a second one
+// (the browser reports InvalidStateError instead).
+func (u User) CredentialDescriptors() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(u.Credentials))
+	for i, cred := range u.Credentials {
+		descriptors[i] = cred.Descriptor()
+	}
+	return descriptors
+}
+
+// credentialRecord returns the user's record for a credential ID, or nil.
+func (u *User) credentialRecord(id []byte) *CredentialRecord {
+	for i := range u.Credentials {
+		if string(u.Credentials[i].ID) == string(id) {
+			return &u.Credentials[i]
+		}
+	}
+	return nil
 }
 
 // Session represents a temporary WebAuthn session during multi-round authentication.
@@ -91,6 +191,10 @@ func (u User) WebAuthnCredentials() []webauthn.Credential {
 //
 // The Session stores the state between these two phases. This includes:
 //   - UserID: Which user initiated the session (nil for discoverable login)
+//   - Ceremony: Which begin handler created the session
+//   - PendingUser: Account being created (new registrations only)
+//   - StepUpFrom: Suspect credential being confirmed (step-up only)
+//   - Transaction: Payload being approved (transaction confirmation only)
 //   - SessionData: Challenge, user ID, and other verification data
 //   - CreatedAt: When the session was created (for expiration)
 //
@@ -99,9 +203,78 @@ func (u User) WebAuthnCredentials() []webauthn.Credential {
 //   - Session IDs should be cryptographically random
 //   - Sessions should be deleted after successful completion
 type Session struct {
-	UserID      []byte               `json:"userId"`      // User who initiated session (nil for discoverable)
-	SessionData webauthn.SessionData `json:"sessionData"` // WebAuthn challenge and verification data
-	CreatedAt   time.Time            `json:"createdAt"`   // Session creation time for expiration
+	UserID      []byte               `json:"userId"`                // User who initiated session (nil for discoverable)
+	Ceremony    string               `json:"ceremony"`              // Which flow created the session (see ceremony* constants)
+	PendingUser *User                `json:"pendingUser,omitempty"` // Account to create on finish (new registrations only)
+	StepUpFrom  []byte               `json:"stepUpFrom,omitempty"`  // Suspect credential that required the step-up
+	Transaction *PendingTransaction  `json:"transaction,omitempty"` // Payload the challenge was derived from (transactions only)
+	SessionData webauthn.SessionData `json:"sessionData"`           // WebAuthn challenge and verification data
+	CreatedAt   time.Time            `json:"createdAt"`             // Session creation time for expiration
+}
+
+// Ceremony types recorded on a Session.
+//
+// Finish handlers only accept sessions created by their matching begin
+// handler, so a login challenge can never be used to complete a
+// registration and vice versa.
+const (
+	ceremonyRegister    = "register"    // New account with its first passkey
+	ceremonyAddPasskey  = "add-passkey" // Additional passkey for a logged-in user
+	ceremonyLogin       = "login"       // Username or discoverable login
+	ceremonyStepUp      = "step-up"     // Second passkey confirming a login with a suspect one
+	ceremonyReauth      = "reauth"      // Fresh user verification for a logged-in user
+	ceremonyTransaction = "transaction" // Approval of one transaction payload
+)
+
+// LoginSession represents an authenticated user session after a successful
+// WebAuthn ceremony.
+//
+// The browser only ever holds an opaque random token in the user-session
+// cookie. The server stores a SHA-256 hash of that token as the ID, so a
+// leaked database cannot be replayed as cookies. Every request re-validates
+// the session against:
+//   - ExpiresAt: absolute lifetime, regardless of activity
+//   - LastSeenAt: idle timeout, refreshed on each authenticated request
+//
+// VerifiedAt records when the user last proved presence with user
+// verification, which gates sensitive operations independently of how long
+// the session itself has been alive.
+//
+// Logging out deletes the record, so the token stops working immediately
+// even if the cookie is still held by the client.
+type LoginSession struct {
+	ID           string    `json:"id"`           // Hex SHA-256 of the session token
+	UserID       []byte    `json:"userId"`       // WebAuthn user ID of the session owner
+	CreatedAt    time.Time `json:"createdAt"`    // When the user logged in
+	LastSeenAt   time.Time `json:"lastSeenAt"`   // Last authenticated request
+	ExpiresAt    time.Time `json:"expiresAt"`    // Absolute expiry
+	IP           string    `json:"ip"`           // Client IP at login
+	UserAgent    string    `json:"userAgent"`    // Client User-Agent at login
+	CredentialID []byte    `json:"credentialId"` // Credential used to log in
+	VerifiedAt   time.Time `json:"verifiedAt"`   // Last ceremony with user verification (zero if none)
+	Recovery     bool      `json:"recovery"`     // Signed in with a recovery code: only reaches recoverySessionPaths
+}
+
+// RecentlyVerified reports whether the user passed user verification (biometric
+// or PIN) within the given window. Sensitive operations such as adding a
+// passkey require this on top of a valid session.
+func (s *LoginSession) RecentlyVerified(now time.Time, window time.Duration) bool {
+	return !s.VerifiedAt.IsZero() && now.Sub(s.VerifiedAt) <= window
+}
+
+// FreshUntil returns when the session's user verification stops counting as
+// recent, or the zero time if the user was never verified.
+func (s *LoginSession) FreshUntil(window time.Duration) time.Time {
+	if s.VerifiedAt.IsZero() {
+		return time.Time{}
+	}
+	return s.VerifiedAt.Add(window)
+}
+
+// Expired reports whether the session is past its absolute lifetime or has
+// been idle for longer than loginSessionIdleTimeout.
+func (s *LoginSession) Expired(now time.Time) bool {
+	return now.After(s.ExpiresAt) || now.Sub(s.LastSeenAt) > loginSessionIdleTimeout
 }
 
 // PasskeyInfo represents credential information formatted for frontend display.
@@ -115,8 +288,10 @@ type Session struct {
 //   - AAGUID: Authenticator model identifier
 //
 // User Experience Information:
-//   - Name: Human-readable name for the credential
-//   - CreatedAt/LastUsed: Timestamps for user reference
+//   - Name: The user's nickname, or a generated name if none was set
+//   - Nickname: The raw nickname (empty if the user never named it)
+//   - CreatedAt/LastUsed: Real timestamps recorded by the server
+//   - UseCount/LastUsedUserAgent: Where and how often the passkey signs in
 //   - Transports: How the credential can be activated (USB, NFC, etc.)
 //
 // Sync and Backup Status:
@@ -126,18 +301,37 @@ type Session struct {
 //
 // This information helps users understand and manage their credentials.
 type PasskeyInfo struct {
-	ID                      string    `json:"id"`                      // Base64-encoded credential ID
-	Name                    string    `json:"name"`                    // Human-friendly credential name
-	CreatedAt               time.Time `json:"createdAt"`               // When credential was created
-	LastUsed                time.Time `json:"lastUsed"`                // Last authentication time
-	Transports              []string  `json:"transports"`              // Available transport methods
-	BackedUp                bool      `json:"backedUp"`                // Currently backed up to cloud
-	BackupEligible          bool      `json:"backupEligible"`          // Can be backed up
-	UserVerified            bool      `json:"userVerified"`            // Requires user verification
-	AttestationType         string    `json:"attestationType"`         // Type of attestation provided
-	AuthenticatorAttachment string    `json:"authenticatorAttachment"` // Platform or cross-platform
-	SignCount               uint32    `json:"signCount"`               // Usage counter for clone detection
-	AAGUID                  string    `json:"aaguid"`                  // Authenticator model ID (hex)
+	ID                      string     `json:"id"`                            // Base64url-encoded credential ID
+	Name                    string     `json:"name"`                          // Human-friendly credential name
+	Nickname                string     `json:"nickname,omitempty"`            // User-assigned name, if any
+	CreatedAt               time.Time  `json:"createdAt"`                     // When credential was created
+	LastUsed                *time.Time `json:"lastUsed,omitempty"`            // Last authentication time (omitted if never used)
+	UseCount                uint64     `json:"useCount"`                      // Successful logins with this credential
+	LastUsedIP              string     `json:"lastUsedIp,omitempty"`          // Client IP of last login
+	LastUsedUserAgent       string     `json:"lastUsedUserAgent,omitempty"`   // User-Agent of last login
+	RegisteredUserAgent     string     `json:"registeredUserAgent,omitempty"` // User-Agent that registered it
+	Transports              []string   `json:"transports"`                    // Available transport methods
+	BackedUp                bool       `json:"backedUp"`                      // Currently backed up to cloud
+	BackupEligible          bool       `json:"backupEligible"`                // Can be backed up
+	UserVerified            bool       `json:"userVerified"`                  // Requires user verification
+	AttestationType         string     `json:"attestationType"`               // Type of attestation provided
+	AuthenticatorAttachment string     `json:"authenticatorAttachment"`       // Platform or cross-platform
+	SignCount               uint32     `json:"signCount"`                     // Usage counter for clone detection
+	AAGUID                  string     `json:"aaguid"`                        // Authenticator model ID (hex)
+	// Authenticator model resolved from the AAGUID listing (see AAGUIDRegistry)
+	AuthenticatorName      string `json:"authenticatorName,omitempty"`      // e.g. "iCloud Keychain"
+	AuthenticatorIconLight string `json:"authenticatorIconLight,omitempty"` // Data URI for light backgrounds
+	AuthenticatorIconDark  string `json:"authenticatorIconDark,omitempty"`  // Data URI for dark backgrounds
+	// FIDO Metadata Service details (see MetadataService)
+	CertificationLevel string `json:"certificationLevel,omitempty"` // e.g. "FIDO_CERTIFIED_L1"
+	MetadataStatus     string `json:"metadataStatus,omitempty"`     // Most recent MDS status report
+	MetadataFlagged    bool   `json:"metadataFlagged,omitempty"`    // Revoked or known compromised
+	// Clone policy state (see App.checkCredentialStanding)
+	Suspect      bool       `json:"suspect,omitempty"`      // Sign counter went backwards and the owner has not reinstated it
+	SuspectSince *time.Time `json:"suspectSince,omitempty"` // First clone warning
+	Suspended    bool       `json:"suspended,omitempty"`    // Cannot sign in until reinstated
+	// Deleting the only passkey needs recovery codes or confirmation (see App.checkLastCredential)
+	LastCredential bool `json:"lastCredential"` // Only passkey on the account
 	// User information associated with this credential
 	Username    string `json:"username"`    // Owner's username
 	DisplayName string `json:"displayName"` // Owner's display name
@@ -145,8 +339,9 @@ type PasskeyInfo struct {
 
 // InMemoryStore provides thread-safe in-memory storage for the WebAuthn demo.
 //
-// This implementation is suitable for development and testing but should be
-// replaced with persistent storage (database) for production use.
+// This implementation is suitable for development and testing but loses all
+// users and passkeys on restart. Use SQLiteStore (-store=sqlite) when data
+// should survive a backend restart.
 //
 // Thread Safety:
 // All methods use read-write locks to ensure safe concurrent access.
@@ -157,6 +352,8 @@ type PasskeyInfo struct {
 //   - users: Username-based lookup for login and user management
 //   - userIDs: WebAuthn user ID-based lookup for discoverable login
 //   - sessions: Temporary session storage with automatic expiration
+//   - loginSessions: Authenticated user sessions keyed by token hash
+//   - auditEvents: Append-only security audit log
 //
 // Design Patterns Demonstrated:
 //   - Interface-based design for easy testing and database migration
@@ -164,10 +361,14 @@ type PasskeyInfo struct {
 //   - Concurrent safety with minimal lock contention
 //   - Automatic cleanup of expired resources
 type InMemoryStore struct {
-	users    map[string]*User    // username -> User (for traditional lookup)
-	userIDs  map[string]*User    // string(userID) -> User (for WebAuthn lookup)
-	sessions map[string]*Session // sessionID -> Session (temporary storage)
-	mu       sync.RWMutex        // Protects all maps for concurrent access
+	users         map[string]*User          // username -> User (for traditional lookup)
+	userIDs       map[string]*User          // string(userID) -> User (for WebAuthn lookup)
+	sessions      map[string]*Session       // sessionID -> Session (temporary storage)
+	loginSessions map[string]*LoginSession  // token hash -> LoginSession
+	auditEvents   []AuditEvent              // Audit log, oldest first
+	loginFailures map[string]*LoginFailures // lockout key -> failure run
+	recoveryCodes map[string][]string       // string(userID) -> unused recovery code hashes
+	mu            sync.RWMutex              // Protects all maps for concurrent access
 }
 
 // NewInMemoryStore creates a new in-memory store with initialized maps.
@@ -176,26 +377,45 @@ type InMemoryStore struct {
 // All maps are initialized to prevent nil pointer panics.
 //
 // Example usage:
-//   store := NewInMemoryStore()
-//   user, err := store.CreateUser("alice", "Alice Smith")
-//   if err != nil {
-//       log.Fatal(err)
-//   }
+//
+//	store := NewInMemoryStore()
+//	err := store.CreateUser(NewUser("alice", "Alice Smith"))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
 func NewInMemoryStore() *InMemoryStore {
 	return &InMemoryStore{
-		users:    make(map[string]*User),
-		userIDs:  make(map[string]*User),
-		sessions: make(map[string]*Session),
+		users:         make(map[string]*User),
+		userIDs:       make(map[string]*User),
+		sessions:      make(map[string]*Session),
+		loginSessions: make(map[string]*LoginSession),
+		loginFailures: make(map[string]*LoginFailures),
+		recoveryCodes: make(map[string][]string),
+	}
+}
+
+// NewUser builds a user with a fresh WebAuthn user ID and no credentials.
+//
+// The user is not stored. New accounts are only persisted once their first
+// passkey has been verified, so an abandoned registration never reserves a
+// username.
+func NewUser(username, displayName string) *User {
+	userID := uuid.New()
+	return &User{
+		ID:          userID[:],
+		Username:    username,
+		DisplayName: displayName,
+		Credentials: []CredentialRecord{},
+		CreatedAt:   time.Now(),
 	}
 }
 
-// CreateUser creates a new user with the given username and display name.
+// CreateUser stores a new user built with NewUser.
 //
 // This method:
 //  1. Checks if username is already taken
-//  2. Generates a new UUID for the WebAuthn user ID
-//  3. Creates the user with empty credentials list
-//  4. Stores the user in both username and userID indices
+//  2. Stores the user, including any credentials already attached
+//  3. Indexes the user by both username and userID
 //
 // The username must be unique across the system. The WebAuthn user ID
 // (UUID) ensures uniqueness even if usernames are reused after deletion.
@@ -203,27 +423,20 @@ func NewInMemoryStore() *InMemoryStore {
 // Returns ErrUserExists if the username is already taken.
 //
 // Thread-safe: Uses write lock for atomic user creation.
-func (s *InMemoryStore) CreateUser(username, displayName string) (*User, error) {
+func (s *InMemoryStore) CreateUser(user *User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.users[username]; exists {
-		return nil, ErrUserExists
+	if _, exists := s.users[user.Username]; exists {
+		return ErrUserExists
 	}
 
-	userID := uuid.New()
-	user := &User{
-		ID:          userID[:],
-		Username:    username,
-		DisplayName: displayName,
-		Credentials: []webauthn.Credential{},
-		CreatedAt:   time.Now(),
-	}
-
-	s.users[username] = user
-	s.userIDs[string(userID[:])] = user
+	// Keep our own copy so the caller's user stays theirs to modify
+	stored := user.clone()
+	s.users[user.Username] = stored
+	s.userIDs[string(user.ID)] = stored
 
-	return user, nil
+	return nil
 }
 
 // GetUser retrieves a user by username.
@@ -231,13 +444,19 @@ func (s *InMemoryStore) CreateUser(username, displayName string) (*User, error)
 // This is used for traditional username-based login flows and user management
 // operations. Returns the user and true if found, or nil and false if not found.
 //
+// The returned user is a copy, so callers may modify it freely; changes only
+// reach the store through UpdateUser and the credential methods.
+//
 // Thread-safe: Uses read lock for concurrent access.
 func (s *InMemoryStore) GetUser(username string) (*User, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	user, exists := s.users[username]
-	return user, exists
+	if !exists {
+		return nil, false
+	}
+	return user.clone(), true
 }
 
 // GetUserByID retrieves a user by their WebAuthn user ID.
@@ -247,26 +466,86 @@ func (s *InMemoryStore) GetUser(username string) (*User, bool) {
 //
 // The userID parameter should be the exact bytes returned by user.WebAuthnID().
 //
+// Like GetUser, the returned user is a copy.
+//
 // Thread-safe: Uses read lock for concurrent access.
 func (s *InMemoryStore) GetUserByID(userID []byte) (*User, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	user, exists := s.userIDs[string(userID)]
-	return user, exists
+	if !exists {
+		return nil, false
+	}
+	return user.clone(), true
 }
 
-// UpdateUser updates user credentials
-func (s *InMemoryStore) UpdateUser(user *User) {
+// UpdateUser saves the user's profile fields.
+//
+// Credentials are left untouched: they change one at a time through
+// AddCredential, UpdateCredential and DeleteUserPasskey, so saving a stale
+// copy of the user cannot undo a concurrent credential change.
+func (s *InMemoryStore) UpdateUser(user *User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.users[user.Username] = user
-	s.userIDs[string(user.ID)] = user
+	stored, exists := s.userIDs[string(user.ID)]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	stored.DisplayName = user.DisplayName
+	return nil
 }
 
-// DeleteUserPasskey removes a specific credential from user
-func (s *InMemoryStore) DeleteUserPasskey(username string, credentialID []byte) error {
+// AddCredential appends a newly registered credential to the user's list,
+// returning ErrCredentialExists if the user already has that credential ID.
+func (s *InMemoryStore) AddCredential(userID []byte, credential CredentialRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.userIDs[string(userID)]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	for _, cred := range user.Credentials {
+		if string(cred.ID) == string(credential.ID) {
+			return ErrCredentialExists
+		}
+	}
+
+	user.Credentials = append(user.Credentials, cloneCredential(credential))
+	return nil
+}
+
+// UpdateCredential applies update to one stored credential under the store
+// lock, returning ErrCredentialNotFound if it has been deleted meanwhile.
+func (s *InMemoryStore) UpdateCredential(userID, credentialID []byte, update func(*CredentialRecord)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.userIDs[string(userID)]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	for i := range user.Credentials {
+		if string(user.Credentials[i].ID) == string(credentialID) {
+			cred := cloneCredential(user.Credentials[i])
+			update(&cred)
+			user.Credentials[i] = cloneCredential(cred)
+			return nil
+		}
+	}
+
+	return ErrCredentialNotFound
+}
+
+// DeleteUserPasskey removes a specific credential from user. Removing the
+// user's only credential fails with ErrLastCredential unless allowLast is
+// set; the check happens under the same lock as the removal.
+func (s *InMemoryStore) DeleteUserPasskey(username string, credentialID []byte, allowLast bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -278,6 +557,9 @@ func (s *InMemoryStore) DeleteUserPasskey(username string, credentialID []byte)
 	// Find and remove the credential
 	for i, cred := range user.Credentials {
 		if string(cred.ID) == string(credentialID) {
+			if len(user.Credentials) == 1 && !allowLast {
+				return ErrLastCredential
+			}
 			// Remove credential from slice
 			user.Credentials = append(user.Credentials[:i], user.Credentials[i+1:]...)
 			s.users[username] = user
@@ -291,8 +573,8 @@ func (s *InMemoryStore) DeleteUserPasskey(username string, credentialID []byte)
 
 // GetUserPasskeys returns passkey info for frontend
 func (s *InMemoryStore) GetUserPasskeys(username string) ([]PasskeyInfo, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	user, exists := s.users[username]
 	if !exists {
@@ -302,16 +584,25 @@ func (s *InMemoryStore) GetUserPasskeys(username string) ([]PasskeyInfo, error)
 	// Remove duplicates from user credentials first
 	uniqueCredentials := removeDuplicateCredentials(user.Credentials)
 	if len(uniqueCredentials) != len(user.Credentials) {
-		fmt.Printf("INFO: Removed %d duplicate credentials for user %s\n", 
-			len(user.Credentials)-len(uniqueCredentials), user.Username)
+		logger.Info("Removed duplicate credentials",
+			"username", user.Username,
+			"count", len(user.Credentials)-len(uniqueCredentials))
 		// Update user with cleaned credentials
 		user.Credentials = uniqueCredentials
 		s.users[user.Username] = user
 		s.userIDs[string(user.ID)] = user
 	}
 
-	passkeys := make([]PasskeyInfo, len(uniqueCredentials))
-	for i, cred := range uniqueCredentials {
+	return newPasskeyInfos(user), nil
+}
+
+// newPasskeyInfos converts a user's credentials into PasskeyInfo records.
+//
+// Shared by every Store implementation so the management screens render the
+// same data regardless of which backend is configured.
+func newPasskeyInfos(user *User) []PasskeyInfo {
+	passkeys := make([]PasskeyInfo, len(user.Credentials))
+	for i, cred := range user.Credentials {
 		// Convert transport enums to strings
 		transports := make([]string, len(cred.Transport))
 		for j, transport := range cred.Transport {
@@ -324,18 +615,40 @@ func (s *InMemoryStore) GetUserPasskeys(username string) ([]PasskeyInfo, error)
 			aaguidStr = fmt.Sprintf("%x", cred.Authenticator.AAGUID)
 		}
 
-		// Use individual credential creation time if available, fallback to user creation
-		credCreatedAt := user.CreatedAt
-		if cred.Authenticator.SignCount == 0 {
-			// For demo: use user creation time + small offset for each credential
-			credCreatedAt = user.CreatedAt.Add(time.Duration(i) * time.Minute)
+		// Credentials stored before creation times were recorded fall back
+		// to the account creation time
+		credCreatedAt := cred.CreatedAt
+		if credCreatedAt.IsZero() {
+			credCreatedAt = user.CreatedAt
+		}
+
+		var lastUsed *time.Time
+		if !cred.LastUsedAt.IsZero() {
+			lastUsedAt := cred.LastUsedAt
+			lastUsed = &lastUsedAt
+		}
+
+		name := cred.Nickname
+		if name == "" {
+			name = generatePasskeyName(cred.Credential)
+		}
+
+		var suspectSince *time.Time
+		if !cred.SuspectAt.IsZero() {
+			suspectAt := cred.SuspectAt
+			suspectSince = &suspectAt
 		}
 
 		passkeys[i] = PasskeyInfo{
-			ID:                      string(cred.ID),
-			Name:                    generatePasskeyName(cred),
+			ID:                      encodeCredentialID(cred.ID),
+			Name:                    name,
+			Nickname:                cred.Nickname,
 			CreatedAt:               credCreatedAt,
-			LastUsed:                time.Now().Add(-time.Duration(i)*time.Hour), // Simulate different last used times
+			LastUsed:                lastUsed,
+			UseCount:                cred.UseCount,
+			LastUsedIP:              cred.LastUsedIP,
+			LastUsedUserAgent:       cred.LastUsedUserAgent,
+			RegisteredUserAgent:     cred.RegisteredUserAgent,
 			Transports:              transports,
 			BackedUp:                cred.Flags.BackupState,
 			BackupEligible:          cred.Flags.BackupEligible,
@@ -344,29 +657,32 @@ func (s *InMemoryStore) GetUserPasskeys(username string) ([]PasskeyInfo, error)
 			AuthenticatorAttachment: string(cred.Authenticator.Attachment),
 			SignCount:               cred.Authenticator.SignCount,
 			AAGUID:                  aaguidStr,
+			Suspect:                 suspectSince != nil,
+			SuspectSince:            suspectSince,
+			Suspended:               cred.Suspended,
+			LastCredential:          len(user.Credentials) == 1,
 			Username:                user.Username,
 			DisplayName:             user.DisplayName,
 		}
 	}
 
-	return passkeys, nil
+	return passkeys
 }
 
 // Session management
-func (s *InMemoryStore) StoreSession(sessionID string, userID []byte, sessionData webauthn.SessionData) {
+func (s *InMemoryStore) StoreSession(sessionID string, session *Session) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.sessions[sessionID] = &Session{
-		UserID:      userID,
-		SessionData: sessionData,
-		CreatedAt:   time.Now(),
-	}
+	stored := *session
+	stored.CreatedAt = time.Now()
+	s.sessions[sessionID] = &stored
+	return nil
 }
 
 func (s *InMemoryStore) GetSession(sessionID string) (*Session, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	session, exists := s.sessions[sessionID]
 	if !exists {
@@ -374,7 +690,7 @@ func (s *InMemoryStore) GetSession(sessionID string) (*Session, bool) {
 	}
 
 	// Check if session is expired (5 minutes for demo)
-	if time.Since(session.CreatedAt) > 5*time.Minute {
+	if time.Since(session.CreatedAt) > sessionTTL {
 		delete(s.sessions, sessionID)
 		return nil, false
 	}
@@ -389,6 +705,84 @@ func (s *InMemoryStore) DeleteSession(sessionID string) {
 	delete(s.sessions, sessionID)
 }
 
+// RenameUserPasskey sets the nickname of one of the user's credentials.
+// An empty nickname reverts to the generated name.
+func (s *InMemoryStore) RenameUserPasskey(username string, credentialID []byte, nickname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	for i := range user.Credentials {
+		if string(user.Credentials[i].ID) == string(credentialID) {
+			user.Credentials[i].Nickname = nickname
+			return nil
+		}
+	}
+
+	return ErrCredentialNotFound
+}
+
+// Login session management
+func (s *InMemoryStore) CreateLoginSession(session *LoginSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.loginSessions[session.ID] = &stored
+	return nil
+}
+
+func (s *InMemoryStore) GetLoginSession(id string) (*LoginSession, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.loginSessions[id]
+	if !exists {
+		return nil, false
+	}
+
+	// Return a copy so callers cannot mutate the stored session
+	copied := *session
+	return &copied, true
+}
+
+func (s *InMemoryStore) TouchLoginSession(id string, lastSeen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.loginSessions[id]
+	if !exists {
+		return ErrInvalidSession
+	}
+	session.LastSeenAt = lastSeen
+	return nil
+}
+
+func (s *InMemoryStore) VerifyLoginSession(id string, credentialID []byte, verifiedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.loginSessions[id]
+	if !exists {
+		return ErrInvalidSession
+	}
+	session.CredentialID = credentialID
+	session.VerifiedAt = verifiedAt
+	return nil
+}
+
+func (s *InMemoryStore) DeleteLoginSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginSessions, id)
+	return nil
+}
+
 // CleanupExpiredSessions removes old sessions (would run periodically in production)
 func (s *InMemoryStore) CleanupExpiredSessions() {
 	s.mu.Lock()
@@ -396,17 +790,156 @@ func (s *InMemoryStore) CleanupExpiredSessions() {
 
 	now := time.Now()
 	for sessionID, session := range s.sessions {
-		if now.Sub(session.CreatedAt) > 5*time.Minute {
+		if now.Sub(session.CreatedAt) > sessionTTL {
 			delete(s.sessions, sessionID)
 		}
 	}
+	for id, session := range s.loginSessions {
+		if session.Expired(now) {
+			delete(s.loginSessions, id)
+		}
+	}
+}
+
+// SessionCounts returns the number of unexpired ceremony and login sessions.
+func (s *InMemoryStore) SessionCounts() (ceremony, login int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, session := range s.sessions {
+		if now.Sub(session.CreatedAt) <= sessionTTL {
+			ceremony++
+		}
+	}
+	for _, session := range s.loginSessions {
+		if !session.Expired(now) {
+			login++
+		}
+	}
+	return ceremony, login, nil
+}
+
+// AppendAuditEvent adds an event to the end of the audit log.
+func (s *InMemoryStore) AppendAuditEvent(event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prevID int64
+	var prevHash string
+	if n := len(s.auditEvents); n > 0 {
+		prevID, prevHash = s.auditEvents[n-1].ID, s.auditEvents[n-1].Hash
+	}
+	event.seal(prevID, prevHash)
+	s.auditEvents = append(s.auditEvents, *event)
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching filter, newest first.
+func (s *InMemoryStore) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []AuditEvent{}
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+		if filter.matches(&s.auditEvents[i]) {
+			events = append(events, s.auditEvents[i])
+		}
+	}
+	return events, nil
+}
+
+// RecordLoginFailure counts a failed assertion for key.
+func (s *InMemoryStore) RecordLoginFailure(key string, at, resetBefore time.Time) (*LoginFailures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures, exists := s.loginFailures[key]
+	if !exists || failures.LastAt.Before(resetBefore) {
+		failures = &LoginFailures{Key: key}
+		s.loginFailures[key] = failures
+	}
+	failures.Count++
+	failures.LastAt = at
+
+	copied := *failures
+	return &copied, nil
+}
+
+// GetLoginFailures returns the failure run for key.
+func (s *InMemoryStore) GetLoginFailures(key string) (*LoginFailures, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failures, exists := s.loginFailures[key]
+	if !exists {
+		return nil, false
+	}
+	copied := *failures
+	return &copied, true
+}
+
+// ClearLoginFailures forgets the failure runs for keys.
+func (s *InMemoryStore) ClearLoginFailures(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.loginFailures, key)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery code hashes for a new set.
+func (s *InMemoryStore) ReplaceRecoveryCodes(userID []byte, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recoveryCodes[string(userID)] = append([]string(nil), hashes...)
+	return nil
+}
+
+// GetRecoveryCodes returns the user's unused recovery code hashes.
+func (s *InMemoryStore) GetRecoveryCodes(userID []byte) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.recoveryCodes[string(userID)]...), nil
+}
+
+// UseRecoveryCode removes one recovery code hash.
+func (s *InMemoryStore) UseRecoveryCode(userID []byte, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := s.recoveryCodes[string(userID)]
+	for i, stored := range hashes {
+		if stored == hash {
+			s.recoveryCodes[string(userID)] = append(hashes[:i:i], hashes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrInvalidRecoveryCode
+}
+
+// Ping always succeeds; the in-memory store cannot become unreachable.
+func (s *InMemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op for the in-memory store; there is nothing to flush.
+func (s *InMemoryStore) Close() error {
+	return nil
 }
 
 // removeDuplicateCredentials removes duplicate credentials based on credential ID
-func removeDuplicateCredentials(credentials []webauthn.Credential) []webauthn.Credential {
+func removeDuplicateCredentials(credentials []CredentialRecord) []CredentialRecord {
 	seen := make(map[string]bool)
-	var unique []webauthn.Credential
-	
+	var unique []CredentialRecord
+
 	for _, cred := range credentials {
 		credID := string(cred.ID)
 		if !seen[credID] {
@@ -414,13 +947,13 @@ func removeDuplicateCredentials(credentials []webauthn.Credential) []webauthn.Cr
 			unique = append(unique, cred)
 		}
 	}
-	
+
 	return unique
 }
 
 // generatePasskeyName creates a human-friendly name for a WebAuthn credential.
 //
-// This function analyzes the credential's properties to generate descriptive names
+// Used when the user has not given the passkey a nickname. This function analyzes the credential's properties to generate descriptive names
 // that help users identify their passkeys in management interfaces.
 //
 // Naming Strategy:
@@ -429,17 +962,15 @@ func removeDuplicateCredentials(credentials []webauthn.Credential) []webauthn.Cr
 //  3. Backup state consideration: "Synced" for cloud-backed credentials
 //  4. Fallback: Generic "Security Key" for unknown types
 //
+// When an AAGUID listing is configured, AAGUIDRegistry.Annotate replaces this
+// name with the authenticator model (e.g. "Google Password Manager").
+//
 // In production applications, consider:
-//  - AAGUID-based device detection for specific device names
-//  - User-defined custom names
-//  - Localization for international users
-//  - Device type detection (iPhone, Android, Windows, etc.)
+//   - Localization for international users
+//   - Device type detection (iPhone, Android, Windows, etc.)
 //
 // Returns a user-friendly string describing the credential type and capabilities.
 func generatePasskeyName(cred webauthn.Credential) string {
-	// In a real app, you might detect device type based on AAGUID
-	// or let users name their passkeys
-	
 	// Consider attachment type first
 	attachment := string(cred.Authenticator.Attachment)
 	if attachment == "platform" {
@@ -448,7 +979,7 @@ func generatePasskeyName(cred webauthn.Credential) string {
 		}
 		return "Platform Passkey"
 	}
-	
+
 	// For cross-platform authenticators, use transport info
 	if len(cred.Transport) > 0 {
 		switch cred.Transport[0] {
@@ -467,7 +998,7 @@ func generatePasskeyName(cred webauthn.Credential) string {
 			return "Phone/Tablet Passkey"
 		}
 	}
-	
+
 	// Fallback based on backup state
 	if cred.Flags.BackupEligible {
 		if cred.Flags.BackupState {
@@ -475,7 +1006,7 @@ func generatePasskeyName(cred webauthn.Credential) string {
 		}
 		return "Backup-Eligible Passkey"
 	}
-	
+
 	return "Security Key"
 }
 
@@ -485,28 +1016,43 @@ func generatePasskeyName(cred webauthn.Credential) string {
 // for proper error handling in client applications.
 //
 // Error Design Pattern:
-//  - Code: Machine-readable identifier for programmatic handling
-//  - Message: Human-readable description for logging and debugging
-//  - JSON serializable for consistent API error responses
+//   - Code: Machine-readable identifier for programmatic handling
+//   - Message: Human-readable description for logging and debugging
+//   - JSON serializable for consistent API error responses
 //
 // Usage:
-//   if err == ErrUserExists {
-//       return http.StatusConflict
-//   }
+//
+//	if err == ErrUserExists {
+//	    return http.StatusConflict
+//	}
 var (
-	ErrUserExists         = &AppError{Code: "USER_EXISTS", Message: "User already exists"}
-	ErrUserNotFound       = &AppError{Code: "USER_NOT_FOUND", Message: "User not found"}
-	ErrCredentialNotFound = &AppError{Code: "CREDENTIAL_NOT_FOUND", Message: "Credential not found"}
-	ErrInvalidSession     = &AppError{Code: "INVALID_SESSION", Message: "Invalid or expired session"}
+	ErrUserExists             = &AppError{Code: "USER_EXISTS", Message: "User already exists"}
+	ErrUserNotFound           = &AppError{Code: "USER_NOT_FOUND", Message: "User not found"}
+	ErrCredentialNotFound     = &AppError{Code: "CREDENTIAL_NOT_FOUND", Message: "Credential not found"}
+	ErrInvalidSession         = &AppError{Code: "INVALID_SESSION", Message: "Invalid or expired session"}
+	ErrAuthRequired           = &AppError{Code: "AUTH_REQUIRED", Message: "Authentication required"}
+	ErrReauthRequired         = &AppError{Code: "REAUTH_REQUIRED", Message: "Recent user verification required"}
+	ErrCredentialExists       = &AppError{Code: "CREDENTIAL_EXISTS", Message: "This authenticator already has a passkey for this account"}
+	ErrCeremonyAborted        = &AppError{Code: "CEREMONY_ABORTED", Message: "The authenticator did not complete the request"}
+	ErrUntrustedAuthenticator = &AppError{Code: "UNTRUSTED_AUTHENTICATOR", Message: "This authenticator is not trusted by the server"}
+	ErrAdminRequired          = &AppError{Code: "ADMIN_REQUIRED", Message: "Admin token required"}
+	ErrStepUpRequired         = &AppError{Code: "STEP_UP_REQUIRED", Message: "Confirm this sign-in with a different passkey"}
+	ErrCredentialSuspended    = &AppError{Code: "CREDENTIAL_SUSPENDED", Message: "This passkey is suspended because it may have been cloned"}
+	ErrDifferentCredential    = &AppError{Code: "DIFFERENT_CREDENTIAL_REQUIRED", Message: "Sign in with a different passkey to reinstate this one"}
+	ErrCredentialSuspect      = &AppError{Code: "CREDENTIAL_SUSPECT", Message: "This passkey may have been cloned; use a different passkey"}
+	ErrRateLimited            = &AppError{Code: "RATE_LIMITED", Message: "Too many requests, try again later"}
+	ErrInvalidRecoveryCode    = &AppError{Code: "INVALID_RECOVERY_CODE", Message: "Invalid or already used recovery code"}
+	ErrLastCredential         = &AppError{Code: "LAST_CREDENTIAL", Message: "This is the only passkey on the account; generate recovery codes or confirm the deletion"}
+	ErrAccountLocked          = &AppError{Code: "ACCOUNT_LOCKED", Message: "Too many failed sign-in attempts, try again later or use a different passkey"}
 )
 
 // AppError represents a structured application error with both code and message.
 //
 // This design allows for:
-//  - Consistent error responses across the API
-//  - Client-side error handling based on error codes
-//  - Human-readable messages for debugging
-//  - Easy localization by translating based on codes
+//   - Consistent error responses across the API
+//   - Client-side error handling based on error codes
+//   - Human-readable messages for debugging
+//   - Easy localization by translating based on codes
 //
 // Implements the standard error interface for compatibility with Go error handling.
 type AppError struct {
@@ -520,4 +1066,4 @@ type AppError struct {
 // while preserving the additional structure for API responses.
 func (e *AppError) Error() string {
 	return e.Message
-}
\ No newline at end of file
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Policy actions.
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+)
+
+// defaultPolicyCode is returned for denied credentials when the matching rule
+// does not define its own code.
+const defaultPolicyCode = "AUTHENTICATOR_NOT_ALLOWED"
+
+// AuthenticatorPolicy decides which newly registered credentials are accepted.
+//
+// It is loaded from a JSON file and evaluated by the registration finish
+// handlers once the credential has been verified, before anything is stored.
+// Rules are checked in order and the first match decides; if nothing matches
+// the Default action applies (allow when unset).
+//
+// Example: only hardware security keys from two vendors, never synced keys.
+//
+//	{
+//	  "default": "deny",
+//	  "attachment": "cross-platform",
+//	  "rules": [
+//	    {
+//	      "name": "no-synced-passkeys",
+//	      "action": "deny",
+//	      "code": "SYNCED_PASSKEY_NOT_ALLOWED",
+//	      "message": "Synced passkeys are not allowed; use a security key",
+//	      "match": {"backupEligible": true}
+//	    },
+//	    {
+//	      "name": "approved-keys",
+//	      "action": "allow",
+//	      "match": {
+//	        "aaguids": ["cb69481e-8ff7-4039-93ec-0a2729a154a8", "ee882879-721c-4913-9775-3dfcce97072a"],
+//	        "attachments": ["cross-platform"],
+//	        "userVerified": true
+//	      }
+//	    }
+//	  ]
+//	}
+//
+// Attachment only shapes the options sent to the browser so that allowed
+// authenticators can be offered at all; the rules are what enforce it.
+//
+// A nil *AuthenticatorPolicy allows everything.
+type AuthenticatorPolicy struct {
+	Default    string       `json:"default"`    // "allow" (default) or "deny"
+	Code       string       `json:"code"`       // Error code when the default action denies
+	Message    string       `json:"message"`    // Error message when the default action denies
+	Attachment string       `json:"attachment"` // Requested at begin: "platform" (default), "cross-platform" or "any"
+	Rules      []PolicyRule `json:"rules"`      // Evaluated in order, first match wins
+}
+
+// PolicyRule allows or denies credentials matching all of its conditions.
+type PolicyRule struct {
+	Name    string      `json:"name"`    // Identifies the rule in logs
+	Action  string      `json:"action"`  // "allow" or "deny"
+	Code    string      `json:"code"`    // Error code returned when denying
+	Message string      `json:"message"` // Error message returned when denying
+	Match   PolicyMatch `json:"match"`   // Conditions; all must hold
+}
+
+// PolicyMatch lists the credential properties a rule applies to.
+//
+// Empty lists and null booleans match anything, so a rule with an empty
+// match applies to every credential.
+type PolicyMatch struct {
+	AAGUIDs          []string `json:"aaguids"`          // Authenticator models, dashed or hex
+	Attachments      []string `json:"attachments"`      // "platform" or "cross-platform"
+	AttestationTypes []string `json:"attestationTypes"` // Attestation formats, e.g. "none", "packed", "apple"
+	BackupEligible   *bool    `json:"backupEligible"`   // BE flag: credential may be synced
+	BackedUp         *bool    `json:"backedUp"`         // BS flag: credential is currently synced
+	UserVerified     *bool    `json:"userVerified"`     // UV flag: biometric or PIN was used
+}
+
+// NewAuthenticatorPolicy loads and validates the policy file at path.
+func NewAuthenticatorPolicy(path string) (*AuthenticatorPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+
+	var policy AuthenticatorPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+// validate checks actions and normalizes AAGUIDs so matching is a plain
+// string comparison.
+func (p *AuthenticatorPolicy) validate() error {
+	if p.Default == "" {
+		p.Default = policyAllow
+	}
+	if p.Default != policyAllow && p.Default != policyDeny {
+		return fmt.Errorf("default must be %q or %q, got %q", policyAllow, policyDeny, p.Default)
+	}
+
+	switch p.Attachment {
+	case "", "platform", "cross-platform", "any":
+	default:
+		return fmt.Errorf("attachment must be platform, cross-platform or any, got %q", p.Attachment)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Action != policyAllow && rule.Action != policyDeny {
+			return fmt.Errorf("%s: action must be %q or %q, got %q", rule.Name, policyAllow, policyDeny, rule.Action)
+		}
+
+		for j, aaguid := range rule.Match.AAGUIDs {
+			normalized := normalizeAAGUID(aaguid)
+			if len(normalized) != 32 {
+				return fmt.Errorf("%s: invalid AAGUID %q", rule.Name, aaguid)
+			}
+			rule.Match.AAGUIDs[j] = normalized
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of rules.
+func (p *AuthenticatorPolicy) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.Rules)
+}
+
+// RequestedAttachment returns the authenticator attachment to request when
+// registration begins. Without a policy, platform authenticators are required.
+func (p *AuthenticatorPolicy) RequestedAttachment() protocol.AuthenticatorAttachment {
+	if p == nil || p.Attachment == "" {
+		return protocol.Platform
+	}
+	if p.Attachment == "any" {
+		return ""
+	}
+	return protocol.AuthenticatorAttachment(p.Attachment)
+}
+
+// Evaluate checks a verified credential against the policy.
+//
+// Returns nil if the credential is allowed, or an AppError carrying the
+// denying rule's code and message.
+func (p *AuthenticatorPolicy) Evaluate(credential *webauthn.Credential) *AppError {
+	if p == nil {
+		return nil
+	}
+
+	for _, rule := range p.Rules {
+		if !rule.Match.matches(credential) {
+			continue
+		}
+		if rule.Action == policyAllow {
+			return nil
+		}
+		return policyError(rule.Name, rule.Code, rule.Message)
+	}
+
+	if p.Default == policyAllow {
+		return nil
+	}
+	return policyError("default", p.Code, p.Message)
+}
+
+// matches reports whether the credential satisfies every set condition.
+func (m PolicyMatch) matches(credential *webauthn.Credential) bool {
+	if len(m.AAGUIDs) > 0 && !containsFold(m.AAGUIDs, fmt.Sprintf("%x", credential.Authenticator.AAGUID)) {
+		return false
+	}
+	if len(m.Attachments) > 0 && !containsFold(m.Attachments, string(credential.Authenticator.Attachment)) {
+		return false
+	}
+	if len(m.AttestationTypes) > 0 && !containsFold(m.AttestationTypes, credential.AttestationType) {
+		return false
+	}
+	if m.BackupEligible != nil && *m.BackupEligible != credential.Flags.BackupEligible {
+		return false
+	}
+	if m.BackedUp != nil && *m.BackedUp != credential.Flags.BackupState {
+		return false
+	}
+	if m.UserVerified != nil && *m.UserVerified != credential.Flags.UserVerified {
+		return false
+	}
+	return true
+}
+
+// policyError builds the AppError for a denied credential.
+func policyError(rule, code, message string) *AppError {
+	if code == "" {
+		code = defaultPolicyCode
+	}
+	if message == "" {
+		message = fmt.Sprintf("This authenticator is not allowed by policy (%s)", rule)
+	}
+	return &AppError{Code: code, Message: message}
+}
+
+// containsFold reports whether list contains value, ignoring case.
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
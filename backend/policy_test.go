@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const testPolicy = `{
+  "default": "deny",
+  "attachment": "cross-platform",
+  "rules": [
+    {
+      "name": "no-synced-passkeys",
+      "action": "deny",
+      "code": "SYNCED_PASSKEY_NOT_ALLOWED",
+      "message": "Synced passkeys are not allowed; use a security key",
+      "match": {"backupEligible": true}
+    },
+    {
+      "name": "approved-keys",
+      "action": "allow",
+      "match": {
+        "aaguids": ["CB69481E-8FF7-4039-93EC-0A2729A154A8"],
+        "attachments": ["cross-platform"],
+        "userVerified": true
+      }
+    }
+  ]
+}`
+
+func writePolicy(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthenticatorPolicyEvaluate(t *testing.T) {
+	policy, err := NewAuthenticatorPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("NewAuthenticatorPolicy: %v", err)
+	}
+	if policy.RequestedAttachment() != protocol.CrossPlatform || policy.Len() != 2 {
+		t.Errorf("attachment %q, %d rules", policy.RequestedAttachment(), policy.Len())
+	}
+
+	approved := uuid.MustParse("cb69481e-8ff7-4039-93ec-0a2729a154a8")
+	credential := func(aaguid uuid.UUID, attachment protocol.AuthenticatorAttachment, backupEligible bool) *webauthn.Credential {
+		return &webauthn.Credential{
+			Flags:         webauthn.CredentialFlags{UserVerified: true, BackupEligible: backupEligible},
+			Authenticator: webauthn.Authenticator{AAGUID: aaguid[:], Attachment: attachment},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		credential *webauthn.Credential
+		wantCode   string // empty when allowed
+	}{
+		{"approved key", credential(approved, protocol.CrossPlatform, false), ""},
+		{"synced passkey", credential(approved, protocol.CrossPlatform, true), "SYNCED_PASSKEY_NOT_ALLOWED"},
+		{"wrong attachment", credential(approved, protocol.Platform, false), defaultPolicyCode},
+		{"unknown model", credential(uuid.New(), protocol.CrossPlatform, false), defaultPolicyCode},
+	}
+	for _, tc := range tests {
+		appErr := policy.Evaluate(tc.credential)
+		switch {
+		case tc.wantCode == "" && appErr != nil:
+			t.Errorf("%s: denied with %s", tc.name, appErr.Code)
+		case tc.wantCode != "" && (appErr == nil || appErr.Code != tc.wantCode):
+			t.Errorf("%s: got %v, want %s", tc.name, appErr, tc.wantCode)
+		}
+	}
+
+	var none *AuthenticatorPolicy
+	if none.Evaluate(credential(uuid.New(), protocol.Platform, true)) != nil || none.RequestedAttachment() != protocol.Platform {
+		t.Error("nil policy is not allow-all with platform attachment")
+	}
+}
+
+func TestAuthenticatorPolicyInvalid(t *testing.T) {
+	for name, contents := range map[string]string{
+		"default":    `{"default": "maybe"}`,
+		"attachment": `{"attachment": "usb"}`,
+		"action":     `{"rules": [{"action": "block"}]}`,
+		"aaguid":     `{"rules": [{"action": "deny", "match": {"aaguids": ["not-an-aaguid"]}}]}`,
+		"json":       `{`,
+	} {
+		if _, err := NewAuthenticatorPolicy(writePolicy(t, contents)); err == nil {
+			t.Errorf("%s: invalid policy accepted", name)
+		}
+	}
+}
+
+func TestAddPasskeyFinishPolicyDenied(t *testing.T) {
+	policy, err := NewAuthenticatorPolicy(writePolicy(t, `{"rules": [
+		{"name": "attested-only", "action": "deny", "code": "ATTESTATION_REQUIRED", "match": {"attestationTypes": ["none"]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t)
+	app.policy = policy
+	user := createTestUser(t, app.store, "alice", "cred-1")
+	authenticator := newTestAuthenticator(t)
+
+	rec := addPasskeyFinish(t, app, user, func(challenge string) []byte { return authenticator.register(t, challenge) })
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if resp := decodeError(t, rec); resp.Code != "ATTESTATION_REQUIRED" {
+		t.Errorf("error code %q", resp.Code)
+	}
+	if stored, _ := app.store.GetUser("alice"); len(stored.Credentials) != 1 {
+		t.Errorf("%d credentials stored, want the denied one left out", len(stored.Credentials))
+	}
+}
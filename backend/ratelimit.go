@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (l RateLimit) unlimited() bool {
+	return l.Requests == 0
+}
+
+// rate returns the refill rate in tokens per second.
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// capacity returns the bucket size.
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// tokenBucket holds the tokens left for one key.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+// refill adds the tokens earned since the last update.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.capacity(), b.tokens+now.Sub(b.updated).Seconds()*b.limit.rate())
+	b.updated = now
+}
+
+// rateLimiter keeps one token bucket per route and client IP or username.
+//
+// Buckets live in memory, so limits are per process and reset on restart.
+// Buckets that have refilled completely are dropped, which keeps memory
+// bounded by the number of recently active clients.
+type rateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+// rateLimitPruneInterval is how often full buckets are dropped.
+const rateLimitPruneInterval = time.Minute
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}, lastPruned: time.Now()}
+}
+
+// take spends one token from the bucket for key. When the bucket is empty,
+// ok is false and retryAfter says when the next token arrives.
+func (l *rateLimiter) take(key string, limit RateLimit, now time.Time) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPruned) >= rateLimitPruneInterval {
+		l.prune(now)
+	}
+
+	bucket, exists := l.buckets[key]
+	if !exists || bucket.limit != limit {
+		bucket = &tokenBucket{tokens: limit.capacity(), updated: now, limit: limit}
+		l.buckets[key] = bucket
+	}
+	bucket.refill(now)
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / limit.rate()
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// refund returns a token taken from the bucket for key, for attempts that
+// should only count when they fail.
+func (l *rateLimiter) refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, exists := l.buckets[key]; exists {
+		bucket.tokens = math.Min(bucket.limit.capacity(), bucket.tokens+1)
+	}
+}
+
+// rateLimitKey names the bucket of one route, scope and value.
+func rateLimitKey(route, scope, value string) string {
+	return route + "|" + scope + "|" + value
+}
+
+// prune drops buckets that are full again. Callers hold l.mu.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.limit.capacity() {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPruned = now
+}
+
+// rateLimitMiddleware applies the per-IP budget of the request's route, or
+// rateLimit.default for routes without one. Per-user budgets need the
+// username from the request body or session, so handlers check them with
+// allowUser.
+func (app *App) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.config.RateLimit
+		if !cfg.Enabled || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := r.URL.Path
+		limit, ok := cfg.Routes[route]
+		if !ok {
+			route, limit.IP = "default", cfg.Default
+		}
+		if !app.allow(w, r, route, "ip", clientIP(r), limit.IP) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowUser applies the per-user budget of the request's route to username.
+// It writes 429 RATE_LIMITED and returns false when the budget is spent.
+func (app *App) allowUser(w http.ResponseWriter, r *http.Request, username string) bool {
+	cfg := app.config.RateLimit
+	if !cfg.Enabled {
+		return true
+	}
+	limit := cfg.Routes[r.URL.Path]
+	return app.allow(w, r, r.URL.Path, "user", strings.ToLower(username), limit.User)
+}
+
+// allow takes a token for value (a client IP or username) from the route's
+// bucket.
+func (app *App) allow(w http.ResponseWriter, r *http.Request, route, scope, value string, limit RateLimit) bool {
+	if limit.unlimited() {
+		return true
+	}
+
+	ok, retryAfter := app.limiter.take(rateLimitKey(route, scope, value), limit, time.Now())
+	if ok {
+		return true
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	logger.WarnContext(r.Context(), "Rate limit exceeded",
+		"route", route, "scope", scope, "client_ip", clientIP(r), "retry_after", seconds)
+	metrics.RateLimited.Inc(routeLabel(r.URL.Path, http.StatusTooManyRequests), scope)
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.writeAppError(w, ErrRateLimited, http.StatusTooManyRequests)
+	return false
+}
+
+// clientIPKey stores the resolved client address in the request context.
+const clientIPKey contextKey = "clientIP"
+
+// clientIPMiddleware resolves the client address once per request (see
+// resolveClientIP) for clientIP to return.
+func clientIPMiddleware(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, trusted)
+		ctx := context.WithValue(r.Context(), clientIPKey, ip)
+		addLogAttrs(ctx, slog.String("client_ip", ip))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// resolveClientIP returns the address of the client behind any trusted
+// proxies.
+//
+// Forwarding headers are only believed when the connection comes from a
+// trusted proxy, since anyone can send them. X-Forwarded-For is read from the
+// right, skipping trusted proxies, so entries a client added itself are never
+// used; X-Real-IP is the fallback for proxies that only set that.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	remote := remoteIP(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !trustedProxy(addr, trusted) {
+		return remote
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !trustedProxy(hop, trusted) {
+			return hop.Unmap().String()
+		}
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+	return remote
+}
+
+func trustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the host part of the connection's remote address.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseTrustedProxies parses addresses and CIDR prefixes (see
+// Config.TrustedProxies).
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("trustedProxies: invalid prefix %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("trustedProxies: invalid address %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
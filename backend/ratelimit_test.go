@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	limiter := newRateLimiter()
+	limit := RateLimit{Requests: 2, Per: time.Minute}
+	now := time.Now()
+
+	for i := range 2 {
+		if ok, _ := limiter.take("k", limit, now); !ok {
+			t.Fatalf("request %d rejected within budget", i+1)
+		}
+	}
+	ok, retryAfter := limiter.take("k", limit, now)
+	if ok {
+		t.Fatal("request over budget allowed")
+	}
+	if retryAfter <= 0 || retryAfter > 30*time.Second {
+		t.Errorf("retryAfter = %v, want about 30s", retryAfter)
+	}
+	if ok, _ := limiter.take("other", limit, now); !ok {
+		t.Error("separate key shares the spent bucket")
+	}
+	if ok, _ := limiter.take("k", limit, now.Add(30*time.Second)); !ok {
+		t.Error("token not refilled after retryAfter")
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remote     string
+		forwarded  string
+		realIP     string
+		wantClient string
+	}{
+		{"direct", "203.0.113.5:1234", "", "", "203.0.113.5"},
+		{"untrusted proxy headers ignored", "203.0.113.5:1234", "198.51.100.7", "", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"spoofed entry skipped", "10.0.0.1:1234", "1.2.3.4, 198.51.100.7, 10.0.0.2", "", "198.51.100.7"},
+		{"real ip fallback", "10.0.0.1:1234", "", "198.51.100.7", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := resolveClientIP(req, trusted); got != tt.wantClient {
+				t.Errorf("resolveClientIP() = %q, want %q", got, tt.wantClient)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("parseTrustedProxies accepted an invalid address")
+	}
+	if prefixes, _ := parseTrustedProxies([]string{"::ffff:10.0.0.1"}); prefixes[0] != netip.MustParsePrefix("10.0.0.1/32") {
+		t.Errorf("mapped address parsed as %v", prefixes[0])
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	app := newTestApp(t)
+	app.config.RateLimit.Routes = map[string]RouteLimit{
+		"/api/login/begin": {IP: RateLimit{Requests: 1, Per: time.Minute}},
+	}
+	handler := clientIPMiddleware(nil, app.rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	send := func(method, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/login/begin", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send(http.MethodPost, "203.0.113.5:1"); rec.Code != http.StatusNoContent {
+		t.Fatalf("first request status = %d", rec.Code)
+	}
+	rec := send(http.MethodPost, "203.0.113.5:2")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request status = %d, want 429", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != ErrRateLimited.Code {
+		t.Errorf("code = %q, want %q", got.Code, ErrRateLimited.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry == "" || strings.HasPrefix(retry, "0") {
+		t.Errorf("Retry-After = %q", retry)
+	}
+	if rec := send(http.MethodPost, "203.0.113.6:1"); rec.Code != http.StatusNoContent {
+		t.Errorf("other client status = %d, want its own budget", rec.Code)
+	}
+	if rec := send(http.MethodOptions, "203.0.113.5:3"); rec.Code != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want it not counted", rec.Code)
+	}
+}
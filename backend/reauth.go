@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// Sensitive operations need "fresh" authentication: user verification
+// (biometric or PIN) within Config.Sessions.ReauthWindow, not just a valid
+// session cookie. Login sets LoginSession.VerifiedAt; once it is older than
+// the window, the user reauthenticates with /api/reauth/begin and
+// /api/reauth/finish without leaving their session.
+
+// requireReauth wraps a handler that needs fresh authentication. Requests
+// without it get 401 AUTH_REQUIRED or 403 REAUTH_REQUIRED (see
+// requireRecentVerification); clients react to the latter by running the
+// reauth ceremony and retrying.
+func (app *App) requireReauth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := app.requireRecentVerification(w, r); !ok {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// verificationCredentials returns descriptors for the passkeys a logged-in
+// user may verify with (see App.canVerify) and, while the account is
+// locked, only those that can unlock it (the same rule as login). With none
+// left it writes 423 ACCOUNT_LOCKED, 403 CREDENTIAL_SUSPECT or 403
+// CREDENTIAL_SUSPENDED and ok is false.
+func (app *App) verificationCredentials(w http.ResponseWriter, user *User) (allowed []protocol.CredentialDescriptor, ok bool) {
+	candidates := user.CredentialDescriptors()
+	until := app.lockedUntil(lockoutUserKey(user))
+	if !until.IsZero() {
+		candidates = app.unlockingCredentials(user)
+	}
+	suspect := false
+	for _, descriptor := range candidates {
+		record := user.credentialRecord(descriptor.CredentialID)
+		switch {
+		case record == nil:
+		case app.canVerify(record):
+			allowed = append(allowed, descriptor)
+		case !record.Suspended:
+			suspect = true
+		}
+	}
+
+	switch {
+	case len(allowed) > 0:
+		return allowed, true
+	case !until.IsZero():
+		app.writeLocked(w, until)
+	case suspect:
+		app.writeAppError(w, ErrCredentialSuspect, http.StatusForbidden)
+	default:
+		app.writeAppError(w, ErrCredentialSuspended, http.StatusForbidden)
+	}
+	return nil, false
+}
+
+// handleReauthBegin starts an assertion for the logged-in user, limited to
+// their own passkeys that can verify (see App.canVerify).
+//
+// Response: WebAuthn CredentialAssertion (JSON)
+// HTTP Status: 200 (success), 401 (not logged in), 403 CREDENTIAL_SUSPENDED
+// or CREDENTIAL_SUSPECT (no usable passkey), 423 ACCOUNT_LOCKED
+func (app *App) handleReauthBegin(w http.ResponseWriter, r *http.Request) {
+	loginSession, ok := getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	user, exists := app.store.GetUserByID(loginSession.UserID)
+	if !exists {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	allowed, ok := app.verificationCredentials(w, user)
+	if !ok {
+		return
+	}
+
+	options, sessionData, err := app.webAuthn.BeginLogin(
+		user,
+		webauthn.WithAllowedCredentials(allowed),
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to begin reauthentication: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if err := app.store.StoreSession(sessionID, &Session{UserID: user.ID, Ceremony: ceremonyReauth, SessionData: *sessionData}); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
+
+	json.NewEncoder(w).Encode(options)
+}
+
+// handleReauthFinish verifies the assertion started by handleReauthBegin
+// and marks the current login session as freshly verified with that
+// passkey.
+//
+// The ceremony must belong to the user of the current login session, so a
+// challenge begun in one session cannot refresh another account's. Failed
+// assertions count towards account lockout.
+//
+// Response: {"verifiedAt": ..., "freshUntil": ...}
+// HTTP Status: 200 (verified), 400 (invalid session), 401 (not logged in or
+// assertion failed), 403 CREDENTIAL_SUSPENDED or CREDENTIAL_SUSPECT, 423
+// ACCOUNT_LOCKED
+func (app *App) handleReauthFinish(w http.ResponseWriter, r *http.Request) {
+	loginSession, ok := getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyReauth)
+	if !ok {
+		return
+	}
+	if string(session.UserID) != string(loginSession.UserID) {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		app.writeError(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Reauthentication failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	if !app.checkLockout(w, r, user, parsedResponse.RawID) {
+		return
+	}
+
+	// The session only allows the user's own passkeys
+	credential, err := app.webAuthn.ValidateLogin(user, session.SessionData, parsedResponse)
+	if err != nil {
+		app.audit(r, AuditEvent{
+			Type:         auditReauth,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(parsedResponse.RawID),
+			Outcome:      auditFailure,
+			Detail:       assertionFailure(err),
+		})
+		app.recordLoginFailure(r, user, parsedResponse.RawID)
+		app.writeError(w, fmt.Sprintf("Reauthentication failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	logCredential(r.Context(), credential.ID)
+
+	if !app.checkCredentialStanding(w, r, ceremonyReauth, sessionID, user, credential, parsedResponse.Response.AuthenticatorData.Counter) {
+		return
+	}
+	app.clearLoginFailures(r, user, credential.ID)
+
+	if !app.updateUserCredential(w, r, user, credential) {
+		return
+	}
+
+	now := time.Now()
+	if err := app.store.VerifyLoginSession(loginSession.ID, credential.ID, now); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to update login session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	app.store.DeleteSession(sessionID)
+	loginSession.VerifiedAt = now
+
+	logger.InfoContext(r.Context(), "Reauthenticated")
+	app.audit(r, AuditEvent{
+		Type:         auditReauth,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: encodeCredentialID(credential.ID),
+		Outcome:      auditSuccess,
+	})
+	app.writeSuccess(w, "Reauthentication successful", map[string]interface{}{
+		"verifiedAt": now,
+		"freshUntil": loginSession.FreshUntil(app.config.Sessions.ReauthWindow),
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+func TestRequireReauth(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice", "cred-1")
+	window := app.config.Sessions.ReauthWindow
+
+	tests := []struct {
+		name     string
+		session  *LoginSession // nil for no login session
+		wantCode int
+		wantErr  string
+	}{
+		{"signed out", nil, http.StatusUnauthorized, ErrAuthRequired.Code},
+		{"never verified", &LoginSession{UserID: user.ID}, http.StatusForbidden, ErrReauthRequired.Code},
+		{"stale", &LoginSession{UserID: user.ID, VerifiedAt: time.Now().Add(-window - time.Second)}, http.StatusForbidden, ErrReauthRequired.Code},
+		{"fresh", &LoginSession{UserID: user.ID, VerifiedAt: time.Now().Add(-window / 2)}, http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := app.requireReauth(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/user/passkeys/x", nil)
+			if tc.session != nil {
+				req = req.WithContext(setLoginSession(req.Context(), tc.session))
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tc.wantCode || called != (tc.wantErr == "") {
+				t.Fatalf("status %d, handler called %v; want %d", rec.Code, called, tc.wantCode)
+			}
+			if tc.wantErr != "" {
+				if resp := decodeError(t, rec); resp.Code != tc.wantErr {
+					t.Errorf("error code %q, want %q", resp.Code, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+// TestPasskeyChangesRequireReauth checks that deleting and renaming a
+// passkey, wrapped in requireReauth as the router does, are refused for a
+// session whose verification is stale and leave the passkey untouched.
+func TestPasskeyChangesRequireReauth(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, app.store, "alice", "cred-1", "cred-2")
+	path := "/api/user/passkeys/" + encodeCredentialID([]byte("cred-2"))
+	stale := time.Now().Add(-app.config.Sessions.ReauthWindow - time.Second)
+
+	send := func(method, body string, verifiedAt time.Time) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(setLoginSession(req.Context(), &LoginSession{UserID: user.ID, VerifiedAt: verifiedAt}))
+		rec := httptest.NewRecorder()
+		switch method {
+		case http.MethodDelete:
+			app.requireReauth(app.handleDeletePasskey)(rec, req)
+		case http.MethodPatch:
+			app.requireReauth(app.handleRenamePasskey)(rec, req)
+		}
+		return rec
+	}
+
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		rec := send(method, `{"name":"Stale"}`, stale)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s with stale verification: status %d, want 403", method, rec.Code)
+		}
+		if resp := decodeError(t, rec); resp.Code != ErrReauthRequired.Code {
+			t.Errorf("%s: error code %q, want %q", method, resp.Code, ErrReauthRequired.Code)
+		}
+	}
+	stored, _ := app.store.GetUser("alice")
+	if record := stored.credentialRecord([]byte("cred-2")); record == nil || record.Nickname != "" {
+		t.Fatalf("passkey changed by stale requests: %+v", record)
+	}
+
+	if rec := send(http.MethodPatch, `{"name":"Phone"}`, time.Now()); rec.Code != http.StatusOK {
+		t.Fatalf("fresh rename: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := send(http.MethodDelete, "", time.Now()); rec.Code != http.StatusOK {
+		t.Fatalf("fresh delete: status %d: %s", rec.Code, rec.Body)
+	}
+	stored, _ = app.store.GetUser("alice")
+	if ids := credentialIDs(stored); len(ids) != 1 || ids[0] != "cred-1" {
+		t.Errorf("credentials after fresh delete = %v", ids)
+	}
+}
+
+func TestLoginSessionFreshUntil(t *testing.T) {
+	verified := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	session := &LoginSession{VerifiedAt: verified}
+
+	if got := session.FreshUntil(5 * time.Minute); !got.Equal(verified.Add(5 * time.Minute)) {
+		t.Errorf("FreshUntil = %v", got)
+	}
+	if !session.RecentlyVerified(verified.Add(5*time.Minute), 5*time.Minute) {
+		t.Error("not recently verified at the end of the window")
+	}
+	if session.RecentlyVerified(verified.Add(5*time.Minute+time.Second), 5*time.Minute) {
+		t.Error("recently verified after the window")
+	}
+	if got := (&LoginSession{}).FreshUntil(5 * time.Minute); !got.IsZero() {
+		t.Errorf("FreshUntil without verification = %v, want zero", got)
+	}
+}
+
+// reauthFinish runs the reauth ceremony for loginSession with passkey. The
+// challenge allows every passkey of the user, as a client could replay one
+// begun before the passkey became suspect.
+func reauthFinish(t *testing.T, app *App, user *User, loginSession *LoginSession, passkey *testAuthenticator) *httptest.ResponseRecorder {
+	t.Helper()
+	_, sessionData, err := app.webAuthn.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if err := app.store.StoreSession("reauth-1", &Session{UserID: user.ID, Ceremony: ceremonyReauth, SessionData: *sessionData}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/reauth/finish", bytes.NewReader(passkey.login(t, sessionData.Challenge, user.ID)))
+	ctx := setSessionID(req.Context(), "reauth-1")
+	req = req.WithContext(setLoginSession(ctx, loginSession))
+	rec := httptest.NewRecorder()
+	app.handleReauthFinish(rec, req)
+	return rec
+}
+
+// TestReauthSuspectPasskey checks that under the step-up policy a suspect
+// passkey is refused for reauthentication instead of starting a step-up,
+// which would sign in a new session rather than refresh the current one.
+func TestReauthSuspectPasskey(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Security.ClonePolicy = clonePolicyStepUp
+	user := createTestUser(t, app.store, "alice")
+	cloned, other := newTestAuthenticator(t), newTestAuthenticator(t)
+	cloned.enroll(t, app, user)
+	other.enroll(t, app, user)
+	user, _ = app.store.GetUser("alice")
+
+	loginSession := &LoginSession{ID: "token-hash", UserID: user.ID, CreatedAt: time.Now(), LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := app.store.CreateLoginSession(loginSession); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := reauthFinish(t, app, user, loginSession, cloned); rec.Code != http.StatusOK {
+		t.Fatalf("reauth: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// A copy of the key replays an old counter
+	cloned.signCount = 0
+	rec := reauthFinish(t, app, user, loginSession, cloned)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("reauth with a repeated counter: status %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if resp := decodeError(t, rec); resp.Code != ErrCredentialSuspect.Code {
+		t.Errorf("error code %q, want %q", resp.Code, ErrCredentialSuspect.Code)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Value != "" {
+			t.Errorf("refused reauth set cookie %s", cookie.Name)
+		}
+	}
+	if _, ok := app.store.GetSession("reauth-1"); ok {
+		t.Error("reauth ceremony kept after refusing the suspect passkey")
+	}
+
+	// begin offers only the passkey in good standing
+	req := httptest.NewRequest(http.MethodPost, "/api/reauth/begin", nil)
+	req = req.WithContext(setLoginSession(req.Context(), loginSession))
+	begin := httptest.NewRecorder()
+	app.handleReauthBegin(begin, req)
+	var options protocol.CredentialAssertion
+	if err := json.NewDecoder(begin.Body).Decode(&options); err != nil {
+		t.Fatalf("decode reauth options: %v", err)
+	}
+	allowed := options.Response.AllowedCredentials
+	if len(allowed) != 1 || !bytes.Equal(allowed[0].CredentialID, other.id) {
+		t.Errorf("reauth offered %d passkeys, want only the one in good standing", len(allowed))
+	}
+
+	if rec := reauthFinish(t, app, user, loginSession, other); rec.Code != http.StatusOK {
+		t.Fatalf("reauth with another passkey: status %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := app.store.GetLoginSession("token-hash"); !bytes.Equal(got.CredentialID, other.id) || got.VerifiedAt.IsZero() {
+		t.Errorf("login session = %+v, want verified with the other passkey", got)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Recovery codes are the fallback for users who lost every passkey. Each
+// code signs in once, into a restricted session that can only enroll a new
+// passkey (see recoverySessionPaths).
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10 // Characters, 50 bits of entropy
+
+	// recoveryCodeAlphabet is Crockford's base32: no I, L, O or U, so codes
+	// survive being read aloud or copied by hand.
+	recoveryCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// argon2id parameters for recovery code hashes: the OWASP minimum of 19 MiB
+// and two passes. Stored hashes carry their parameters, so raising these
+// only affects codes generated afterwards.
+const (
+	recoveryHashPasses  = 2
+	recoveryHashMemory  = 19 * 1024 // KiB
+	recoveryHashThreads = 1
+	recoveryHashKeyLen  = 32
+)
+
+// recoveryFailureRoute and recoveryFailureScope name the rate limiter
+// buckets counting wrong recovery codes, one per account. They are separate
+// from the lockout counters: failed assertions cannot block recovery, and
+// wrong codes cannot lock passkey logins.
+const (
+	recoveryFailureRoute = "recovery-code"
+	recoveryFailureScope = "user"
+)
+
+// recoverySessionPaths are the only routes that see a recovery session;
+// everywhere else the request is treated as signed out.
+var recoverySessionPaths = map[string]bool{
+	"/api/user/passkeys/register/begin":  true,
+	"/api/user/passkeys/register/finish": true,
+	"/api/logout":                        true,
+}
+
+// RecoveryLoginRequest is the body of POST /api/recovery/login.
+type RecoveryLoginRequest struct {
+	Username string `json:"username"`
+	Code     string `json:"code"` // Case, spaces and dashes are ignored
+}
+
+// generateRecoveryCodes returns a fresh set of codes for display and their
+// hashes for storage.
+//
+// The codes of one set share a salt, so checking a code costs one hash
+// rather than one per remaining code. Codes are random, so sharing the salt
+// within a set gives nothing away.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("generate recovery code salt: %w", err)
+	}
+
+	raw := make([]byte, recoveryCodeLength)
+	for i := 0; i < recoveryCodeCount; i++ {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		var code strings.Builder
+		for j, b := range raw {
+			if j == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			code.WriteByte(recoveryCodeAlphabet[b&31]) // 256 is a multiple of 32, so unbiased
+		}
+		codes = append(codes, code.String())
+		hashes = append(hashes, hashRecoveryCode(normalizeRecoveryCode(code.String()), salt,
+			recoveryHashPasses, recoveryHashMemory, recoveryHashThreads))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode uppercases a code, drops separators and maps the
+// letters Crockford's base32 leaves out to the digits they resemble.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t':
+			return -1
+		case 'O', 'o':
+			return '0'
+		case 'I', 'i', 'L', 'l':
+			return '1'
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, code)
+}
+
+// hashRecoveryCode returns the argon2id hash of code in the PHC string
+// format: $argon2id$v=19$m=...,t=...,p=...$salt$hash
+func hashRecoveryCode(code string, salt []byte, passes, memory uint32, threads uint8) string {
+	key := argon2.IDKey([]byte(code), salt, passes, memory, threads, recoveryHashKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, passes, threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// matchRecoveryCode returns the stored hash that code matches, or "".
+func matchRecoveryCode(code string, hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+
+	// Every hash of a set has the same parameters and salt
+	var memory, passes uint32
+	var threads uint8
+	var version int
+	parts := strings.Split(hashes[0], "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return ""
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return ""
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return ""
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return ""
+	}
+
+	candidate := hashRecoveryCode(normalizeRecoveryCode(code), salt, passes, memory, threads)
+	match := ""
+	for _, hash := range hashes {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			match = hash
+		}
+	}
+	return match
+}
+
+// issueRecoveryCodes replaces the user's recovery codes with a new set and
+// returns the codes, which are never shown again.
+func (app *App) issueRecoveryCodes(r *http.Request, user *User) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := app.store.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	logger.InfoContext(r.Context(), "Recovery codes generated")
+	app.audit(r, AuditEvent{
+		Type:     auditRecoveryCodes,
+		Actor:    user.Username,
+		Username: user.Username,
+		Outcome:  auditSuccess,
+		Detail:   fmt.Sprintf("%d codes", len(codes)),
+	})
+	return codes, nil
+}
+
+// handleRecoveryCodes reports how many recovery codes the logged-in user
+// has left (GET) or replaces them with a new set (POST).
+//
+// Generating requires recent user verification, like other sensitive
+// account changes. The new codes are returned once and invalidate the old
+// ones.
+//
+// Response: {"remaining": n} (GET) or {"codes": [...]} (POST)
+// HTTP Status: 200 (success), 401 (not logged in), 403 (verification too old)
+func (app *App) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		session, ok := getLoginSession(r.Context())
+		if !ok {
+			app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+			return
+		}
+		hashes, err := app.store.GetRecoveryCodes(session.UserID)
+		if err != nil {
+			app.writeError(w, fmt.Sprintf("Failed to load recovery codes: %v", err), http.StatusInternalServerError)
+			return
+		}
+		app.writeSuccess(w, "Recovery codes", map[string]interface{}{"remaining": len(hashes)})
+		return
+	}
+
+	_, user, ok := app.requireRecentVerification(w, r)
+	if !ok {
+		return
+	}
+	codes, err := app.issueRecoveryCodes(r, user)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to generate recovery codes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	app.writeSuccess(w, "Recovery codes generated", map[string]interface{}{"codes": codes})
+}
+
+// handleRecoveryLogin signs a user in with a recovery code.
+//
+// The code is used up and the session is restricted: it lasts the
+// reauthentication window (Config.Sessions.ReauthWindow) and only reaches
+// recoverySessionPaths, which is enough to enroll a new passkey and then
+// sign in with it.
+//
+// Every attempt takes a token from the account's recovery failure budget
+// (Config.Security.RecoveryFailures) and a correct code gives it back, so
+// only wrong codes use it up. The assertion lockout plays no part.
+//
+// Request: RecoveryLoginRequest
+// Response: {"username": ..., "remaining": n}
+// HTTP Status: 200 (signed in), 400 (bad body), 401 INVALID_RECOVERY_CODE,
+// 429 RATE_LIMITED
+func (app *App) handleRecoveryLogin(w http.ResponseWriter, r *http.Request) {
+	var req RecoveryLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateUsername(req.Username); err != nil {
+		app.writeAppError(w, ErrInvalidRecoveryCode, http.StatusUnauthorized)
+		return
+	}
+	if !app.allowUser(w, r, req.Username) {
+		return
+	}
+
+	user, exists := app.store.GetUser(req.Username)
+	if !exists {
+		// Spend the same time as a real check so unknown users don't stand out
+		hashRecoveryCode(normalizeRecoveryCode(req.Code), make([]byte, 16),
+			recoveryHashPasses, recoveryHashMemory, recoveryHashThreads)
+		app.writeAppError(w, ErrInvalidRecoveryCode, http.StatusUnauthorized)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	failureKey := encodeCredentialID(user.ID)
+	if !app.allow(w, r, recoveryFailureRoute, recoveryFailureScope, failureKey, app.config.Security.RecoveryFailures) {
+		return
+	}
+
+	hashes, err := app.store.GetRecoveryCodes(user.ID)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to load recovery codes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	match := matchRecoveryCode(req.Code, hashes)
+	if match != "" {
+		// Fails if a concurrent request used the same code first
+		err = app.store.UseRecoveryCode(user.ID, match)
+	}
+	if match == "" || err != nil {
+		app.audit(r, AuditEvent{
+			Type:     auditLogin,
+			Username: user.Username,
+			Outcome:  auditFailure,
+			Detail:   ErrInvalidRecoveryCode.Code,
+		})
+		app.writeAppError(w, ErrInvalidRecoveryCode, http.StatusUnauthorized)
+		return
+	}
+	app.limiter.refund(rateLimitKey(recoveryFailureRoute, recoveryFailureScope, failureKey))
+
+	if err := app.startRecoverySession(w, r, user); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to create login session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	remaining := len(hashes) - 1
+	logger.WarnContext(r.Context(), "Signed in with a recovery code", "remaining", remaining)
+	metrics.Logins.Inc("recovery_code")
+	app.audit(r, AuditEvent{
+		Type:     auditLogin,
+		Actor:    user.Username,
+		Username: user.Username,
+		Outcome:  auditSuccess,
+		Detail:   fmt.Sprintf("recovery code, %d left", remaining),
+	})
+	app.writeSuccess(w, "Signed in with a recovery code; register a new passkey", map[string]interface{}{
+		"username":  user.Username,
+		"remaining": remaining,
+	})
+}
+
+// startRecoverySession creates a restricted login session for user (see
+// handleRecoveryLogin). The recovery code counts as user verification, and
+// the session ends with the reauthentication window, so adding a passkey
+// never asks for verification the user cannot give.
+func (app *App) startRecoverySession(w http.ResponseWriter, r *http.Request, user *User) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return fmt.Errorf("generate session token: %w", err)
+	}
+
+	lifetime := app.config.Sessions.ReauthWindow
+	now := time.Now()
+	session := &LoginSession{
+		ID:         hashSessionToken(token),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(lifetime),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		VerifiedAt: now,
+		Recovery:   true,
+	}
+	if err := app.store.CreateLoginSession(session); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, app.config.Cookies.newCookie(userSessionCookie, token, lifetime))
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoveryCodeMatching(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generateRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes", len(codes), len(hashes))
+	}
+
+	code := codes[3]
+	if len(normalizeRecoveryCode(code)) != recoveryCodeLength {
+		t.Errorf("code %q has the wrong length", code)
+	}
+	for _, typed := range []string{code, strings.ToLower(code), strings.ReplaceAll(code, "-", " ")} {
+		if got := matchRecoveryCode(typed, hashes); got != hashes[3] {
+			t.Errorf("matchRecoveryCode(%q) did not match its hash", typed)
+		}
+	}
+	if got := matchRecoveryCode("00000-00000", hashes); got != "" {
+		t.Errorf("matchRecoveryCode matched a wrong code")
+	}
+	if got := matchRecoveryCode(code, nil); got != "" {
+		t.Errorf("matchRecoveryCode matched without hashes")
+	}
+}
+
+// TestRecoveryLoginFailures checks that wrong recovery codes have their own
+// budget, independent of the assertion lockout in both directions.
+func TestRecoveryLoginFailures(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Security.RecoveryFailures = RateLimit{Requests: 2, Per: time.Hour}
+	user := testUser("alice", "cred-1")
+	if err := app.store.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	recoveryLogin := func(code string) int {
+		body := `{"username": "alice", "code": "` + code + `"}`
+		rec := httptest.NewRecorder()
+		app.handleRecoveryLogin(rec, httptest.NewRequest(http.MethodPost, "/api/recovery/login", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// A locked account can still recover
+	req := httptest.NewRequest(http.MethodPost, "/api/login/finish", nil)
+	for i := 0; i < app.config.Security.Lockout.Threshold; i++ {
+		app.recordLoginFailure(req, user, []byte("cred-1"))
+	}
+	if code := recoveryLogin(codes[0]); code != http.StatusOK {
+		t.Fatalf("recovery while locked out = %d, want 200", code)
+	}
+	app.store.ClearLoginFailures(lockoutUserKey(user), lockoutCredentialKey([]byte("cred-1")))
+
+	// Correct codes gave their token back; wrong ones use the budget up
+	for i := 0; i < 2; i++ {
+		if code := recoveryLogin("00000-00000"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d = %d, want 401", i+1, code)
+		}
+	}
+	if code := recoveryLogin(codes[1]); code != http.StatusTooManyRequests {
+		t.Errorf("attempt over budget = %d, want 429", code)
+	}
+
+	// Wrong codes do not lock passkey logins
+	if until := app.lockedUntil(lockoutUserKey(user)); !until.IsZero() {
+		t.Errorf("wrong recovery codes locked the account until %v", until)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sessionTTL is how long a WebAuthn ceremony session stays valid between
+// the begin and finish requests. Every Store implementation honours it.
+//
+// These lifetimes default to DefaultConfig and are set from Config.Sessions
+// at startup, before any store is created.
+var sessionTTL = 5 * time.Minute
+
+// Login session lifetimes. A session ends at whichever limit is hit first.
+var (
+	loginSessionLifetime    = 24 * time.Hour // Absolute lifetime from login
+	loginSessionIdleTimeout = 1 * time.Hour  // Maximum gap between requests
+)
+
+// Store is the persistence boundary for users, credentials, WebAuthn
+// ceremony sessions, authenticated login sessions and the audit log.
+//
+// Handlers only ever talk to this interface, so the backing storage can be
+// swapped without touching the HTTP layer:
+//   - InMemoryStore: zero setup, everything is lost on restart
+//   - SQLiteStore: single-file database that survives restarts
+//
+// Implementations must be safe for concurrent use, and users returned by
+// lookups are copies owned by the caller. Lookups report absence
+// with a false return value; storage failures on lookups are logged by the
+// implementation and also reported as "not found".
+type Store interface {
+	// User management
+	CreateUser(user *User) error
+	GetUser(username string) (*User, bool)
+	GetUserByID(userID []byte) (*User, bool)
+	UpdateUser(user *User) error // Profile fields only, never credentials
+
+	// Credential management. Each call changes a single stored credential,
+	// so a stale *User held by one request cannot undo another's changes.
+	AddCredential(userID []byte, credential CredentialRecord) error
+	UpdateCredential(userID, credentialID []byte, update func(*CredentialRecord)) error
+	DeleteUserPasskey(username string, credentialID []byte, allowLast bool) error // ErrLastCredential for the only one unless allowLast
+	RenameUserPasskey(username string, credentialID []byte, nickname string) error
+	GetUserPasskeys(username string) ([]PasskeyInfo, error)
+
+	// WebAuthn ceremony sessions
+	StoreSession(sessionID string, session *Session) error
+	GetSession(sessionID string) (*Session, bool)
+	DeleteSession(sessionID string)
+
+	// Authenticated login sessions, keyed by token hash
+	CreateLoginSession(session *LoginSession) error
+	GetLoginSession(id string) (*LoginSession, bool)
+	TouchLoginSession(id string, lastSeen time.Time) error
+	// VerifyLoginSession records a reauthentication: the credential that
+	// passed user verification and when (see handleReauthFinish).
+	VerifyLoginSession(id string, credentialID []byte, verifiedAt time.Time) error
+	DeleteLoginSession(id string) error
+
+	// Security audit log (see AuditEvent). Events are only ever appended:
+	// AppendAuditEvent links the event into the hash chain, setting its ID
+	// and hashes, and ListAuditEvents returns matches newest first.
+	AppendAuditEvent(event *AuditEvent) error
+	ListAuditEvents(filter AuditFilter) ([]AuditEvent, error)
+
+	// Failed assertion counters for account lockout (see LoginFailures).
+	// RecordLoginFailure adds one failure at the given time, starting a new
+	// run if the last failure was before resetBefore, and returns the result.
+	RecordLoginFailure(key string, at, resetBefore time.Time) (*LoginFailures, error)
+	GetLoginFailures(key string) (*LoginFailures, bool)
+	ClearLoginFailures(keys ...string) error
+
+	// Recovery codes, as argon2id hashes (see recovery.go). UseRecoveryCode
+	// removes one hash and returns ErrInvalidRecoveryCode if it was already
+	// gone, so each code works once even under concurrent requests.
+	ReplaceRecoveryCodes(userID []byte, hashes []string) error
+	GetRecoveryCodes(userID []byte) ([]string, error)
+	UseRecoveryCode(userID []byte, hash string) error
+
+	// CleanupExpiredSessions prunes both ceremony and login sessions.
+	CleanupExpiredSessions()
+
+	// SessionCounts returns how many unexpired ceremony and login sessions
+	// are stored; exported as gauges on /metrics.
+	SessionCounts() (ceremony, login int, err error)
+
+	// Ping reports whether the backing storage is reachable; used by the
+	// readiness probe.
+	Ping(ctx context.Context) error
+
+	// Close flushes pending writes and releases any resources held by the
+	// store. Called once, after the HTTP server has drained.
+	Close() error
+}
+
+// Compile-time checks that both backends and the metrics wrapper satisfy Store.
+var (
+	_ Store = (*InMemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+	_ Store = instrumentedStore{}
+)
+
+// NewStore creates the Store selected by the -store flag.
+//
+// Supported kinds are "memory" (default) and "sqlite". The dsn is only used
+// by persistent backends and is the database file path for SQLite.
+func NewStore(kind, dsn string) (Store, error) {
+	switch kind {
+	case "", "memory":
+		return NewInMemoryStore(), nil
+	case "sqlite":
+		return NewSQLiteStore(dsn)
+	default:
+		return nil, fmt.Errorf("unknown store %q (expected memory or sqlite)", kind)
+	}
+}
+
+// runSessionCleanup prunes expired sessions every interval until ctx is
+// cancelled, so shutdown can stop it before the store is closed.
+func runSessionCleanup(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			store.CleanupExpiredSessions()
+		}
+	}
+}
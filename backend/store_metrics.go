@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// instrumentedStore wraps a Store and records the latency of every call, and
+// the calls that failed with a storage error, in metrics.
+//
+// AppErrors such as ErrUserExists or ErrCredentialNotFound are expected
+// answers rather than storage failures, so they are not counted as errors.
+// Ping, SessionCounts and Close pass straight through: they are driven by
+// probes and scrapes, not by user traffic.
+type instrumentedStore struct {
+	Store
+}
+
+// InstrumentStore returns store with metrics recorded for every operation.
+func InstrumentStore(store Store) Store {
+	return instrumentedStore{Store: store}
+}
+
+// observe records one call to operation that started at start.
+func (s instrumentedStore) observe(operation string, start time.Time, err error) {
+	metrics.StoreDuration.ObserveSince(start, operation)
+	var appErr *AppError
+	if err != nil && !errors.As(err, &appErr) {
+		metrics.StoreErrors.Inc(operation)
+	}
+}
+
+func (s instrumentedStore) CreateUser(user *User) error {
+	start := time.Now()
+	err := s.Store.CreateUser(user)
+	s.observe("create_user", start, err)
+	return err
+}
+
+func (s instrumentedStore) GetUser(username string) (*User, bool) {
+	defer s.observe("get_user", time.Now(), nil)
+	return s.Store.GetUser(username)
+}
+
+func (s instrumentedStore) GetUserByID(userID []byte) (*User, bool) {
+	defer s.observe("get_user_by_id", time.Now(), nil)
+	return s.Store.GetUserByID(userID)
+}
+
+func (s instrumentedStore) UpdateUser(user *User) error {
+	start := time.Now()
+	err := s.Store.UpdateUser(user)
+	s.observe("update_user", start, err)
+	return err
+}
+
+func (s instrumentedStore) AddCredential(userID []byte, credential CredentialRecord) error {
+	start := time.Now()
+	err := s.Store.AddCredential(userID, credential)
+	s.observe("add_credential", start, err)
+	return err
+}
+
+func (s instrumentedStore) UpdateCredential(userID, credentialID []byte, update func(*CredentialRecord)) error {
+	start := time.Now()
+	err := s.Store.UpdateCredential(userID, credentialID, update)
+	s.observe("update_credential", start, err)
+	return err
+}
+
+func (s instrumentedStore) DeleteUserPasskey(username string, credentialID []byte, allowLast bool) error {
+	start := time.Now()
+	err := s.Store.DeleteUserPasskey(username, credentialID, allowLast)
+	s.observe("delete_passkey", start, err)
+	return err
+}
+
+func (s instrumentedStore) RenameUserPasskey(username string, credentialID []byte, nickname string) error {
+	start := time.Now()
+	err := s.Store.RenameUserPasskey(username, credentialID, nickname)
+	s.observe("rename_passkey", start, err)
+	return err
+}
+
+func (s instrumentedStore) GetUserPasskeys(username string) ([]PasskeyInfo, error) {
+	start := time.Now()
+	passkeys, err := s.Store.GetUserPasskeys(username)
+	s.observe("get_passkeys", start, err)
+	return passkeys, err
+}
+
+func (s instrumentedStore) StoreSession(sessionID string, session *Session) error {
+	start := time.Now()
+	err := s.Store.StoreSession(sessionID, session)
+	s.observe("store_session", start, err)
+	return err
+}
+
+func (s instrumentedStore) GetSession(sessionID string) (*Session, bool) {
+	defer s.observe("get_session", time.Now(), nil)
+	return s.Store.GetSession(sessionID)
+}
+
+func (s instrumentedStore) DeleteSession(sessionID string) {
+	defer s.observe("delete_session", time.Now(), nil)
+	s.Store.DeleteSession(sessionID)
+}
+
+func (s instrumentedStore) CreateLoginSession(session *LoginSession) error {
+	start := time.Now()
+	err := s.Store.CreateLoginSession(session)
+	s.observe("create_login_session", start, err)
+	return err
+}
+
+func (s instrumentedStore) GetLoginSession(id string) (*LoginSession, bool) {
+	defer s.observe("get_login_session", time.Now(), nil)
+	return s.Store.GetLoginSession(id)
+}
+
+func (s instrumentedStore) TouchLoginSession(id string, lastSeen time.Time) error {
+	start := time.Now()
+	err := s.Store.TouchLoginSession(id, lastSeen)
+	s.observe("touch_login_session", start, err)
+	return err
+}
+
+func (s instrumentedStore) VerifyLoginSession(id string, credentialID []byte, verifiedAt time.Time) error {
+	start := time.Now()
+	err := s.Store.VerifyLoginSession(id, credentialID, verifiedAt)
+	s.observe("verify_login_session", start, err)
+	return err
+}
+
+func (s instrumentedStore) DeleteLoginSession(id string) error {
+	start := time.Now()
+	err := s.Store.DeleteLoginSession(id)
+	s.observe("delete_login_session", start, err)
+	return err
+}
+
+func (s instrumentedStore) CleanupExpiredSessions() {
+	defer s.observe("cleanup_sessions", time.Now(), nil)
+	s.Store.CleanupExpiredSessions()
+}
+
+func (s instrumentedStore) AppendAuditEvent(event *AuditEvent) error {
+	start := time.Now()
+	err := s.Store.AppendAuditEvent(event)
+	s.observe("append_audit_event", start, err)
+	return err
+}
+
+func (s instrumentedStore) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	start := time.Now()
+	events, err := s.Store.ListAuditEvents(filter)
+	s.observe("list_audit_events", start, err)
+	return events, err
+}
+
+func (s instrumentedStore) RecordLoginFailure(key string, at, resetBefore time.Time) (*LoginFailures, error) {
+	start := time.Now()
+	failures, err := s.Store.RecordLoginFailure(key, at, resetBefore)
+	s.observe("record_login_failure", start, err)
+	return failures, err
+}
+
+func (s instrumentedStore) GetLoginFailures(key string) (*LoginFailures, bool) {
+	defer s.observe("get_login_failures", time.Now(), nil)
+	return s.Store.GetLoginFailures(key)
+}
+
+func (s instrumentedStore) ClearLoginFailures(keys ...string) error {
+	start := time.Now()
+	err := s.Store.ClearLoginFailures(keys...)
+	s.observe("clear_login_failures", start, err)
+	return err
+}
+
+func (s instrumentedStore) ReplaceRecoveryCodes(userID []byte, hashes []string) error {
+	start := time.Now()
+	err := s.Store.ReplaceRecoveryCodes(userID, hashes)
+	s.observe("replace_recovery_codes", start, err)
+	return err
+}
+
+func (s instrumentedStore) GetRecoveryCodes(userID []byte) ([]string, error) {
+	start := time.Now()
+	hashes, err := s.Store.GetRecoveryCodes(userID)
+	s.observe("get_recovery_codes", start, err)
+	return hashes, err
+}
+
+func (s instrumentedStore) UseRecoveryCode(userID []byte, hash string) error {
+	start := time.Now()
+	err := s.Store.UseRecoveryCode(userID, hash)
+	s.observe("use_recovery_code", start, err)
+	return err
+}
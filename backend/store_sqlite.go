@@ -0,0 +1,810 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registers as "sqlite"
+)
+
+// SQLiteStore persists users, credentials and sessions in a SQLite database.
+//
+// Unlike InMemoryStore, registered passkeys survive a backend restart, so
+// testers do not have to re-enroll every device after each deploy.
+//
+// Storage Structure:
+//   - users: one row per account, unique on username
+//   - credentials: one row per passkey, JSON-encoded CredentialRecord
+//   - sessions: temporary WebAuthn ceremony state with creation time
+//   - login_sessions: authenticated sessions keyed by token hash
+//   - audit_events: append-only security audit log; triggers refuse
+//     UPDATE and DELETE so the application cannot rewrite it by accident
+//
+// Timestamps are written in UTC using SQLite's own datetime format so they
+// sort and compare correctly inside SQL (e.g. session expiry cleanup).
+//
+// Thread Safety:
+// database/sql handles connection pooling; the pool is limited to a single
+// connection so SQLite never reports "database is locked" under concurrent
+// writes from the demo's handlers.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// sqliteMigrations holds the schema, one entry per version.
+//
+// The database records how many entries have been applied in PRAGMA
+// user_version, so new tables or columns are added by appending a step here
+// and never by editing an existing one.
+var sqliteMigrations = []string{
+	`CREATE TABLE users (
+		id           BLOB PRIMARY KEY,
+		username     TEXT NOT NULL UNIQUE,
+		display_name TEXT NOT NULL,
+		created_at   DATETIME NOT NULL
+	);
+	CREATE TABLE credentials (
+		id       BLOB PRIMARY KEY,
+		user_id  BLOB NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		data     TEXT NOT NULL
+	);
+	CREATE INDEX credentials_user_id ON credentials(user_id);
+	CREATE TABLE sessions (
+		id         TEXT PRIMARY KEY,
+		user_id    BLOB,
+		data       TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);`,
+	`CREATE TABLE login_sessions (
+		id            TEXT PRIMARY KEY,
+		user_id       BLOB NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at    DATETIME NOT NULL,
+		last_seen_at  DATETIME NOT NULL,
+		expires_at    DATETIME NOT NULL,
+		ip            TEXT NOT NULL,
+		user_agent    TEXT NOT NULL,
+		credential_id BLOB
+	);
+	CREATE INDEX login_sessions_user_id ON login_sessions(user_id);`,
+	`ALTER TABLE sessions ADD COLUMN ceremony TEXT NOT NULL DEFAULT '';
+	ALTER TABLE sessions ADD COLUMN pending_user TEXT;
+	ALTER TABLE login_sessions ADD COLUMN verified_at DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';`,
+	`CREATE TABLE audit_events (
+		id            INTEGER PRIMARY KEY,
+		time          DATETIME NOT NULL,
+		type          TEXT NOT NULL,
+		actor         TEXT NOT NULL,
+		username      TEXT NOT NULL,
+		credential_id TEXT NOT NULL,
+		ip            TEXT NOT NULL,
+		user_agent    TEXT NOT NULL,
+		outcome       TEXT NOT NULL,
+		detail        TEXT NOT NULL,
+		prev_hash     TEXT NOT NULL,
+		hash          TEXT NOT NULL
+	);
+	CREATE INDEX audit_events_username ON audit_events(username);
+	CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+	CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;`,
+	`ALTER TABLE sessions ADD COLUMN step_up_from BLOB;`,
+	`CREATE TABLE login_failures (
+		subject TEXT PRIMARY KEY,
+		count   INTEGER NOT NULL,
+		last_at DATETIME NOT NULL
+	);`,
+	`CREATE TABLE recovery_codes (
+		user_id BLOB NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		hash    TEXT NOT NULL,
+		PRIMARY KEY (user_id, hash)
+	);
+	ALTER TABLE login_sessions ADD COLUMN recovery INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE sessions ADD COLUMN pending_transaction TEXT;`,
+}
+
+// NewSQLiteStore opens (or creates) the database at path and applies any
+// pending schema migrations.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite store requires a database path")
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// migrate brings the schema up to date inside a single transaction.
+func (s *SQLiteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version >= len(sqliteMigrations) {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("apply schema migration %d: %w", i+1, err)
+		}
+	}
+	// PRAGMA does not accept bound parameters
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteMigrations))); err != nil {
+		return fmt.Errorf("record schema version: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// CreateUser inserts a new user and any attached credentials, returning
+// ErrUserExists if the username is taken.
+func (s *SQLiteStore) CreateUser(user *User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("create user: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO users (id, username, display_name, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(username) DO NOTHING`,
+		user.ID, user.Username, user.DisplayName, user.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("create user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserExists
+	}
+
+	if err := writeCredentials(tx, user); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUser retrieves a user and their credentials by username.
+func (s *SQLiteStore) GetUser(username string) (*User, bool) {
+	return s.loadUser(`SELECT id, username, display_name, created_at FROM users WHERE username = ?`, username)
+}
+
+// GetUserByID retrieves a user and their credentials by WebAuthn user ID.
+func (s *SQLiteStore) GetUserByID(userID []byte) (*User, bool) {
+	return s.loadUser(`SELECT id, username, display_name, created_at FROM users WHERE id = ?`, userID)
+}
+
+// loadUser runs a single-user query and attaches the user's credentials.
+func (s *SQLiteStore) loadUser(query string, arg interface{}) (*User, bool) {
+	user := &User{}
+	err := s.db.QueryRow(query, arg).Scan(&user.ID, &user.Username, &user.DisplayName, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("sqlite: load user: %v", err)
+		return nil, false
+	}
+
+	credentials, err := s.loadCredentials(user.ID)
+	if err != nil {
+		logger.Errorf("sqlite: load credentials for %s: %v", user.Username, err)
+		return nil, false
+	}
+	user.Credentials = credentials
+
+	return user, true
+}
+
+// loadCredentials returns a user's credentials in registration order.
+func (s *SQLiteStore) loadCredentials(userID []byte) ([]CredentialRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM credentials WHERE user_id = ? ORDER BY position`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []CredentialRecord{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var cred CredentialRecord
+		if err := json.Unmarshal([]byte(data), &cred); err != nil {
+			return nil, fmt.Errorf("decode credential: %w", err)
+		}
+		credentials = append(credentials, cred)
+	}
+
+	return credentials, rows.Err()
+}
+
+// UpdateUser saves the user's display name. Credentials are not touched;
+// see AddCredential and UpdateCredential.
+func (s *SQLiteStore) UpdateUser(user *User) error {
+	result, err := s.db.Exec(`UPDATE users SET display_name = ? WHERE id = ?`, user.DisplayName, user.ID)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// AddCredential stores a newly registered credential after the user's
+// existing ones, returning ErrCredentialExists if the ID is already stored.
+func (s *SQLiteStore) AddCredential(userID []byte, credential CredentialRecord) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("encode credential: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("add credential: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists); err != nil {
+		return fmt.Errorf("add credential: %w", err)
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO credentials (id, user_id, position, data)
+		 SELECT ?, ?, COALESCE(MAX(position) + 1, 0), ? FROM credentials WHERE user_id = ?
+		 ON CONFLICT(id) DO NOTHING`,
+		credential.ID, userID, string(data), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("add credential: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrCredentialExists
+	}
+
+	return tx.Commit()
+}
+
+// UpdateCredential reads one credential, applies update and writes it back
+// in a single transaction, returning ErrCredentialNotFound if the credential
+// has been deleted meanwhile.
+func (s *SQLiteStore) UpdateCredential(userID, credentialID []byte, update func(*CredentialRecord)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("update credential: %w", err)
+	}
+	defer tx.Rollback()
+
+	var data string
+	err = tx.QueryRow(`SELECT data FROM credentials WHERE id = ? AND user_id = ?`, credentialID, userID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCredentialNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("update credential: %w", err)
+	}
+
+	var cred CredentialRecord
+	if err := json.Unmarshal([]byte(data), &cred); err != nil {
+		return fmt.Errorf("decode credential: %w", err)
+	}
+	update(&cred)
+	encoded, err := json.Marshal(cred)
+	if err != nil {
+		return fmt.Errorf("encode credential: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE credentials SET data = ? WHERE id = ? AND user_id = ?`, string(encoded), credentialID, userID); err != nil {
+		return fmt.Errorf("update credential: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// writeCredentials inserts the credentials of a newly created user in order.
+func writeCredentials(tx *sql.Tx, user *User) error {
+	// Skip duplicate IDs instead of failing on the primary key
+	for i, cred := range removeDuplicateCredentials(user.Credentials) {
+		data, err := json.Marshal(cred)
+		if err != nil {
+			return fmt.Errorf("encode credential: %w", err)
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO credentials (id, user_id, position, data) VALUES (?, ?, ?, ?)`,
+			cred.ID, user.ID, i, string(data),
+		); err != nil {
+			return fmt.Errorf("write credentials: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserPasskey removes a specific credential from user. Removing the
+// user's only credential fails with ErrLastCredential unless allowLast is
+// set; the count is part of the DELETE, so concurrent deletions cannot both
+// pass it.
+func (s *SQLiteStore) DeleteUserPasskey(username string, credentialID []byte, allowLast bool) error {
+	user, exists := s.GetUser(username)
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	result, err := s.db.Exec(`
+		DELETE FROM credentials
+		WHERE id = ? AND user_id = ?
+		  AND (? OR (SELECT COUNT(*) FROM credentials WHERE user_id = ?) > 1)`,
+		credentialID, user.ID, allowLast, user.ID)
+	if err != nil {
+		return fmt.Errorf("delete credential: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	var found int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM credentials WHERE id = ? AND user_id = ?`, credentialID, user.ID).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("delete credential: %w", err)
+	}
+	if found > 0 {
+		return ErrLastCredential
+	}
+	return ErrCredentialNotFound
+}
+
+// RenameUserPasskey sets the nickname stored in a credential record.
+func (s *SQLiteStore) RenameUserPasskey(username string, credentialID []byte, nickname string) error {
+	user, exists := s.GetUser(username)
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	return s.UpdateCredential(user.ID, credentialID, func(record *CredentialRecord) {
+		record.Nickname = nickname
+	})
+}
+
+// GetUserPasskeys returns passkey info for frontend
+func (s *SQLiteStore) GetUserPasskeys(username string) ([]PasskeyInfo, error) {
+	user, exists := s.GetUser(username)
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	return newPasskeyInfos(user), nil
+}
+
+// StoreSession saves WebAuthn ceremony state under sessionID.
+func (s *SQLiteStore) StoreSession(sessionID string, session *Session) error {
+	data, err := json.Marshal(session.SessionData)
+	if err != nil {
+		return fmt.Errorf("encode session: %w", err)
+	}
+
+	var pendingUser sql.NullString
+	if session.PendingUser != nil {
+		encoded, err := json.Marshal(session.PendingUser)
+		if err != nil {
+			return fmt.Errorf("encode pending user: %w", err)
+		}
+		pendingUser = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	var transaction sql.NullString
+	if session.Transaction != nil {
+		encoded, err := json.Marshal(session.Transaction)
+		if err != nil {
+			return fmt.Errorf("encode pending transaction: %w", err)
+		}
+		transaction = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	_, err = s.db.Exec(
+		`INSERT OR REPLACE INTO sessions (id, user_id, ceremony, pending_user, step_up_from, pending_transaction, data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, session.UserID, session.Ceremony, pendingUser, session.StepUpFrom, transaction, string(data), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("store session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSession returns an unexpired WebAuthn ceremony session.
+func (s *SQLiteStore) GetSession(sessionID string) (*Session, bool) {
+	var data string
+	var pendingUser, transaction sql.NullString
+	session := &Session{}
+	err := s.db.QueryRow(
+		`SELECT user_id, ceremony, pending_user, step_up_from, pending_transaction, data, created_at FROM sessions WHERE id = ?`, sessionID,
+	).Scan(&session.UserID, &session.Ceremony, &pendingUser, &session.StepUpFrom, &transaction, &data, &session.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("sqlite: load session: %v", err)
+		return nil, false
+	}
+
+	if time.Since(session.CreatedAt) > sessionTTL {
+		s.DeleteSession(sessionID)
+		return nil, false
+	}
+
+	if err := json.Unmarshal([]byte(data), &session.SessionData); err != nil {
+		logger.Errorf("sqlite: decode session: %v", err)
+		return nil, false
+	}
+	if pendingUser.Valid {
+		session.PendingUser = &User{}
+		if err := json.Unmarshal([]byte(pendingUser.String), session.PendingUser); err != nil {
+			logger.Errorf("sqlite: decode pending user: %v", err)
+			return nil, false
+		}
+	}
+	if transaction.Valid {
+		session.Transaction = &PendingTransaction{}
+		if err := json.Unmarshal([]byte(transaction.String), session.Transaction); err != nil {
+			logger.Errorf("sqlite: decode pending transaction: %v", err)
+			return nil, false
+		}
+	}
+
+	return session, true
+}
+
+// DeleteSession removes a WebAuthn ceremony session.
+func (s *SQLiteStore) DeleteSession(sessionID string) {
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID); err != nil {
+		logger.Errorf("sqlite: delete session: %v", err)
+	}
+}
+
+// CreateLoginSession saves a new authenticated session.
+func (s *SQLiteStore) CreateLoginSession(session *LoginSession) error {
+	_, err := s.db.Exec(
+		`INSERT INTO login_sessions (id, user_id, created_at, last_seen_at, expires_at, ip, user_agent, credential_id, verified_at, recovery)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC(),
+		session.IP, session.UserAgent, session.CredentialID, session.VerifiedAt.UTC(), session.Recovery,
+	)
+	if err != nil {
+		return fmt.Errorf("create login session: %w", err)
+	}
+
+	return nil
+}
+
+// GetLoginSession returns the login session with the given token hash.
+//
+// Expiry is checked by the caller via LoginSession.Expired so both stores
+// apply the same rules.
+func (s *SQLiteStore) GetLoginSession(id string) (*LoginSession, bool) {
+	session := &LoginSession{}
+	err := s.db.QueryRow(
+		`SELECT id, user_id, created_at, last_seen_at, expires_at, ip, user_agent, credential_id, verified_at, recovery
+		 FROM login_sessions WHERE id = ?`, id,
+	).Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		&session.IP, &session.UserAgent, &session.CredentialID, &session.VerifiedAt, &session.Recovery)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("sqlite: load login session: %v", err)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// TouchLoginSession records activity on a login session.
+func (s *SQLiteStore) TouchLoginSession(id string, lastSeen time.Time) error {
+	result, err := s.db.Exec(`UPDATE login_sessions SET last_seen_at = ? WHERE id = ?`, lastSeen.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("touch login session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+// VerifyLoginSession records fresh user verification on a login session.
+func (s *SQLiteStore) VerifyLoginSession(id string, credentialID []byte, verifiedAt time.Time) error {
+	result, err := s.db.Exec(`UPDATE login_sessions SET credential_id = ?, verified_at = ? WHERE id = ?`,
+		credentialID, verifiedAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("verify login session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+// DeleteLoginSession revokes a login session.
+func (s *SQLiteStore) DeleteLoginSession(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM login_sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete login session: %w", err)
+	}
+
+	return nil
+}
+
+// CleanupExpiredSessions removes ceremony sessions older than sessionTTL and
+// login sessions past their absolute or idle expiry.
+func (s *SQLiteStore) CleanupExpiredSessions() {
+	now := time.Now().UTC()
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE created_at < ?`, now.Add(-sessionTTL)); err != nil {
+		logger.Errorf("sqlite: cleanup sessions: %v", err)
+	}
+	if _, err := s.db.Exec(
+		`DELETE FROM login_sessions WHERE expires_at < ? OR last_seen_at < ?`,
+		now, now.Add(-loginSessionIdleTimeout),
+	); err != nil {
+		logger.Errorf("sqlite: cleanup login sessions: %v", err)
+	}
+}
+
+// SessionCounts returns the number of unexpired ceremony and login sessions.
+func (s *SQLiteStore) SessionCounts() (ceremony, login int, err error) {
+	now := time.Now().UTC()
+	if err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM sessions WHERE created_at >= ?`, now.Add(-sessionTTL),
+	).Scan(&ceremony); err != nil {
+		return 0, 0, fmt.Errorf("count sessions: %w", err)
+	}
+	if err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM login_sessions WHERE expires_at >= ? AND last_seen_at >= ?`,
+		now, now.Add(-loginSessionIdleTimeout),
+	).Scan(&login); err != nil {
+		return 0, 0, fmt.Errorf("count login sessions: %w", err)
+	}
+	return ceremony, login, nil
+}
+
+// AppendAuditEvent adds an event to the end of the audit log.
+//
+// Reading the previous hash and inserting happen in one transaction, which
+// the single pooled connection serializes with every other append.
+func (s *SQLiteStore) AppendAuditEvent(event *AuditEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("append audit event: %w", err)
+	}
+	defer tx.Rollback()
+
+	var prevID int64
+	var prevHash string
+	err = tx.QueryRow(`SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevID, &prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("append audit event: %w", err)
+	}
+
+	event.seal(prevID, prevHash)
+	if _, err := tx.Exec(
+		`INSERT INTO audit_events (id, time, type, actor, username, credential_id, ip, user_agent, outcome, detail, prev_hash, hash)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, event.Time, event.Type, event.Actor, event.Username, event.CredentialID,
+		event.IP, event.UserAgent, event.Outcome, event.Detail, event.PrevHash, event.Hash,
+	); err != nil {
+		return fmt.Errorf("append audit event: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ListAuditEvents returns the audit events matching filter, newest first.
+func (s *SQLiteStore) ListAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var where []string
+	var args []interface{}
+	if len(filter.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(filter.Types)-1)+")")
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+	conditions := []struct {
+		set   bool
+		sql   string
+		value interface{}
+	}{
+		{filter.Username != "", "username = ?", filter.Username},
+		{filter.CredentialID != "", "credential_id = ?", filter.CredentialID},
+		{filter.Outcome != "", "outcome = ?", filter.Outcome},
+		{!filter.Since.IsZero(), "time >= ?", filter.Since.UTC()},
+		{!filter.Until.IsZero(), "time < ?", filter.Until.UTC()},
+		{filter.BeforeID > 0, "id < ?", filter.BeforeID},
+	}
+	for _, c := range conditions {
+		if c.set {
+			where = append(where, c.sql)
+			args = append(args, c.value)
+		}
+	}
+
+	query := `SELECT id, time, type, actor, username, credential_id, ip, user_agent, outcome, detail, prev_hash, hash
+		FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.Scan(&e.ID, &e.Time, &e.Type, &e.Actor, &e.Username, &e.CredentialID,
+			&e.IP, &e.UserAgent, &e.Outcome, &e.Detail, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("list audit events: %w", err)
+		}
+		e.Time = e.Time.UTC()
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// RecordLoginFailure counts a failed assertion for key in a single
+// upsert, so concurrent failures are never lost.
+func (s *SQLiteStore) RecordLoginFailure(key string, at, resetBefore time.Time) (*LoginFailures, error) {
+	failures := &LoginFailures{Key: key}
+	err := s.db.QueryRow(
+		`INSERT INTO login_failures (subject, count, last_at) VALUES (?, 1, ?)
+		 ON CONFLICT(subject) DO UPDATE SET
+		   count = CASE WHEN last_at < ? THEN 1 ELSE count + 1 END,
+		   last_at = excluded.last_at
+		 RETURNING count, last_at`,
+		key, at.UTC(), resetBefore.UTC(),
+	).Scan(&failures.Count, &failures.LastAt)
+	if err != nil {
+		return nil, fmt.Errorf("record login failure: %w", err)
+	}
+
+	return failures, nil
+}
+
+// GetLoginFailures returns the failure run for key.
+func (s *SQLiteStore) GetLoginFailures(key string) (*LoginFailures, bool) {
+	failures := &LoginFailures{Key: key}
+	err := s.db.QueryRow(
+		`SELECT count, last_at FROM login_failures WHERE subject = ?`, key,
+	).Scan(&failures.Count, &failures.LastAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false
+	}
+	if err != nil {
+		logger.Errorf("sqlite: load login failures: %v", err)
+		return nil, false
+	}
+
+	return failures, true
+}
+
+// ClearLoginFailures forgets the failure runs for keys.
+func (s *SQLiteStore) ClearLoginFailures(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	if _, err := s.db.Exec(`DELETE FROM login_failures WHERE subject IN (`+placeholders+`)`, args...); err != nil {
+		return fmt.Errorf("clear login failures: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery code hashes for a new set.
+func (s *SQLiteStore) ReplaceRecoveryCodes(userID []byte, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("replace recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("replace recovery codes: %w", err)
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return fmt.Errorf("replace recovery codes: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRecoveryCodes returns the user's unused recovery code hashes.
+func (s *SQLiteStore) GetRecoveryCodes(userID []byte) ([]string, error) {
+	rows, err := s.db.Query(`SELECT hash FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load recovery codes: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("load recovery codes: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// UseRecoveryCode deletes one recovery code hash, so it cannot be used
+// again. Deleting is the check: of two concurrent uses only one succeeds.
+func (s *SQLiteStore) UseRecoveryCode(userID []byte, hash string) error {
+	result, err := s.db.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidRecoveryCode
+	}
+
+	return nil
+}
+
+// Ping checks that the database file can still be queried.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close checkpoints the write-ahead log into the database file and closes
+// it, so the .db file is complete on its own after a clean shutdown.
+func (s *SQLiteStore) Close() error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		logger.Errorf("sqlite: checkpoint: %v", err)
+	}
+	return s.db.Close()
+}
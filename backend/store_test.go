@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// storeBackends lists every Store implementation. Each conformance test
+// runs against all of them, so the backends cannot drift apart.
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewInMemoryStore() }},
+	{"sqlite", func(t *testing.T) Store {
+		store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteStore: %v", err)
+		}
+		return store
+	}},
+}
+
+// forEachStore runs test as a subtest against a fresh store of every
+// backend.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Helper()
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			t.Cleanup(func() { store.Close() })
+			test(t, store)
+		})
+	}
+}
+
+// testCredential returns a credential record with the given ID.
+func testCredential(id string) CredentialRecord {
+	return CredentialRecord{
+		Credential: webauthn.Credential{ID: []byte(id), PublicKey: []byte("key-" + id)},
+		CreatedAt:  time.Now(),
+	}
+}
+
+// testUser returns an unsaved user with one credential per ID.
+func testUser(username string, credentialIDs ...string) *User {
+	user := NewUser(username, username+" display")
+	for _, id := range credentialIDs {
+		user.Credentials = append(user.Credentials, testCredential(id))
+	}
+	return user
+}
+
+// createTestUser stores a testUser and fails the test on error.
+func createTestUser(t *testing.T, store Store, username string, credentialIDs ...string) *User {
+	t.Helper()
+	user := testUser(username, credentialIDs...)
+	if err := store.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return user
+}
+
+// credentialIDs lists a user's credential IDs in stored order.
+func credentialIDs(user *User) []string {
+	ids := make([]string, len(user.Credentials))
+	for i, cred := range user.Credentials {
+		ids[i] = string(cred.ID)
+	}
+	return ids
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		alice := createTestUser(t, store, "alice", "cred-1")
+
+		if err := store.CreateUser(testUser("alice")); err != ErrUserExists {
+			t.Errorf("CreateUser(duplicate) = %v, want ErrUserExists", err)
+		}
+
+		// The store keeps its own copy of the created user
+		alice.DisplayName = "changed after create"
+
+		got, ok := store.GetUser("alice")
+		if !ok {
+			t.Fatal("GetUser(alice) not found")
+		}
+		if string(got.ID) != string(alice.ID) || got.DisplayName != "alice display" || len(got.Credentials) != 1 {
+			t.Errorf("GetUser(alice) = %+v", got)
+		}
+
+		byID, ok := store.GetUserByID(alice.ID)
+		if !ok || byID.Username != "alice" {
+			t.Errorf("GetUserByID = %v, %v", byID, ok)
+		}
+		if _, ok := store.GetUser("bob"); ok {
+			t.Error("GetUser(bob) found a user that was never created")
+		}
+		if _, ok := store.GetUserByID([]byte("missing")); ok {
+			t.Error("GetUserByID(missing) found a user")
+		}
+
+		got.DisplayName = "Alice Smith"
+		if err := store.UpdateUser(got); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if updated, _ := store.GetUserByID(alice.ID); updated.DisplayName != "Alice Smith" {
+			t.Errorf("after UpdateUser: display name %q", updated.DisplayName)
+		}
+		if err := store.UpdateUser(&User{ID: []byte("missing")}); err != ErrUserNotFound {
+			t.Errorf("UpdateUser(unknown) = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestStoreReturnsCopies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		createTestUser(t, store, "alice", "cred-1")
+
+		got, _ := store.GetUser("alice")
+		got.DisplayName = "Mallory"
+		got.Credentials[0].Authenticator.SignCount = 99
+		got.Credentials[0].ID[0] = 'X'
+		got.Credentials = append(got.Credentials, testCredential("cred-2"))
+
+		stored, _ := store.GetUser("alice")
+		if stored.DisplayName != "alice display" {
+			t.Errorf("display name = %q, editing a returned user changed the store", stored.DisplayName)
+		}
+		if ids := credentialIDs(stored); len(ids) != 1 || ids[0] != "cred-1" {
+			t.Errorf("credentials = %v, editing a returned user changed the store", ids)
+		}
+		if stored.Credentials[0].Authenticator.SignCount != 0 {
+			t.Errorf("sign count = %d, editing a returned user changed the store", stored.Credentials[0].Authenticator.SignCount)
+		}
+	})
+}
+
+func TestStoreCredentials(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		alice := createTestUser(t, store, "alice", "cred-1", "cred-2")
+
+		if err := store.AddCredential(alice.ID, testCredential("cred-3")); err != nil {
+			t.Fatalf("AddCredential: %v", err)
+		}
+		if err := store.AddCredential(alice.ID, testCredential("cred-1")); err != ErrCredentialExists {
+			t.Errorf("AddCredential(duplicate) = %v, want ErrCredentialExists", err)
+		}
+		if err := store.AddCredential([]byte("missing"), testCredential("cred-4")); err != ErrUserNotFound {
+			t.Errorf("AddCredential(unknown user) = %v, want ErrUserNotFound", err)
+		}
+
+		err := store.UpdateCredential(alice.ID, []byte("cred-1"), func(cred *CredentialRecord) {
+			cred.Authenticator.SignCount = 7
+		})
+		if err != nil {
+			t.Fatalf("UpdateCredential: %v", err)
+		}
+		if err := store.UpdateCredential(alice.ID, []byte("nope"), func(*CredentialRecord) {}); err != ErrCredentialNotFound {
+			t.Errorf("UpdateCredential(unknown) = %v, want ErrCredentialNotFound", err)
+		}
+
+		if err := store.RenameUserPasskey("alice", []byte("cred-1"), "Work laptop"); err != nil {
+			t.Fatalf("RenameUserPasskey: %v", err)
+		}
+		if err := store.RenameUserPasskey("alice", []byte("nope"), "x"); err != ErrCredentialNotFound {
+			t.Errorf("RenameUserPasskey(unknown) = %v, want ErrCredentialNotFound", err)
+		}
+		if err := store.RenameUserPasskey("bob", []byte("cred-1"), "x"); err != ErrUserNotFound {
+			t.Errorf("RenameUserPasskey(unknown user) = %v, want ErrUserNotFound", err)
+		}
+
+		passkeys, err := store.GetUserPasskeys("alice")
+		if err != nil {
+			t.Fatalf("GetUserPasskeys: %v", err)
+		}
+		if len(passkeys) != 3 || passkeys[0].ID != encodeCredentialID([]byte("cred-1")) || passkeys[0].SignCount != 7 ||
+			passkeys[0].Name != "Work laptop" || passkeys[2].ID != encodeCredentialID([]byte("cred-3")) {
+			t.Errorf("GetUserPasskeys = %+v", passkeys)
+		}
+
+		for _, id := range []string{"cred-2", "cred-3"} {
+			if err := store.DeleteUserPasskey("alice", []byte(id), false); err != nil {
+				t.Fatalf("DeleteUserPasskey(%s): %v", id, err)
+			}
+		}
+		if err := store.DeleteUserPasskey("alice", []byte("cred-2"), false); err != ErrCredentialNotFound {
+			t.Errorf("DeleteUserPasskey(again) = %v, want ErrCredentialNotFound", err)
+		}
+		if err := store.DeleteUserPasskey("bob", []byte("cred-1"), false); err != ErrUserNotFound {
+			t.Errorf("DeleteUserPasskey(unknown user) = %v, want ErrUserNotFound", err)
+		}
+		if _, err := store.GetUserPasskeys("bob"); err != ErrUserNotFound {
+			t.Errorf("GetUserPasskeys(unknown user) = %v, want ErrUserNotFound", err)
+		}
+
+		user, _ := store.GetUser("alice")
+		if ids := credentialIDs(user); len(ids) != 1 || ids[0] != "cred-1" || user.Credentials[0].Nickname != "Work laptop" {
+			t.Errorf("after delete: %v", ids)
+		}
+	})
+}
+
+func TestStoreStaleUpdateKeepsDeletedCredential(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		createTestUser(t, store, "alice", "cred-1", "cred-2")
+
+		stale, _ := store.GetUser("alice")
+		if err := store.DeleteUserPasskey("alice", []byte("cred-2"), false); err != nil {
+			t.Fatalf("DeleteUserPasskey: %v", err)
+		}
+
+		stale.DisplayName = "Alice Smith"
+		if err := store.UpdateUser(stale); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		err := store.UpdateCredential(stale.ID, []byte("cred-2"), func(cred *CredentialRecord) {
+			cred.Authenticator.SignCount = 3
+		})
+		if err != ErrCredentialNotFound {
+			t.Errorf("UpdateCredential(deleted) = %v, want ErrCredentialNotFound", err)
+		}
+
+		user, _ := store.GetUser("alice")
+		if ids := credentialIDs(user); len(ids) != 1 || ids[0] != "cred-1" {
+			t.Errorf("credentials = %v, a stale update resurrected the deleted passkey", ids)
+		}
+		if user.DisplayName != "Alice Smith" {
+			t.Errorf("display name = %q, want the updated one", user.DisplayName)
+		}
+	})
+}
+
+func TestStoreDeleteLastPasskey(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		createTestUser(t, store, "alice", "cred-1")
+		if err := store.DeleteUserPasskey("alice", []byte("cred-1"), false); err != ErrLastCredential {
+			t.Errorf("DeleteUserPasskey(last) = %v, want ErrLastCredential", err)
+		}
+		if err := store.DeleteUserPasskey("alice", []byte("nope"), false); err != ErrCredentialNotFound {
+			t.Errorf("DeleteUserPasskey(unknown) = %v, want ErrCredentialNotFound", err)
+		}
+		if err := store.DeleteUserPasskey("alice", []byte("cred-1"), true); err != nil {
+			t.Errorf("DeleteUserPasskey(last, allowed) = %v", err)
+		}
+
+		// Concurrent deletions of both passkeys leave one behind
+		createTestUser(t, store, "bob", "cred-a", "cred-b")
+		errs := make(chan error, 2)
+		for _, id := range []string{"cred-a", "cred-b"} {
+			go func() { errs <- store.DeleteUserPasskey("bob", []byte(id), false) }()
+		}
+		var refused int
+		for range 2 {
+			switch err := <-errs; err {
+			case nil:
+			case ErrLastCredential:
+				refused++
+			default:
+				t.Errorf("DeleteUserPasskey: %v", err)
+			}
+		}
+		bob, _ := store.GetUser("bob")
+		if refused != 1 || len(bob.Credentials) != 1 {
+			t.Errorf("%d deletions refused, %d credentials left; want 1 and 1", refused, len(bob.Credentials))
+		}
+	})
+}
+
+// TestStoreDeleteRacesLoginUpdate races the deletions of both passkeys
+// against the sign count update a login makes for one of them: the account
+// keeps exactly one passkey, and a deleted one never comes back.
+func TestStoreDeleteRacesLoginUpdate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		for i := range 20 {
+			username := fmt.Sprintf("user-%d", i)
+			credA, credB := fmt.Sprintf("cred-%d-a", i), fmt.Sprintf("cred-%d-b", i)
+			user := createTestUser(t, store, username, credA, credB)
+
+			var wg sync.WaitGroup
+			deleted := make([]error, 2)
+			var updated error
+			for j, id := range []string{credA, credB} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					deleted[j] = store.DeleteUserPasskey(username, []byte(id), false)
+				}()
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				updated = store.UpdateCredential(user.ID, []byte(credA), func(cred *CredentialRecord) {
+					cred.Authenticator.SignCount++
+					cred.UseCount++
+				})
+			}()
+			wg.Wait()
+
+			stored, _ := store.GetUser(username)
+			ids := credentialIDs(stored)
+			if len(ids) != 1 {
+				t.Fatalf("%s: credentials %v, want exactly one left", username, ids)
+			}
+			for j, id := range []string{credA, credB} {
+				if deleted[j] == nil && ids[0] == id {
+					t.Errorf("%s: %s was deleted but is still stored", username, id)
+				}
+				if deleted[j] != nil && deleted[j] != ErrLastCredential {
+					t.Errorf("%s: DeleteUserPasskey(%s) = %v", username, id, deleted[j])
+				}
+			}
+			if updated != nil && updated != ErrCredentialNotFound {
+				t.Errorf("%s: UpdateCredential = %v", username, updated)
+			}
+			if updated == ErrCredentialNotFound && ids[0] == credA {
+				t.Errorf("%s: update missed a credential that was kept", username)
+			}
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		session := &Session{
+			UserID:      []byte("user-1"),
+			Ceremony:    ceremonyRegister,
+			PendingUser: testUser("carol", "cred-1"),
+			SessionData: webauthn.SessionData{Challenge: "challenge-1", UserID: []byte("user-1")},
+		}
+		if err := store.StoreSession("s1", session); err != nil {
+			t.Fatalf("StoreSession: %v", err)
+		}
+
+		got, ok := store.GetSession("s1")
+		if !ok {
+			t.Fatal("GetSession(s1) not found")
+		}
+		if got.Ceremony != ceremonyRegister || got.SessionData.Challenge != "challenge-1" || string(got.UserID) != "user-1" ||
+			got.PendingUser == nil || got.PendingUser.Username != "carol" || len(got.PendingUser.Credentials) != 1 {
+			t.Errorf("GetSession(s1) = %+v", got)
+		}
+
+		store.DeleteSession("s1")
+		if _, ok := store.GetSession("s1"); ok {
+			t.Error("GetSession found a deleted session")
+		}
+		if _, ok := store.GetSession("never"); ok {
+			t.Error("GetSession found a session that was never stored")
+		}
+	})
+}
+
+func TestStoreSessionExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if err := store.StoreSession("s1", &Session{Ceremony: ceremonyLogin}); err != nil {
+			t.Fatalf("StoreSession: %v", err)
+		}
+
+		ttl := sessionTTL
+		sessionTTL = 0
+		t.Cleanup(func() { sessionTTL = ttl })
+
+		if _, ok := store.GetSession("s1"); ok {
+			t.Error("GetSession returned a session older than sessionTTL")
+		}
+	})
+}
+
+func TestStoreLoginSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		alice := createTestUser(t, store, "alice", "cred-1")
+		now := time.Now().Truncate(time.Second)
+		session := &LoginSession{
+			ID:           "token-hash",
+			UserID:       alice.ID,
+			CreatedAt:    now,
+			LastSeenAt:   now,
+			ExpiresAt:    now.Add(time.Hour),
+			IP:           "192.0.2.1",
+			UserAgent:    "test",
+			CredentialID: []byte("cred-1"),
+		}
+		if err := store.CreateLoginSession(session); err != nil {
+			t.Fatalf("CreateLoginSession: %v", err)
+		}
+
+		got, ok := store.GetLoginSession("token-hash")
+		if !ok {
+			t.Fatal("GetLoginSession not found")
+		}
+		if string(got.UserID) != string(alice.ID) || got.IP != "192.0.2.1" || string(got.CredentialID) != "cred-1" || !got.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("GetLoginSession = %+v", got)
+		}
+
+		later := now.Add(time.Minute)
+		if err := store.TouchLoginSession("token-hash", later); err != nil {
+			t.Fatalf("TouchLoginSession: %v", err)
+		}
+		if got, _ := store.GetLoginSession("token-hash"); !got.LastSeenAt.Equal(later) {
+			t.Errorf("LastSeenAt = %v, want %v", got.LastSeenAt, later)
+		}
+		if err := store.TouchLoginSession("missing", later); err != ErrInvalidSession {
+			t.Errorf("TouchLoginSession(missing) = %v, want ErrInvalidSession", err)
+		}
+
+		// Reauthentication records the passkey used and when
+		if err := store.VerifyLoginSession("token-hash", []byte("cred-2"), later); err != nil {
+			t.Fatalf("VerifyLoginSession: %v", err)
+		}
+		if got, _ := store.GetLoginSession("token-hash"); !got.VerifiedAt.Equal(later) || string(got.CredentialID) != "cred-2" {
+			t.Errorf("after VerifyLoginSession: verified %v with %q", got.VerifiedAt, got.CredentialID)
+		}
+		if err := store.VerifyLoginSession("missing", []byte("cred-2"), later); err != ErrInvalidSession {
+			t.Errorf("VerifyLoginSession(missing) = %v, want ErrInvalidSession", err)
+		}
+
+		expired := *session
+		expired.ID = "expired"
+		expired.ExpiresAt = now.Add(-time.Minute)
+		if err := store.CreateLoginSession(&expired); err != nil {
+			t.Fatalf("CreateLoginSession: %v", err)
+		}
+		store.CleanupExpiredSessions()
+		if _, ok := store.GetLoginSession("expired"); ok {
+			t.Error("CleanupExpiredSessions kept an expired login session")
+		}
+		if _, ok := store.GetLoginSession("token-hash"); !ok {
+			t.Error("CleanupExpiredSessions removed a live login session")
+		}
+
+		if err := store.DeleteLoginSession("token-hash"); err != nil {
+			t.Fatalf("DeleteLoginSession: %v", err)
+		}
+		if _, ok := store.GetLoginSession("token-hash"); ok {
+			t.Error("GetLoginSession found a deleted session")
+		}
+	})
+}
+
+func TestLoginSessionExpired(t *testing.T) {
+	now := time.Now()
+	session := &LoginSession{LastSeenAt: now, ExpiresAt: now.Add(loginSessionLifetime)}
+
+	if session.Expired(now.Add(time.Minute)) {
+		t.Error("fresh session reported expired")
+	}
+	if !session.Expired(now.Add(loginSessionIdleTimeout + time.Minute)) {
+		t.Error("idle session not reported expired")
+	}
+	session.LastSeenAt = now.Add(loginSessionLifetime)
+	if !session.Expired(now.Add(loginSessionLifetime + time.Second)) {
+		t.Error("session past its absolute lifetime not reported expired")
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Development certificate files written to TLSConfig.Dir.
+const (
+	devCAFile      = "ca.pem"     // Root to trust on test devices
+	devCAKeyFile   = "ca-key.pem" // Never leaves this machine
+	devCertFile    = "cert.pem"   // Leaf served by the backend
+	devCertKeyFile = "key.pem"
+)
+
+// Development certificate lifetimes. The leaf stays within the 398 days
+// Apple platforms accept and is reissued a month before it runs out.
+const (
+	devCALifetime   = 10 * 365 * 24 * time.Hour
+	devCertLifetime = 397 * 24 * time.Hour
+	devCertRenewal  = 30 * 24 * time.Hour
+)
+
+// serverTLSConfig returns the TLS settings for the HTTPS listener.
+//
+// A configured cert/key pair is used as-is. Otherwise a local development CA
+// is created in c.Dir on first use, and a leaf certificate signed by it is
+// (re)issued whenever the existing one is missing, about to expire, or does
+// not cover every name in devCertHosts. Trusting ca.pem once on a phone or
+// laptop is then enough for every later leaf.
+func (c TLSConfig) serverTLSConfig(rpID string) (*tls.Config, error) {
+	certFile, keyFile := c.Cert, c.Key
+	if certFile == "" {
+		var err error
+		certFile, keyFile, err = c.ensureDevCertificate(devCertHosts(rpID, c.Hosts))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// devCertHosts lists the names the development certificate must cover: the
+// RP ID, localhost, this machine's hostname (plain and .local for Bonjour),
+// its LAN addresses and any extra configured hosts.
+func devCertHosts(rpID string, extra []string) []string {
+	hosts := []string{rpID, "localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hostname = strings.ToLower(strings.TrimSuffix(hostname, ".local"))
+		hosts = append(hosts, hostname, hostname+".local")
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsPrivate() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	hosts = append(hosts, extra...)
+
+	// Keep the RP ID first (it becomes the common name) and drop repeats
+	var unique []string
+	for _, host := range hosts {
+		if !slices.Contains(unique, host) {
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// ensureDevCertificate makes sure c.Dir holds a development CA and a leaf
+// certificate valid for hosts, returning the leaf's cert and key paths.
+func (c TLSConfig) ensureDevCertificate(hosts []string) (certFile, keyFile string, err error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("create certificate directory: %w", err)
+	}
+
+	caCert, caKey, err := loadOrCreateDevCA(filepath.Join(c.Dir, devCAFile), filepath.Join(c.Dir, devCAKeyFile))
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(c.Dir, devCertFile)
+	keyFile = filepath.Join(c.Dir, devCertKeyFile)
+	if leaf, err := readCertificate(certFile); err == nil && devCertCurrent(leaf, caCert, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	logger.Printf("🔏 Issuing development certificate for %s", strings.Join(hosts, ", "))
+	if err := issueDevCertificate(caCert, caKey, hosts, certFile, keyFile); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// devCertCurrent reports whether leaf was signed by ca, is not close to
+// expiry and covers every host.
+func devCertCurrent(leaf, ca *x509.Certificate, hosts []string) bool {
+	if leaf.CheckSignatureFrom(ca) != nil || time.Now().Add(devCertRenewal).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadOrCreateDevCA reads the development CA, generating it on first use.
+func loadOrCreateDevCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, certErr := readCertificate(certFile)
+	key, keyErr := readECKey(keyFile)
+	if certErr == nil && keyErr == nil {
+		return cert, key, nil
+	}
+	if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("load development CA from %s: %w", filepath.Dir(certFile), errors.Join(certErr, keyErr))
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate CA key: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject: pkix.Name{
+			Organization: []string{"Passkey Demo"},
+			CommonName:   "Passkey Demo Development CA (" + hostname + ")",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create CA certificate: %w", err)
+	}
+	if err := writePEMFiles(certFile, der, keyFile, key); err != nil {
+		return nil, nil, err
+	}
+
+	logger.Printf("🔏 Created development CA %s; trust it on test devices to avoid certificate warnings", certFile)
+	cert, err = x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// issueDevCertificate signs a server certificate for hosts with the CA.
+func issueDevCertificate(ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate certificate key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject: pkix.Name{
+			Organization: []string{"Passkey Demo"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(devCertLifetime),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("create certificate: %w", err)
+	}
+	return writePEMFiles(certFile, der, keyFile, key)
+}
+
+// newSerialNumber returns a random 128-bit certificate serial number.
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(fmt.Sprintf("generate serial number: %v", err))
+	}
+	return serial
+}
+
+// writePEMFiles writes a certificate and its private key, the key readable
+// only by the current user.
+func writePEMFiles(certFile string, der []byte, keyFile string, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encode private key: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return fmt.Errorf("write private key: %w", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return fmt.Errorf("write certificate: %w", err)
+	}
+	return nil
+}
+
+// readCertificate parses the first PEM certificate in path.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// readECKey parses a PEM EC private key written by writePEMFiles.
+func readECKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM EC private key", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the same host and path on
+// the HTTPS listener. 308 keeps the method and body, so a POST that reaches
+// the wrong port is retried as a POST.
+func httpsRedirectHandler(httpsListen string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsListen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureDevCertificate(t *testing.T) {
+	cfg := TLSConfig{Enabled: true, Dir: filepath.Join(t.TempDir(), "certs")}
+	hosts := []string{"passkey.test", "localhost", "127.0.0.1"}
+
+	certFile, keyFile, err := cfg.ensureDevCertificate(hosts)
+	if err != nil {
+		t.Fatalf("ensureDevCertificate: %v", err)
+	}
+	ca, err := readCertificate(filepath.Join(cfg.Dir, devCAFile))
+	if err != nil {
+		t.Fatalf("read CA: %v", err)
+	}
+	leaf, err := readCertificate(certFile)
+	if err != nil {
+		t.Fatalf("read leaf: %v", err)
+	}
+	if !ca.IsCA || leaf.Subject.CommonName != "passkey.test" || !devCertCurrent(leaf, ca, hosts) {
+		t.Errorf("leaf %v not issued by CA for %v", leaf.Subject, hosts)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "passkey.test", Roots: roots}); err != nil {
+		t.Errorf("leaf does not verify against the CA: %v", err)
+	}
+
+	// A current certificate is reused as-is
+	if _, _, err := cfg.ensureDevCertificate(hosts); err != nil {
+		t.Fatalf("ensureDevCertificate: %v", err)
+	}
+	reused, _ := readCertificate(certFile)
+	if reused.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Error("current certificate was reissued")
+	}
+
+	// A new host reissues the leaf under the same CA
+	hosts = append(hosts, "laptop.local")
+	if _, _, err := cfg.ensureDevCertificate(hosts); err != nil {
+		t.Fatalf("ensureDevCertificate: %v", err)
+	}
+	reissued, _ := readCertificate(certFile)
+	if reissued.SerialNumber.Cmp(leaf.SerialNumber) == 0 || !devCertCurrent(reissued, ca, hosts) {
+		t.Error("certificate not reissued for the new host")
+	}
+	if sameCA, _ := readCertificate(filepath.Join(cfg.Dir, devCAFile)); sameCA.SerialNumber.Cmp(ca.SerialNumber) != 0 {
+		t.Error("development CA was replaced")
+	}
+
+	if _, err := (TLSConfig{Cert: certFile, Key: keyFile}).serverTLSConfig("passkey.test"); err != nil {
+		t.Errorf("serverTLSConfig with the issued pair: %v", err)
+	}
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tls     TLSConfig
+		wantErr bool
+	}{
+		{"disabled", TLSConfig{Cert: "cert.pem"}, false},
+		{"generated", TLSConfig{Enabled: true, Dir: "certs"}, false},
+		{"cert and key", TLSConfig{Enabled: true, Cert: "cert.pem", Key: "key.pem"}, false},
+		{"cert without key", TLSConfig{Enabled: true, Cert: "cert.pem", Dir: "certs"}, true},
+		{"no dir", TLSConfig{Enabled: true}, true},
+		{"redirect on listen", TLSConfig{Enabled: true, Dir: "certs", RedirectListen: ":8443"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.tls.validate(":8443"); (err != nil) != tc.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		listen, host, want string
+	}{
+		{":443", "example.com", "https://example.com/api/login?x=1"},
+		{":8443", "example.com:80", "https://example.com:8443/api/login?x=1"},
+		{":8443", "[::1]", "https://[::1]:8443/api/login?x=1"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/login?x=1", nil)
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		httpsRedirectHandler(tc.listen).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tc.want {
+			t.Errorf("%s via %s: %d %q, want 308 %q", tc.host, tc.listen, rec.Code, rec.Header().Get("Location"), tc.want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// Transaction confirmation lets a signed-in user approve one specific
+// action, such as a payment of an amount to a payee, with a passkey
+// assertion.
+//
+// The assertion's challenge is derived from the hash of the canonical
+// payload (see transactionChallenge), so the authenticator's signature
+// covers exactly what was approved. The result is a TransactionApproval
+// that the caller can store and later check against the server's key.
+const (
+	// transactionMaxPayload bounds the canonical payload, in bytes.
+	transactionMaxPayload = 16 << 10
+
+	// transactionChallengeContext separates transaction challenges from any
+	// other use of SHA-256 over the same bytes.
+	transactionChallengeContext = "passkey-demo transaction v1\x00"
+
+	// approvalSigningContext starts the text signed by the server (see
+	// TransactionApproval.signingInput).
+	approvalSigningContext = "passkey-demo transaction approval v1"
+)
+
+// PendingTransaction is the payload a transaction ceremony was started for,
+// kept in the ceremony Session until finish.
+type PendingTransaction struct {
+	Payload json.RawMessage `json:"payload"` // Canonical JSON (see canonicalPayload)
+	Nonce   []byte          `json:"nonce"`   // Random, so every challenge is unique
+}
+
+// TransactionBeginRequest is the body of POST /api/transactions/begin.
+type TransactionBeginRequest struct {
+	Payload json.RawMessage `json:"payload"` // Any JSON object, e.g. {"amount": "12.50", "payee": "..."}
+}
+
+// TransactionBeginResponse carries the assertion options for
+// navigator.credentials.get() and the hash they were derived from.
+type TransactionBeginResponse struct {
+	Options     *protocol.CredentialAssertion `json:"options"`
+	PayloadHash string                        `json:"payloadHash"` // Hex SHA-256 of the canonical payload
+}
+
+// TransactionApproval records that a user approved a payload with a
+// passkey.
+//
+// It carries the assertion itself, which anyone holding the credential's
+// public key can verify (the challenge is transactionChallenge of
+// PayloadHash and Nonce), and the server's Ed25519 signature over
+// signingInput, which binds user, credential, payload hash and time. The
+// server's public key is published at GET /api/transactions/key.
+type TransactionApproval struct {
+	Version           int             `json:"version"`           // Format version, currently 1
+	Username          string          `json:"username"`          // Approving user
+	UserID            string          `json:"userId"`            // WebAuthn user handle, base64url
+	CredentialID      string          `json:"credentialId"`      // Passkey that signed, base64url
+	Payload           json.RawMessage `json:"payload"`           // Canonical payload
+	PayloadHash       string          `json:"payloadHash"`       // Hex SHA-256 of Payload
+	Nonce             string          `json:"nonce"`             // Challenge nonce, base64url
+	Challenge         string          `json:"challenge"`         // Signed challenge, base64url
+	ApprovedAt        time.Time       `json:"approvedAt"`        // When the server verified the assertion (UTC)
+	UserVerified      bool            `json:"userVerified"`      // Biometric or PIN, not just presence
+	AuthenticatorData string          `json:"authenticatorData"` // Assertion authenticator data, base64url
+	ClientDataJSON    string          `json:"clientDataJSON"`    // Assertion client data, base64url
+	Signature         string          `json:"signature"`         // Passkey signature, base64url
+	KeyID             string          `json:"keyId"`             // Server key that signed (see approvalKeyID)
+	ServerSignature   string          `json:"serverSignature"`   // Ed25519 over signingInput, base64url
+}
+
+// signingInput returns the text the server signs: the context line followed
+// by one field per line. Every field is free of newlines, so the encoding
+// is unambiguous and easy to rebuild outside Go.
+func (a *TransactionApproval) signingInput() []byte {
+	return []byte(strings.Join([]string{
+		approvalSigningContext,
+		a.Username,
+		a.UserID,
+		a.CredentialID,
+		a.PayloadHash,
+		a.Challenge,
+		a.ApprovedAt.UTC().Format(time.RFC3339Nano),
+	}, "\n"))
+}
+
+// canonicalPayload returns the canonical form of a JSON object: object keys
+// sorted, no insignificant whitespace and no HTML escaping. Numbers are kept
+// exactly as written, so clients should send amounts as strings. Objects
+// that repeat a key are refused (see checkDuplicateKeys).
+func canonicalPayload(raw json.RawMessage) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("payload has trailing data")
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("payload must be a JSON object")
+	}
+	if err := checkDuplicateKeys(json.NewDecoder(bytes.NewReader(raw))); err != nil {
+		return nil, err
+	}
+
+	// encoding/json writes map keys in sorted order
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, fmt.Errorf("encode payload: %w", err)
+	}
+	canonical := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if len(canonical) > transactionMaxPayload {
+		return nil, fmt.Errorf("payload is larger than %d bytes", transactionMaxPayload)
+	}
+	return canonical, nil
+}
+
+// checkDuplicateKeys reads one JSON value from dec and fails if any object
+// in it repeats a key. Decoding keeps only the last value of a repeated key,
+// so the approval would cover a different payload than a client that shows
+// the first one displayed.
+func checkDuplicateKeys(dec *json.Decoder) error {
+	token, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	switch token {
+	case json.Delim('{'):
+		seen := map[string]bool{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("payload is not valid JSON: %w", err)
+			}
+			if seen[key.(string)] {
+				return fmt.Errorf("payload repeats key %q", key)
+			}
+			seen[key.(string)] = true
+			if err := checkDuplicateKeys(dec); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for dec.More() {
+			if err := checkDuplicateKeys(dec); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	// The closing delimiter
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	return nil
+}
+
+// transactionChallenge derives the assertion challenge for a payload hash:
+// SHA-256(transactionChallengeContext || payloadHash || nonce).
+func transactionChallenge(payloadHash, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(transactionChallengeContext))
+	h.Write(payloadHash)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// parseTransactionKey decodes security.transactionKey, a base64 Ed25519
+// seed. An empty value returns nil.
+func parseTransactionKey(value string) (ed25519.PrivateKey, error) {
+	if value == "" {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("security.transactionKey must be %d bytes, base64 encoded", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// approvalKeyID names a server key: the first 8 bytes of the SHA-256 of its
+// public key, in hex.
+func approvalKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// handleTransactionBegin starts approving a payload: it canonicalizes and
+// hashes it, and starts an assertion limited to the current user's passkeys
+// whose challenge is derived from the hash.
+//
+// Request: TransactionBeginRequest
+// Response: TransactionBeginResponse
+// HTTP Status: 200 (success), 400 (invalid payload), 401 (not logged in),
+// 403 CREDENTIAL_SUSPENDED or CREDENTIAL_SUSPECT, 423 ACCOUNT_LOCKED
+func (app *App) handleTransactionBegin(w http.ResponseWriter, r *http.Request) {
+	loginSession, ok := getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	user, exists := app.store.GetUserByID(loginSession.UserID)
+	if !exists {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	var req TransactionBeginRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 2*transactionMaxPayload)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.writeError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	payload, err := canonicalPayload(req.Payload)
+	if err != nil {
+		app.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	allowed, ok := app.verificationCredentials(w, user)
+	if !ok {
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to generate nonce: %v", err), http.StatusInternalServerError)
+		return
+	}
+	payloadHash := sha256.Sum256(payload)
+
+	options, sessionData, err := app.webAuthn.BeginLogin(
+		user,
+		webauthn.WithAllowedCredentials(allowed),
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+		webauthn.WithChallenge(transactionChallenge(payloadHash[:], nonce)),
+	)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to begin transaction: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if err := app.store.StoreSession(sessionID, &Session{
+		UserID:      user.ID,
+		Ceremony:    ceremonyTransaction,
+		Transaction: &PendingTransaction{Payload: payload, Nonce: nonce},
+		SessionData: *sessionData,
+	}); err != nil {
+		app.writeError(w, fmt.Sprintf("Failed to store session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, app.config.Cookies.newCookie("webauthn-session", sessionID, sessionTTL))
+
+	logger.InfoContext(r.Context(), "Transaction approval started", "payload_hash", hex.EncodeToString(payloadHash[:]))
+	json.NewEncoder(w).Encode(TransactionBeginResponse{
+		Options:     options,
+		PayloadHash: hex.EncodeToString(payloadHash[:]),
+	})
+}
+
+// handleTransactionFinish verifies the assertion started by
+// handleTransactionBegin and returns a signed TransactionApproval.
+//
+// Like reauthentication, the ceremony must belong to the user of the
+// current login session, and failed assertions count towards account
+// lockout. Approvals and failures are audited with the payload hash.
+//
+// Response: TransactionApproval
+// HTTP Status: 200 (approved), 400 (invalid session), 401 (not logged in or
+// assertion failed), 403 CREDENTIAL_SUSPENDED or CREDENTIAL_SUSPECT, 423
+// ACCOUNT_LOCKED
+func (app *App) handleTransactionFinish(w http.ResponseWriter, r *http.Request) {
+	loginSession, ok := getLoginSession(r.Context())
+	if !ok {
+		app.writeAppError(w, ErrAuthRequired, http.StatusUnauthorized)
+		return
+	}
+	sessionID, session, ok := app.ceremonySession(w, r, ceremonyTransaction)
+	if !ok {
+		return
+	}
+	if string(session.UserID) != string(loginSession.UserID) || session.Transaction == nil {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return
+	}
+
+	// The stored challenge must be the one derived from the stored payload
+	transaction := session.Transaction
+	payloadHash := sha256.Sum256(transaction.Payload)
+	challenge := base64.RawURLEncoding.EncodeToString(transactionChallenge(payloadHash[:], transaction.Nonce))
+	if session.SessionData.Challenge != challenge {
+		app.writeAppError(w, ErrInvalidSession, http.StatusBadRequest)
+		return
+	}
+
+	user, exists := app.store.GetUserByID(session.UserID)
+	if !exists {
+		app.writeError(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	logUser(r.Context(), user.Username)
+	if !app.allowUser(w, r, user.Username) {
+		return
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		app.writeError(w, fmt.Sprintf("Transaction approval failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	if !app.checkLockout(w, r, user, parsedResponse.RawID) {
+		return
+	}
+
+	credential, err := app.webAuthn.ValidateLogin(user, session.SessionData, parsedResponse)
+	if err != nil {
+		app.audit(r, AuditEvent{
+			Type:         auditTransactionApproval,
+			Username:     user.Username,
+			CredentialID: encodeCredentialID(parsedResponse.RawID),
+			Outcome:      auditFailure,
+			Detail:       assertionFailure(err),
+		})
+		app.recordLoginFailure(r, user, parsedResponse.RawID)
+		app.writeError(w, fmt.Sprintf("Transaction approval failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	logCredential(r.Context(), credential.ID)
+
+	if !app.checkCredentialStanding(w, r, ceremonyTransaction, sessionID, user, credential, parsedResponse.Response.AuthenticatorData.Counter) {
+		return
+	}
+	app.clearLoginFailures(r, user, credential.ID)
+
+	if !app.updateUserCredential(w, r, user, credential) {
+		return
+	}
+	app.store.DeleteSession(sessionID)
+
+	approval := &TransactionApproval{
+		Version:           1,
+		Username:          user.Username,
+		UserID:            base64.RawURLEncoding.EncodeToString(user.ID),
+		CredentialID:      encodeCredentialID(credential.ID),
+		Payload:           transaction.Payload,
+		PayloadHash:       hex.EncodeToString(payloadHash[:]),
+		Nonce:             base64.RawURLEncoding.EncodeToString(transaction.Nonce),
+		Challenge:         challenge,
+		ApprovedAt:        time.Now().UTC(),
+		UserVerified:      credential.Flags.UserVerified,
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(parsedResponse.Raw.AssertionResponse.AuthenticatorData),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(parsedResponse.Raw.AssertionResponse.ClientDataJSON),
+		Signature:         base64.RawURLEncoding.EncodeToString(parsedResponse.Raw.AssertionResponse.Signature),
+		KeyID:             approvalKeyID(app.approvalKey.Public().(ed25519.PublicKey)),
+	}
+	approval.ServerSignature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(app.approvalKey, approval.signingInput()))
+
+	logger.InfoContext(r.Context(), "Transaction approved", "payload_hash", approval.PayloadHash)
+	app.audit(r, AuditEvent{
+		Type:         auditTransactionApproval,
+		Actor:        user.Username,
+		Username:     user.Username,
+		CredentialID: approval.CredentialID,
+		Outcome:      auditSuccess,
+		Detail:       "payload " + approval.PayloadHash,
+	})
+	app.writeSuccess(w, "Transaction approved", approval)
+}
+
+// handleTransactionKey publishes the Ed25519 public key that signs
+// transaction approvals, so stored approvals can be checked offline.
+//
+// Response: {"keyId": ..., "algorithm": "Ed25519", "publicKey": base64}
+func (app *App) handleTransactionKey(w http.ResponseWriter, r *http.Request) {
+	public := app.approvalKey.Public().(ed25519.PublicKey)
+	json.NewEncoder(w).Encode(map[string]string{
+		"keyId":     approvalKeyID(public),
+		"algorithm": "Ed25519",
+		"publicKey": base64.StdEncoding.EncodeToString(public),
+	})
+}
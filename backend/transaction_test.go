@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string // Canonical form, or "" when an error is expected
+	}{
+		{"sorted keys", `{"payee": "Bob", "amount": "12.50"}`, `{"amount":"12.50","payee":"Bob"}`},
+		{"nested", `{"b": {"y": 1, "x": [2, 1]}, "a": null}`, `{"a":null,"b":{"x":[2,1],"y":1}}`},
+		{"numbers as written", `{"n": 1.10, "big": 12345678901234567890}`, `{"big":12345678901234567890,"n":1.10}`},
+		{"no html escaping", `{"memo": "<b>&</b>"}`, `{"memo":"<b>&</b>"}`},
+		{"repeated keys in arrays", `{"a": [{"k": 1}, {"k": 2}]}`, `{"a":[{"k":1},{"k":2}]}`},
+		{"array", `[1, 2]`, ""},
+		{"string", `"pay"`, ""},
+		{"number", `12.50`, ""},
+		{"null", `null`, ""},
+		{"duplicate key", `{"amount": "1.00", "amount": "1000.00"}`, ""},
+		{"duplicate escaped key", `{"payee": "Bob", "pay\u0065e": "Eve"}`, ""},
+		{"nested duplicate key", `{"to": {"iban": "A", "iban": "B"}}`, ""},
+		{"duplicate key in array", `{"items": [{"n": 1, "n": 2}]}`, ""},
+		{"trailing data", `{"a": 1} {"b": 2}`, ""},
+		{"invalid", `{"a": }`, ""},
+		{"too large", `{"a": "` + strings.Repeat("x", transactionMaxPayload) + `"}`, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := canonicalPayload([]byte(tc.payload))
+			switch {
+			case tc.want == "" && err == nil:
+				t.Errorf("canonicalPayload = %s, want an error", got)
+			case tc.want != "" && err != nil:
+				t.Errorf("canonicalPayload: %v", err)
+			case tc.want != "" && string(got) != tc.want:
+				t.Errorf("canonicalPayload = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransactionChallenge(t *testing.T) {
+	hash := sha256.Sum256([]byte(`{"amount":"12.50"}`))
+	nonce := []byte("0123456789abcdef")
+
+	want := sha256.Sum256(append(append([]byte(transactionChallengeContext), hash[:]...), nonce...))
+	if got := transactionChallenge(hash[:], nonce); !bytes.Equal(got, want[:]) {
+		t.Errorf("transactionChallenge = %x, want %x", got, want)
+	}
+
+	other := sha256.Sum256([]byte(`{"amount":"1250"}`))
+	if bytes.Equal(transactionChallenge(hash[:], nonce), transactionChallenge(other[:], nonce)) {
+		t.Error("different payloads share a challenge")
+	}
+	if bytes.Equal(transactionChallenge(hash[:], nonce), transactionChallenge(hash[:], []byte("another nonce"))) {
+		t.Error("different nonces share a challenge")
+	}
+}
+
+func TestTransactionApprovalSignature(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	key, err := parseTransactionKey(base64.StdEncoding.EncodeToString(seed))
+	if err != nil {
+		t.Fatalf("parseTransactionKey: %v", err)
+	}
+	public := key.Public().(ed25519.PublicKey)
+
+	approval := &TransactionApproval{
+		Version:      1,
+		Username:     "alice",
+		UserID:       "dXNlcg",
+		CredentialID: "Y3JlZA",
+		PayloadHash:  "ab12",
+		Challenge:    "Y2hhbGxlbmdl",
+		ApprovedAt:   time.Date(2026, 1, 1, 12, 0, 0, 500, time.UTC),
+	}
+	signature := ed25519.Sign(key, approval.signingInput())
+
+	// The signed text can be rebuilt from the published fields alone
+	want := "passkey-demo transaction approval v1\nalice\ndXNlcg\nY3JlZA\nab12\nY2hhbGxlbmdl\n2026-01-01T12:00:00.0000005Z"
+	if got := string(approval.signingInput()); got != want {
+		t.Errorf("signingInput = %q, want %q", got, want)
+	}
+	if !ed25519.Verify(public, approval.signingInput(), signature) {
+		t.Fatal("server signature does not verify")
+	}
+
+	// The same instant in another zone signs the same text
+	local := *approval
+	local.ApprovedAt = approval.ApprovedAt.In(time.FixedZone("CET", 3600))
+	if !ed25519.Verify(public, local.signingInput(), signature) {
+		t.Error("signature depends on the time zone of ApprovedAt")
+	}
+
+	tampered := *approval
+	tampered.PayloadHash = "cd34"
+	if ed25519.Verify(public, tampered.signingInput(), signature) {
+		t.Error("signature verifies for a different payload hash")
+	}
+
+	if id := approvalKeyID(public); len(id) != 16 {
+		t.Errorf("approvalKeyID = %q, want 16 hex characters", id)
+	}
+}
+
+func TestParseTransactionKey(t *testing.T) {
+	if key, err := parseTransactionKey(""); key != nil || err != nil {
+		t.Errorf("parseTransactionKey(\"\") = %v, %v; want nil, nil", key, err)
+	}
+	for _, value := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := parseTransactionKey(value); err == nil {
+			t.Errorf("parseTransactionKey(%q) accepted an invalid key", value)
+		}
+	}
+}
+
+// transactionBegin posts payload to handleTransactionBegin for loginSession.
+func transactionBegin(t *testing.T, app *App, loginSession *LoginSession, payload string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/transactions/begin", strings.NewReader(`{"payload": `+payload+`}`))
+	req = req.WithContext(setLoginSession(req.Context(), loginSession))
+	rec := httptest.NewRecorder()
+	app.handleTransactionBegin(rec, req)
+	return rec
+}
+
+// transactionFinish approves the transaction begun in rec with passkey.
+func transactionFinish(t *testing.T, app *App, user *User, loginSession *LoginSession, passkey *testAuthenticator, rec *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	t.Helper()
+	var begin TransactionBeginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&begin); err != nil || begin.Options == nil {
+		t.Fatalf("decode transaction options: %v", err)
+	}
+	var sessionID string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "webauthn-session" {
+			sessionID = cookie.Value
+		}
+	}
+
+	body := passkey.login(t, begin.Options.Response.Challenge.String(), user.ID)
+	req := httptest.NewRequest(http.MethodPost, "/api/transactions/finish", bytes.NewReader(body))
+	ctx := setSessionID(req.Context(), sessionID)
+	req = req.WithContext(setLoginSession(ctx, loginSession))
+	finish := httptest.NewRecorder()
+	app.handleTransactionFinish(finish, req)
+	return finish
+}
+
+func TestTransactionApproval(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Security.ClonePolicy = clonePolicyStepUp
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.approvalKey = key
+	user := createTestUser(t, app.store, "alice")
+	cloned, other := newTestAuthenticator(t), newTestAuthenticator(t)
+	cloned.enroll(t, app, user)
+	other.enroll(t, app, user)
+	loginSession := &LoginSession{ID: "token-hash", UserID: user.ID}
+
+	for _, payload := range []string{`["pay"]`, `"pay"`, `{"amount": "1.00", "amount": "1000.00"}`} {
+		if rec := transactionBegin(t, app, loginSession, payload); rec.Code != http.StatusBadRequest {
+			t.Errorf("begin with %s: status %d, want 400", payload, rec.Code)
+		}
+	}
+
+	payload := `{"payee": "Bob", "amount": "12.50"}`
+	finish := transactionFinish(t, app, user, loginSession, cloned, transactionBegin(t, app, loginSession, payload))
+	if finish.Code != http.StatusOK {
+		t.Fatalf("approval: status %d: %s", finish.Code, finish.Body)
+	}
+	var resp struct{ Data TransactionApproval }
+	if err := json.NewDecoder(finish.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	approval := resp.Data
+	signature, _ := base64.RawURLEncoding.DecodeString(approval.ServerSignature)
+	if string(approval.Payload) != `{"amount":"12.50","payee":"Bob"}` ||
+		!ed25519.Verify(key.Public().(ed25519.PublicKey), approval.signingInput(), signature) {
+		t.Errorf("approval = %+v, want the canonical payload with a valid server signature", approval)
+	}
+
+	// A copy of the key replays an old counter: refused, not stepped up
+	cloned.signCount = 0
+	finish = transactionFinish(t, app, user, loginSession, cloned, transactionBegin(t, app, loginSession, payload))
+	if finish.Code != http.StatusForbidden {
+		t.Fatalf("approval with a repeated counter: status %d, want 403: %s", finish.Code, finish.Body)
+	}
+	if resp := decodeError(t, finish); resp.Code != ErrCredentialSuspect.Code {
+		t.Errorf("error code %q, want %q", resp.Code, ErrCredentialSuspect.Code)
+	}
+	for _, cookie := range finish.Result().Cookies() {
+		if cookie.Value != "" {
+			t.Errorf("refused approval set cookie %s", cookie.Name)
+		}
+	}
+
+	// Later approvals only offer the passkey in good standing
+	rec := transactionBegin(t, app, loginSession, payload)
+	var begin TransactionBeginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &begin); err != nil {
+		t.Fatal(err)
+	}
+	if allowed := begin.Options.Response.AllowedCredentials; len(allowed) != 1 || !bytes.Equal(allowed[0].CredentialID, other.id) {
+		t.Errorf("approval offered %d passkeys, want only the one in good standing", len(allowed))
+	}
+	if finish := transactionFinish(t, app, user, loginSession, other, rec); finish.Code != http.StatusOK {
+		t.Errorf("approval with another passkey: status %d: %s", finish.Code, finish.Body)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "strings"
+
+// describeUserAgent turns a User-Agent header into a short label such as
+// "Safari on iPhone" or "Chrome on Windows".
+//
+// It is a best-effort heuristic used to suggest passkey nicknames, not a
+// full User-Agent parser. Order matters: many browsers include the tokens of
+// the engines they are based on (every Chromium browser says "Safari"), so
+// the most specific tokens are checked first.
+//
+// Returns an empty string when nothing useful can be recognised.
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return ""
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "SamsungBrowser/"):
+		browser = "Samsung Internet"
+	case strings.Contains(ua, "Firefox/") || strings.Contains(ua, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/") || strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "okhttp/"):
+		browser = "Android app"
+	case strings.Contains(ua, "CFNetwork/"):
+		browser = "iOS app"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iPhone"):
+		platform = "iPhone"
+	case strings.Contains(ua, "iPad"):
+		platform = "iPad"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Mac OS X") || strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	default:
+		return platform
+	}
+}
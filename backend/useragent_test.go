@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		ua, want string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36", "Samsung Internet on Android"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on macOS"},
+		{"okhttp/4.12.0", "Android app"},
+		{"curl/8.4.0", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := describeUserAgent(tc.ua); got != tc.want {
+			t.Errorf("describeUserAgent(%q) = %q, want %q", tc.ua, got, tc.want)
+		}
+	}
+}
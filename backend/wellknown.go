@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// wellKnownCacheControl lets platform verifiers cache association files for
+// an hour without pinning a stale copy for long after a config change.
+const wellKnownCacheControl = "public, max-age=3600"
+
+// AppleAppSiteAssociation is the /.well-known/apple-app-site-association
+// document. Sections without apps are omitted.
+//
+// See: https://developer.apple.com/documentation/xcode/supporting-associated-domains
+type AppleAppSiteAssociation struct {
+	WebCredentials *AASAApps     `json:"webcredentials,omitempty"`
+	AppLinks       *AASAAppLinks `json:"applinks,omitempty"`
+	AppClips       *AASAApps     `json:"appclips,omitempty"`
+}
+
+// AASAApps lists app IDs for the webcredentials and appclips services.
+type AASAApps struct {
+	Apps []string `json:"apps"`
+}
+
+// AASAAppLinks holds the Universal Link details per app.
+type AASAAppLinks struct {
+	Details []AASAAppLinkDetail `json:"details"`
+}
+
+// AASAAppLinkDetail maps URL components to the apps that open them.
+type AASAAppLinkDetail struct {
+	AppIDs     []string           `json:"appIDs"`
+	Components []AppLinkComponent `json:"components"`
+}
+
+// appleAppSiteAssociation builds the AASA document for the configured apps.
+func (c *Config) appleAppSiteAssociation() AppleAppSiteAssociation {
+	var aasa AppleAppSiteAssociation
+	for _, app := range c.Apple.Apps {
+		if app.WebCredentials {
+			if aasa.WebCredentials == nil {
+				aasa.WebCredentials = &AASAApps{}
+			}
+			aasa.WebCredentials.Apps = append(aasa.WebCredentials.Apps, app.AppID())
+		}
+		if len(app.AppLinks) > 0 {
+			if aasa.AppLinks == nil {
+				aasa.AppLinks = &AASAAppLinks{}
+			}
+			aasa.AppLinks.Details = append(aasa.AppLinks.Details, AASAAppLinkDetail{
+				AppIDs:     []string{app.AppID()},
+				Components: app.AppLinks,
+			})
+		}
+		if app.AppClip {
+			if aasa.AppClips == nil {
+				aasa.AppClips = &AASAApps{}
+			}
+			aasa.AppClips.Apps = append(aasa.AppClips.Apps, app.AppID())
+		}
+	}
+	return aasa
+}
+
+// handleAppleAppSiteAssociation serves the AASA file so iOS trusts the
+// configured apps with this domain's passkeys and links.
+//
+// iOS (through Apple's CDN) requires application/json with no redirects.
+//
+// HTTP Status: 200 (success), 404 (no Apple apps configured)
+func (app *App) handleAppleAppSiteAssociation(w http.ResponseWriter, r *http.Request) {
+	if len(app.config.Apple.Apps) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.Printf("🍎 AASA file requested from: %s (User-Agent: %s)", r.RemoteAddr, r.UserAgent())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", wellKnownCacheControl)
+	json.NewEncoder(w).Encode(app.config.appleAppSiteAssociation())
+}
+
+// AssetLinkStatement is one entry of /.well-known/assetlinks.json.
+//
+// See: https://developers.google.com/digital-asset-links/v1/getting-started
+type AssetLinkStatement struct {
+	Relation []string        `json:"relation"`
+	Target   AssetLinkTarget `json:"target"`
+}
+
+// AssetLinkTarget identifies an Android app by package and signing certs.
+type AssetLinkTarget struct {
+	Namespace              string   `json:"namespace"`
+	PackageName            string   `json:"package_name"`
+	SHA256CertFingerprints []string `json:"sha256_cert_fingerprints"`
+}
+
+// assetLinkRelations grant the app this site's links and, for Credential
+// Manager, its passkeys and passwords.
+var assetLinkRelations = []string{
+	"delegate_permission/common.handle_all_urls",
+	"delegate_permission/common.get_login_creds",
+}
+
+// assetLinks builds the Digital Asset Links statements for the configured
+// Android apps.
+func (c *Config) assetLinks() []AssetLinkStatement {
+	statements := make([]AssetLinkStatement, 0, len(c.Android.Apps))
+	for _, app := range c.Android.Apps {
+		statements = append(statements, AssetLinkStatement{
+			Relation: assetLinkRelations,
+			Target: AssetLinkTarget{
+				Namespace:              "android_app",
+				PackageName:            app.PackageName,
+				SHA256CertFingerprints: app.SHA256CertFingerprints,
+			},
+		})
+	}
+	return statements
+}
+
+// handleAssetLinks serves /.well-known/assetlinks.json so Android trusts the
+// configured apps to use passkeys for this RP ID.
+//
+// HTTP Status: 200 (success), 404 (no Android apps configured)
+func (app *App) handleAssetLinks(w http.ResponseWriter, r *http.Request) {
+	if len(app.config.Android.Apps) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.Printf("🤖 assetlinks.json requested from: %s (User-Agent: %s)", r.RemoteAddr, r.UserAgent())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", wellKnownCacheControl)
+	json.NewEncoder(w).Encode(app.config.assetLinks())
+}
+
+// WebAuthnRelatedOrigins is the /.well-known/webauthn document that lets
+// browsers accept this RP ID from origins on other domains (Related Origin
+// Requests).
+//
+// See: https://passkeys.dev/docs/advanced/related-origins/
+type WebAuthnRelatedOrigins struct {
+	Origins []string `json:"origins"`
+}
+
+// handleWebAuthnRelatedOrigins serves /.well-known/webauthn so browsers on
+// the configured related origins may run ceremonies for this RP ID.
+//
+// Browsers fetch it from https://<rp id> without credentials and refuse
+// redirects, so it is served directly with application/json.
+//
+// HTTP Status: 200 (success), 404 (no related origins configured)
+func (app *App) handleWebAuthnRelatedOrigins(w http.ResponseWriter, r *http.Request) {
+	if len(app.config.RelyingParty.RelatedOrigins) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	logger.Printf("🌐 webauthn related origins requested from: %s (User-Agent: %s)", r.RemoteAddr, r.UserAgent())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", wellKnownCacheControl)
+	json.NewEncoder(w).Encode(WebAuthnRelatedOrigins{Origins: app.config.RelyingParty.RelatedOrigins})
+}
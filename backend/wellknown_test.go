@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func TestHandleAppleAppSiteAssociation(t *testing.T) {
+	app := &App{config: DefaultConfig()}
+
+	rec := httptest.NewRecorder()
+	app.handleAppleAppSiteAssociation(rec, httptest.NewRequest(http.MethodGet, "/.well-known/apple-app-site-association", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without Apple apps: status = %d, want 404", rec.Code)
+	}
+
+	app.config.Apple.Apps = []AppleApp{
+		{TeamID: "ABCDE12345", BundleID: "com.passkey.demo.ios", WebCredentials: true, AppLinks: []AppLinkComponent{
+			{Path: "/profile/*"},
+			{Path: "/api/*", Exclude: true},
+		}},
+		{TeamID: "ABCDE12345", BundleID: "com.passkey.demo.ios.Clip", AppClip: true},
+	}
+	rec = httptest.NewRecorder()
+	app.handleAppleAppSiteAssociation(rec, httptest.NewRequest(http.MethodGet, "/.well-known/apple-app-site-association", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != wellKnownCacheControl {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	want := `{"webcredentials":{"apps":["ABCDE12345.com.passkey.demo.ios"]},` +
+		`"applinks":{"details":[{"appIDs":["ABCDE12345.com.passkey.demo.ios"],"components":[{"/":"/profile/*"},{"/":"/api/*","exclude":true}]}]},` +
+		`"appclips":{"apps":["ABCDE12345.com.passkey.demo.ios.Clip"]}}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandleAssetLinks(t *testing.T) {
+	app := &App{config: DefaultConfig()}
+
+	rec := httptest.NewRecorder()
+	app.handleAssetLinks(rec, httptest.NewRequest(http.MethodGet, "/.well-known/assetlinks.json", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without Android apps: status = %d, want 404", rec.Code)
+	}
+
+	app.config.Android.Apps = []AndroidApp{{PackageName: "com.passkeydemo.android", SHA256CertFingerprints: []string{testFingerprint}}}
+	rec = httptest.NewRecorder()
+	app.handleAssetLinks(rec, httptest.NewRequest(http.MethodGet, "/.well-known/assetlinks.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != wellKnownCacheControl {
+		t.Errorf("Cache-Control = %q", got)
+	}
+
+	var statements []AssetLinkStatement
+	if err := json.Unmarshal(rec.Body.Bytes(), &statements); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(statements) != 1 {
+		t.Fatalf("got %d statements, want 1", len(statements))
+	}
+	target := statements[0].Target
+	if target.Namespace != "android_app" || target.PackageName != "com.passkeydemo.android" ||
+		len(target.SHA256CertFingerprints) != 1 || target.SHA256CertFingerprints[0] != testFingerprint {
+		t.Errorf("target = %+v", target)
+	}
+	if len(statements[0].Relation) != 2 || statements[0].Relation[1] != "delegate_permission/common.get_login_creds" {
+		t.Errorf("relation = %q", statements[0].Relation)
+	}
+}
+
+func TestHandleWebAuthnRelatedOrigins(t *testing.T) {
+	app := &App{config: DefaultConfig()}
+
+	rec := httptest.NewRecorder()
+	app.handleWebAuthnRelatedOrigins(rec, httptest.NewRequest(http.MethodGet, "/.well-known/webauthn", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without related origins: status = %d, want 404", rec.Code)
+	}
+
+	app.config.RelyingParty.RelatedOrigins = []string{"https://example.de", "https://example.co.uk"}
+	rec = httptest.NewRecorder()
+	app.handleWebAuthnRelatedOrigins(rec, httptest.NewRequest(http.MethodGet, "/.well-known/webauthn", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	want := `{"origins":["https://example.de","https://example.co.uk"]}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestAndroidOriginLogin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RelyingParty.ID = testRPID
+	cfg.RelyingParty.Origins = []string{testOrigin}
+	cfg.Android.Apps = []AndroidApp{{PackageName: "com.passkeydemo.android", SHA256CertFingerprints: []string{testFingerprint}}}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: cfg.RelyingParty.DisplayName,
+		RPID:          cfg.RelyingParty.ID,
+		RPOrigins:     cfg.WebAuthnOrigins(),
+	})
+	if err != nil {
+		t.Fatalf("webauthn.New: %v", err)
+	}
+	app := &App{config: cfg, webAuthn: w, store: NewInMemoryStore()}
+	user := createTestUser(t, app.store, "alice")
+
+	// Credential Manager reports the app's signing certificate as the origin
+	passkey := newTestAuthenticator(t)
+	passkey.origin = testAndroidOrigin
+	passkey.enroll(t, app, user)
+	if rec := passkey.loginFinish(t, app, user); rec.Code != http.StatusOK {
+		t.Fatalf("login from the Android app: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// An app signed with a different certificate is not this RP's
+	passkey.origin = "android:apk-key-hash:" + b64(make([]byte, 32))
+	if rec := passkey.loginFinish(t, app, user); rec.Code == http.StatusOK {
+		t.Error("login from an unknown Android app succeeded")
+	}
+}
@@ -1,10 +1,12 @@
 import { useState, useEffect } from 'react';
 import * as api from '../services/api.js';
+import { useWebAuthn } from '../hooks/useWebAuthn.js';
 
 export default function Dashboard({ user, onLogout }) {
   const [passkeys, setPasskeys] = useState([]);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState(null);
+  const { loading: adding, addPasskey } = useWebAuthn();
   
   const serverName = window.location.hostname;
 
@@ -64,6 +66,45 @@ export default function Dashboard({ user, onLogout }) {
     }
   };
 
+  const handleRenamePasskey = async (credentialId, currentName) => {
+    const name = prompt('Name this passkey (leave empty to use the default name):', currentName);
+    if (name === null) {
+      return;
+    }
+
+    setError(null);
+
+    try {
+      await api.renamePasskey(encodeURIComponent(credentialId), name);
+      await loadPasskeys();
+    } catch (err) {
+      if (err.message.includes('Not authenticated')) {
+        setError('Session expired. Please sign in again to manage passkeys.');
+      } else {
+        setError(`Failed to rename passkey: ${err.message}`);
+      }
+    }
+  };
+
+  const handleAddPasskey = async () => {
+    setError(null);
+
+    try {
+      await addPasskey();
+      await loadPasskeys();
+    } catch (err) {
+      if (err.code === 'CREDENTIAL_EXISTS') {
+        setError('This device already has a passkey for your account.');
+      } else if (err.code === 'REAUTH_REQUIRED') {
+        setError('For your security, please sign out and sign back in before adding a passkey.');
+      } else if (err.code === 'AUTH_REQUIRED') {
+        setError('Session expired. Please sign in again to manage passkeys.');
+      } else {
+        setError(`Failed to add passkey: ${err.message}`);
+      }
+    }
+  };
+
   const handleLogout = async () => {
     try {
       await api.logout();
@@ -112,6 +153,9 @@ export default function Dashboard({ user, onLogout }) {
       <div className="header">
         <h2>Your Passkeys</h2>
         <p>Manage your registered passkeys below</p>
+        <button onClick={handleAddPasskey} disabled={adding} className="btn btn-primary btn-small">
+          {adding ? 'Adding…' : '➕ Add a passkey'}
+        </button>
       </div>
 
       {error && (
@@ -150,7 +194,13 @@ export default function Dashboard({ user, onLogout }) {
             <div key={passkey.id} className="passkey-item">
               <div className="passkey-info">
                 <h4>
-                  {getTransportIcon(passkey.transports[0])} {passkey.name}
+                  {passkey.authenticatorIconLight ? (
+                    <img
+                      src={passkey.authenticatorIconLight}
+                      alt=""
+                      style={{ width: '1.25rem', height: '1.25rem', verticalAlign: 'middle' }}
+                    />
+                  ) : getTransportIcon(passkey.transports[0])} {passkey.name}
                   {passkey.backedUp && <span style={{ marginLeft: '0.5rem' }}>☁️</span>}
                   {passkey.userVerified && <span style={{ marginLeft: '0.5rem' }}>✅</span>}
                 </h4>
@@ -161,7 +211,8 @@ export default function Dashboard({ user, onLogout }) {
                   </p>
                   <p style={{ margin: 0, color: '#666' }}>
                     Created: {formatDate(passkey.createdAt)} • 
-                    Last used: {formatDate(passkey.lastUsed)}
+                    Last used: {passkey.lastUsed ? formatDate(passkey.lastUsed) : 'Never'}
+                    {passkey.useCount > 0 && <> • Sign-ins: {passkey.useCount}</>}
                   </p>
                 </div>
 
@@ -178,6 +229,16 @@ export default function Dashboard({ user, onLogout }) {
                       <> • <strong>Sign Count:</strong> {passkey.signCount}</>
                     )}
                   </p>
+                  {passkey.authenticatorName && (
+                    <p style={{ margin: '0.125rem 0' }}>
+                      <strong>Authenticator:</strong> {passkey.authenticatorName}
+                    </p>
+                  )}
+                  {passkey.certificationLevel && (
+                    <p style={{ margin: '0.125rem 0' }}>
+                      <strong>Certification:</strong> {passkey.certificationLevel}
+                    </p>
+                  )}
                   {passkey.aaguid && (
                     <p style={{ margin: '0.125rem 0' }}>
                       <strong>AAGUID:</strong> {passkey.aaguid}
@@ -186,6 +247,11 @@ export default function Dashboard({ user, onLogout }) {
                 </div>
 
                 <div style={{ marginTop: '0.5rem', fontSize: '0.75rem' }}>
+                  {passkey.metadataFlagged && (
+                    <span style={{ color: '#d32f2f', marginRight: '1rem' }}>
+                      ⛔ Authenticator flagged by FIDO metadata ({passkey.metadataStatus})
+                    </span>
+                  )}
                   {passkey.backedUp && (
                     <span style={{ color: '#28a745', marginRight: '1rem' }}>
                       ✓ Backed up and synced
@@ -204,6 +270,13 @@ export default function Dashboard({ user, onLogout }) {
                 </div>
               </div>
               <div className="passkey-actions">
+                <button
+                  onClick={() => handleRenamePasskey(passkey.id, passkey.nickname || passkey.name)}
+                  className="btn btn-secondary btn-small"
+                  title="Give this passkey a name you will recognise"
+                >
+                  ✏️ Rename
+                </button>
                 <button
                   onClick={() => handleDeletePasskey(passkey.id, passkey.name)}
                   className="btn btn-danger btn-small"
@@ -246,8 +319,9 @@ export default function Dashboard({ user, onLogout }) {
         <h4 style={{ margin: '0 0 0.5rem 0' }}>Try These Demo Features:</h4>
         <ul style={{ margin: 0, paddingLeft: '1.5rem' }}>
           <li>Sign out and sign back in with the "Sign in with Passkey" button</li>
-          <li>Try creating additional passkeys by registering again</li>
+          <li>Add another passkey to this account with the "Add a passkey" button</li>
           <li>Test the username-based sign in flow</li>
+          <li>Rename passkeys so you can tell your devices apart</li>
           <li>Delete passkeys and see them removed from the list</li>
           <li>
             <strong>Test Deep Link Authentication:</strong> Copy your profile URL, sign out, 
@@ -252,6 +252,48 @@ export function useWebAuthn() {
     }
   }, [isSupported]);
 
+  // Add another passkey to the signed-in account
+  const addPasskey = useCallback(async () => {
+    if (!isSupported()) {
+      throw new Error('WebAuthn is not supported in this browser');
+    }
+
+    setLoading(true);
+    setError(null);
+
+    try {
+      const options = await api.addPasskeyBegin();
+
+      let credential;
+      try {
+        credential = await navigator.credentials.create({
+          publicKey: parseCredentialCreationOptions(options.publicKey),
+        });
+      } catch (createErr) {
+        // InvalidStateError means this authenticator matched excludeCredentials:
+        // report it so the server answers with CREDENTIAL_EXISTS and drops the challenge
+        if (createErr.name === 'InvalidStateError') {
+          await api.addPasskeyFinish({
+            clientError: { name: createErr.name, message: createErr.message },
+          });
+        }
+        throw createErr;
+      }
+
+      if (!credential) {
+        throw new Error('Failed to create credential');
+      }
+
+      return await api.addPasskeyFinish(formatCredentialCreationResponse(credential));
+    } catch (err) {
+      console.error('Add passkey failed:', err.name, err.message);
+      setError(err.message);
+      throw err;
+    } finally {
+      setLoading(false);
+    }
+  }, [isSupported]);
+
   // Authenticate with passkey
   const authenticate = useCallback(async (username = null) => {
     if (!isSupported()) {
@@ -362,6 +404,7 @@ export function useWebAuthn() {
     clearError,
     isSupported,
     register,
+    addPasskey,
     authenticate,
   };
 }
\ No newline at end of file
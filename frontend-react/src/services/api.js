@@ -20,7 +20,11 @@ const API_BASE = getApiBaseUrl();
 async function handleResponse(response) {
   if (!response.ok) {
     const error = await response.json().catch(() => ({ error: 'Network error' }));
-    throw new Error(error.error || `HTTP ${response.status}`);
+    const err = new Error(error.error || `HTTP ${response.status}`);
+    // Preserve the machine-readable code (e.g. USER_EXISTS, REAUTH_REQUIRED)
+    err.code = error.code;
+    err.status = response.status;
+    throw err;
   }
   return response.json();
 }
@@ -54,6 +58,20 @@ export const registerFinish = async (credential) => {
   });
 };
 
+// Add a passkey to the signed-in account
+export const addPasskeyBegin = async () => {
+  return apiRequest('/user/passkeys/register/begin', {
+    method: 'POST',
+  });
+};
+
+export const addPasskeyFinish = async (credential) => {
+  return apiRequest('/user/passkeys/register/finish', {
+    method: 'POST',
+    body: JSON.stringify(credential),
+  });
+};
+
 // Authentication API
 export const loginBegin = async (username = null) => {
   return apiRequest('/login/begin', {
@@ -80,6 +98,13 @@ export const deletePasskey = async (credentialId) => {
   });
 };
 
+export const renamePasskey = async (credentialId, name) => {
+  return apiRequest(`/user/passkeys/${credentialId}`, {
+    method: 'PATCH',
+    body: JSON.stringify({ name }),
+  });
+};
+
 export const logout = async () => {
   return apiRequest('/logout', {
     method: 'POST',
@@ -52,8 +52,8 @@ echo "🌐 Domain: $DOMAIN"
 echo "👥 Team ID: $TEAM_ID"
 echo ""
 echo "🚀 Next steps:"
-echo "1. Run: cd ../backend && ./setup-aasa.sh $TEAM_ID"
-echo "2. Start backend: source ../.env && ./passkey-backend"
+echo "1. Start backend: cd ../backend && source ../.env && PASSKEY_APPLE_APP_IDS=$TEAM_ID.com.passkey.demo.ios ./passkey-backend"
+echo "2. (The backend generates the AASA file from PASSKEY_APPLE_APP_IDS)"
 echo "3. Build and run iOS app on device (not simulator)"
 echo ""
 echo "📋 Verification:"